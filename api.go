@@ -0,0 +1,730 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// APIServer is the http delivery layer of the application. It only decodes requests,
+// calls the usecase processors and encodes their results, so that no business logic
+// leaks into the web layer.
+type APIServer struct {
+	ReqProcessor    ReqProcessor
+	PropProcessor   PropProcessor
+	OwnerProcessor  OwnerProcessor
+	TenantProcessor TenantProcessor
+	Inbox           *Inbox
+	// Auth authenticates every request, nil disables the auth and trusts the X-Tenant-ID and
+	// X-Owner-ID headers instead
+	Auth *Authenticator
+}
+
+func NewAPIServer(rP ReqProcessor, plP PropProcessor, oP OwnerProcessor, tP TenantProcessor, inbox *Inbox, auth *Authenticator) APIServer {
+	return APIServer{
+		ReqProcessor:    rP,
+		PropProcessor:   plP,
+		OwnerProcessor:  oP,
+		TenantProcessor: tP,
+		Inbox:           inbox,
+		Auth:            auth,
+	}
+}
+
+// Routes returns the http handler with all the routes of the api attached to their controllers
+func (s APIServer) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/requirements", s.handleRequirements)
+	mux.HandleFunc("/requirements/", s.handleRequirement)
+	mux.HandleFunc("/properties", s.handleProperties)
+	mux.HandleFunc("/properties/", s.handleProperty)
+	mux.HandleFunc("/owners", s.handleOwners)
+	mux.HandleFunc("/owners/", s.handleOwner)
+	mux.HandleFunc("/tenants", s.handleTenants)
+	mux.HandleFunc("/tenants/", s.handleTenant)
+	mux.HandleFunc("/inbox/", s.handleInbox)
+
+	if s.Auth == nil {
+		return withTenant(mux)
+	}
+	return s.Auth.Wrap(mux)
+}
+
+type tenantKey struct{}
+
+type ownerKey struct{}
+
+// withTenant reads the tenant a request is made for from the X-Tenant-ID header, which defaults to
+// the default tenant 0, and the owner it acts for from the X-Owner-ID header, which defaults to the
+// whole tenant, and puts them in the request context for the controllers. It is only used when the
+// auth is disabled, otherwise both come from the API key of the request.
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids := map[string]uint64{}
+		for _, name := range []string{"X-Tenant-ID", "X-Owner-ID"} {
+			if header := r.Header.Get(name); header != "" {
+				id, err := strconv.ParseUint(header, 10, 64)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, "bad "+name+" header: "+header)
+					return
+				}
+				ids[name] = id
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(withActor(r.Context(), ids["X-Tenant-ID"], ids["X-Owner-ID"])))
+	})
+}
+
+// withActor returns the context of a request made for the tenant and acting for its owner
+func withActor(ctx context.Context, tenantID, ownerID uint64) context.Context {
+	return context.WithValue(context.WithValue(ctx, tenantKey{}, tenantID), ownerKey{}, ownerID)
+}
+
+// tenantOf returns the tenant the request is made for
+func tenantOf(r *http.Request) uint64 {
+	tenantID, _ := r.Context().Value(tenantKey{}).(uint64)
+	return tenantID
+}
+
+// ownerOf returns the owner of the tenant the request acts for, 0 when it acts for the whole tenant
+func ownerOf(r *http.Request) uint64 {
+	ownerID, _ := r.Context().Value(ownerKey{}).(uint64)
+	return ownerID
+}
+
+// recordOwnerOf returns the owner of the records filed or updated by the request, ie: the owner it
+// acts for, or an error caused by ErrForbidden when the owner_id given by the client is another one
+func recordOwnerOf(r *http.Request, ownerID uint64) (uint64, error) {
+	if ownerID != 0 && ownerID != ownerOf(r) {
+		return 0, errors.Wrapf(ErrForbidden, "the request can't act for owner %d", ownerID)
+	}
+	return ownerOf(r), nil
+}
+
+// unitsOf returns the units the distances of the request are given and returned in, ie: the ones
+// of its units query parameter, eg: ?units=km, or else the ones of the match policy of its tenant
+func (s APIServer) unitsOf(r *http.Request) (DistanceUnits, error) {
+	return s.TenantProcessor.Units(tenantOf(r), r.URL.Query().Get("units"))
+}
+
+// handleRequirements adds a new requirement and responds with the matching properties
+func (s APIServer) handleRequirements(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req PropRequirement
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid requirement json: "+err.Error())
+		return
+	}
+	req.TenantID = tenantOf(r)
+	ownerID, err := recordOwnerOf(r, req.OwnerID)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	req.OwnerID = ownerID
+	units, err := s.unitsOf(r)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	req.Units = units
+
+	matches, err := s.ReqProcessor.GetMatchingProps(req)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, matches)
+}
+
+// handleProperties adds a new property listing and responds with the matching requirements
+func (s APIServer) handleProperties(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var listing PropListing
+	if err := json.NewDecoder(r.Body).Decode(&listing); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid property json: "+err.Error())
+		return
+	}
+	listing.TenantID = tenantOf(r)
+	ownerID, err := recordOwnerOf(r, listing.OwnerID)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	listing.OwnerID = ownerID
+	units, err := s.unitsOf(r)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	listing.Units = units
+
+	matches, err := s.PropProcessor.GetMatchingReqs(listing)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, matches)
+}
+
+// handleRequirement serves everything under /requirements/:
+//
+//	POST   /requirements/search       - match only (dry run), the requirement is not stored
+//	GET    /requirements/{id}/matches - re-score a stored requirement against the current properties
+//	PUT    /requirements/{id}         - update a stored requirement and respond with its fresh matches
+//	PUT    /requirements/{id}/status  - pause, fulfill or reactivate a stored requirement
+//	DELETE /requirements/{id}         - delete a stored requirement
+func (s APIServer) handleRequirement(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r.URL.Path, "/requirements/")
+	if len(parts) == 1 && parts[0] == "search" {
+		s.searchRequirement(w, r)
+		return
+	}
+
+	id, ok := parseID(parts[0])
+	switch {
+	case ok && len(parts) == 1:
+		s.updateOrDeleteRequirement(w, r, id)
+	case ok && len(parts) == 2 && parts[1] == "matches":
+		s.rematchRequirement(w, r, id)
+	case ok && len(parts) == 2 && parts[1] == "status":
+		s.setRequirementStatus(w, r, id)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s APIServer) searchRequirement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req PropRequirement
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid requirement json: "+err.Error())
+		return
+	}
+	req.TenantID = tenantOf(r)
+	ownerID, err := recordOwnerOf(r, req.OwnerID)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	req.OwnerID = ownerID
+	units, err := s.unitsOf(r)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	req.Units = units
+
+	matches, err := s.ReqProcessor.SearchProps(req)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, matches)
+}
+
+func (s APIServer) rematchRequirement(w http.ResponseWriter, r *http.Request, id uint64) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	units, err := s.unitsOf(r)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	matches, err := s.ReqProcessor.RematchRequirement(tenantOf(r), ownerOf(r), id, units)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, matches)
+}
+
+func (s APIServer) setRequirementStatus(w http.ResponseWriter, r *http.Request, id uint64) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w, http.MethodPut)
+		return
+	}
+
+	var req StatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid status json: "+err.Error())
+		return
+	}
+
+	units, err := s.unitsOf(r)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	requirement, err := s.ReqProcessor.SetRequirementStatus(tenantOf(r), ownerOf(r), id, req, units)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, requirement)
+}
+
+func (s APIServer) updateOrDeleteRequirement(w http.ResponseWriter, r *http.Request, id uint64) {
+	switch r.Method {
+	case http.MethodPut:
+		var req PropRequirement
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid requirement json: "+err.Error())
+			return
+		}
+		req.TenantID = tenantOf(r)
+		ownerID, err := recordOwnerOf(r, req.OwnerID)
+		if err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+		req.OwnerID = ownerID
+		units, err := s.unitsOf(r)
+		if err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+		req.Units = units
+		matches, err := s.ReqProcessor.UpdateRequirement(id, req)
+		if err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, matches)
+	case http.MethodDelete:
+		if err := s.ReqProcessor.DeleteRequirement(tenantOf(r), ownerOf(r), id); err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, http.MethodPut+", "+http.MethodDelete)
+	}
+}
+
+// handleProperty serves everything under /properties/:
+//
+//	POST   /properties/search       - match only (dry run), the listing is not stored
+//	GET    /properties/{id}/matches - re-score a stored listing against the current requirements
+//	PUT    /properties/{id}         - update a stored listing and respond with its fresh matches
+//	PUT    /properties/{id}/status  - pause, fulfill or reactivate a stored listing
+//	DELETE /properties/{id}         - delete a stored listing
+func (s APIServer) handleProperty(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r.URL.Path, "/properties/")
+	if len(parts) == 1 && parts[0] == "search" {
+		s.searchProperty(w, r)
+		return
+	}
+
+	id, ok := parseID(parts[0])
+	switch {
+	case ok && len(parts) == 1:
+		s.updateOrDeleteProperty(w, r, id)
+	case ok && len(parts) == 2 && parts[1] == "matches":
+		s.rematchProperty(w, r, id)
+	case ok && len(parts) == 2 && parts[1] == "status":
+		s.setPropertyStatus(w, r, id)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s APIServer) searchProperty(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var listing PropListing
+	if err := json.NewDecoder(r.Body).Decode(&listing); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid property json: "+err.Error())
+		return
+	}
+	listing.TenantID = tenantOf(r)
+	ownerID, err := recordOwnerOf(r, listing.OwnerID)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	listing.OwnerID = ownerID
+	units, err := s.unitsOf(r)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	listing.Units = units
+
+	matches, err := s.PropProcessor.SearchReqs(listing)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, matches)
+}
+
+func (s APIServer) rematchProperty(w http.ResponseWriter, r *http.Request, id uint64) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	units, err := s.unitsOf(r)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	matches, err := s.PropProcessor.RematchProperty(tenantOf(r), ownerOf(r), id, units)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, matches)
+}
+
+func (s APIServer) setPropertyStatus(w http.ResponseWriter, r *http.Request, id uint64) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w, http.MethodPut)
+		return
+	}
+
+	var req StatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid status json: "+err.Error())
+		return
+	}
+
+	property, err := s.PropProcessor.SetPropertyStatus(tenantOf(r), ownerOf(r), id, req)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, property)
+}
+
+func (s APIServer) updateOrDeleteProperty(w http.ResponseWriter, r *http.Request, id uint64) {
+	switch r.Method {
+	case http.MethodPut:
+		var listing PropListing
+		if err := json.NewDecoder(r.Body).Decode(&listing); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid property json: "+err.Error())
+			return
+		}
+		listing.TenantID = tenantOf(r)
+		ownerID, err := recordOwnerOf(r, listing.OwnerID)
+		if err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+		listing.OwnerID = ownerID
+		units, err := s.unitsOf(r)
+		if err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+		listing.Units = units
+		matches, err := s.PropProcessor.UpdateProperty(id, listing)
+		if err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, matches)
+	case http.MethodDelete:
+		if err := s.PropProcessor.DeleteProperty(tenantOf(r), ownerOf(r), id); err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, http.MethodPut+", "+http.MethodDelete)
+	}
+}
+
+// handleOwners registers a new agent or client owner and responds with it
+func (s APIServer) handleOwners(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req OwnerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid owner json: "+err.Error())
+		return
+	}
+	req.TenantID = tenantOf(r)
+
+	owner, err := s.OwnerProcessor.CreateOwner(req)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, owner)
+}
+
+// handleOwner serves everything under /owners/:
+//
+//	GET /owners/{id}              - a stored owner
+//	GET /owners/{id}/requirements - the requirements of the owner
+//	GET /owners/{id}/properties   - the property listings of the owner
+func (s APIServer) handleOwner(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	parts := pathParts(r.URL.Path, "/owners/")
+	id, ok := parseID(parts[0])
+	// a request acting for an owner only sees that owner
+	if !ok || len(parts) > 2 || (ownerOf(r) != 0 && ownerOf(r) != id) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	var result interface{}
+	var err error
+	switch {
+	case len(parts) == 1:
+		result, err = s.OwnerProcessor.GetOwner(tenantOf(r), id)
+	case parts[1] == "requirements":
+		var units DistanceUnits
+		if units, err = s.unitsOf(r); err == nil {
+			result, err = s.OwnerProcessor.ListRequirements(tenantOf(r), id, units)
+		}
+	case parts[1] == "properties":
+		result, err = s.OwnerProcessor.ListProperties(tenantOf(r), id)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleTenants registers a new tenant and responds with it
+func (s APIServer) handleTenants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req TenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid tenant json: "+err.Error())
+		return
+	}
+
+	tenant, err := s.TenantProcessor.CreateTenant(req)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, tenant)
+}
+
+// handleTenant serves everything under /tenants/:
+//
+//	GET    /tenants/{id}                      - a stored tenant
+//	PUT    /tenants/{id}/policy               - replace the match policy overrides of the tenant
+//	GET    /tenants/{id}/partners             - the tenants the tenant shares its listings with
+//	POST   /tenants/{id}/partners             - share the listings of the tenant with another tenant
+//	DELETE /tenants/{id}/partners/{partnerID} - stop sharing the listings of the tenant with the partner
+func (s APIServer) handleTenant(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r.URL.Path, "/tenants/")
+	id, ok := parseID(parts[0])
+	switch {
+	case ok && len(parts) == 1:
+		s.getTenant(w, r, id)
+	case ok && len(parts) == 2 && parts[1] == "policy":
+		s.updateTenantPolicy(w, r, id)
+	case ok && len(parts) == 2 && parts[1] == "partners":
+		s.listOrAddPartner(w, r, id)
+	case ok && len(parts) == 3 && parts[1] == "partners":
+		partnerID, ok := parseID(parts[2])
+		if !ok {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		s.removePartner(w, r, id, partnerID)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s APIServer) getTenant(w http.ResponseWriter, r *http.Request, id uint64) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	tenant, err := s.TenantProcessor.GetTenant(id)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tenant)
+}
+
+func (s APIServer) updateTenantPolicy(w http.ResponseWriter, r *http.Request, id uint64) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w, http.MethodPut)
+		return
+	}
+
+	// the body is the policy itself, eg: {"min_score": 60}, and an empty body resets it
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "couldn't read policy: "+err.Error())
+		return
+	}
+
+	tenant, err := s.TenantProcessor.UpdatePolicy(id, strings.TrimSpace(string(body)))
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tenant)
+}
+
+type partnerRequest struct {
+	PartnerTenantID uint64 `json:"partner_tenant_id"`
+}
+
+func (s APIServer) listOrAddPartner(w http.ResponseWriter, r *http.Request, id uint64) {
+	switch r.Method {
+	case http.MethodGet:
+		partners, err := s.TenantProcessor.ListPartners(id)
+		if err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, partners)
+	case http.MethodPost:
+		var req partnerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid partner json: "+err.Error())
+			return
+		}
+		agreement, err := s.TenantProcessor.Share(id, req.PartnerTenantID)
+		if err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, agreement)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet+", "+http.MethodPost)
+	}
+}
+
+func (s APIServer) removePartner(w http.ResponseWriter, r *http.Request, id, partnerID uint64) {
+	if r.Method != http.MethodDelete {
+		writeMethodNotAllowed(w, http.MethodDelete)
+		return
+	}
+
+	if err := s.TenantProcessor.Unshare(id, partnerID); err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleInbox responds with the in-app notifications of a recipient, eg: GET /inbox/requirement-42.
+// The recipients of the other tenants answer with 404 like their records.
+func (s APIServer) handleInbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	parts := pathParts(r.URL.Path, "/inbox/")
+	if len(parts) != 1 || parts[0] == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err := s.OwnerProcessor.CheckRecipient(tenantOf(r), ownerOf(r), parts[0]); err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Inbox.Messages(parts[0]))
+}
+
+// pathParts splits the part of path following prefix, eg: ["42", "matches"] for /properties/42/matches
+func pathParts(path, prefix string) []string {
+	return strings.Split(strings.Trim(strings.TrimPrefix(path, prefix), "/"), "/")
+}
+
+// parseID parses a positive numeric id
+func parseID(s string) (uint64, bool) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeProcessorError maps errors returned by the usecase processors to http status codes.
+// Validation failures, forbidden owners, missing records and bad API keys are the client's fault, anything else is a failure on our side (db etc).
+func writeProcessorError(w http.ResponseWriter, err error) {
+	switch errors.Cause(err) {
+	case ErrUnauthorized:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	case ErrForbidden:
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	case ErrValidation:
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	log.Printf("API request failed: %v", err)
+	writeError(w, http.StatusInternalServerError, "internal server error")
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("API couldn't encode response: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestWriteProcessorError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+		msg  string
+	}{
+		{"unauthorized", errors.Wrap(ErrUnauthorized, "api key is missing"), http.StatusUnauthorized, "api key is missing: unauthorized"},
+		{"forbidden", errors.Wrap(ErrForbidden, "owner 2"), http.StatusForbidden, "owner 2: forbidden"},
+		{"validation", errors.Wrap(errors.Wrap(ErrValidation, "bad price"), "PropProcessor couldn't validate"), http.StatusBadRequest, "PropProcessor couldn't validate: bad price: validation failed"},
+		{"not found", errors.Wrapf(ErrNotFound, "property %d", 7), http.StatusNotFound, "property 7: record not found"},
+		// the failures on our side don't leak their cause to the client
+		{"internal", errors.Wrap(errors.New("connection refused"), "PropProcessor couldn't insert property"), http.StatusInternalServerError, "internal server error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeProcessorError(w, tt.err)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			var body errorResponse
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("couldn't decode the body: %v", err)
+			}
+			if body.Error != tt.msg {
+				t.Errorf("error = %q, want %q", body.Error, tt.msg)
+			}
+			if auth := w.Header().Get("WWW-Authenticate"); (auth == "Bearer") != (tt.want == http.StatusUnauthorized) {
+				t.Errorf("WWW-Authenticate = %q for a %d", auth, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// apiKeyPrefixLen is the number of leading characters of a key kept in clear as its Prefix
+	apiKeyPrefixLen = 12
+	// minAPIKeyLen is the length of the shortest key which can be imported, ie: 128 bits of hex
+	minAPIKeyLen = 32
+)
+
+// KeyProcessor is the usecase interactor for the API keys, issuing and revoking them for the
+// admin cli and authenticating the requests of the api
+type KeyProcessor struct {
+	KeyRepo    APIKeyRepository
+	TenantRepo TenantRepository
+	OwnerRepo  OwnerRepository
+}
+
+func NewKeyProcessor(keyRepo APIKeyRepository, tenantRepo TenantRepository, ownerRepo OwnerRepository) KeyProcessor {
+	return KeyProcessor{
+		KeyRepo:    keyRepo,
+		TenantRepo: tenantRepo,
+		OwnerRepo:  ownerRepo,
+	}
+}
+
+// IssueKey usecase creates a new key of the tenant, acting for one of its owners or for the whole
+// tenant when ownerID is 0, and returns it as stored along with the key itself, which is not
+// stored anywhere and can't be shown again
+func (kP KeyProcessor) IssueKey(tenantID, ownerID uint64, name string, scopes []string, rateLimit int) (APIKey, string, error) {
+	err := kP.validate(tenantID, ownerID, name, scopes, rateLimit)
+	if err != nil {
+		return APIKey{}, "", errors.Wrap(err, "KeyProcessor couldn't validate")
+	}
+
+	secret, err := generateAPIKey()
+	if err != nil {
+		return APIKey{}, "", errors.Wrap(err, "KeyProcessor couldn't generate key")
+	}
+
+	key, err := kP.addToDB(tenantID, ownerID, name, secret, scopes, rateLimit)
+	return key, secret, err
+}
+
+// ImportKey usecase stores a key chosen by the operator of the deployment instead of a generated
+// one, eg: the key of the memory store given in the config. The key must be as hard to guess as a
+// generated one, ie: at least minAPIKeyLen characters long.
+func (kP KeyProcessor) ImportKey(tenantID, ownerID uint64, name, secret string, scopes []string, rateLimit int) (APIKey, error) {
+	err := kP.validate(tenantID, ownerID, name, scopes, rateLimit)
+	if err != nil {
+		return APIKey{}, errors.Wrap(err, "KeyProcessor couldn't validate")
+	}
+	if len(secret) < minAPIKeyLen {
+		return APIKey{}, errors.Wrapf(ErrValidation, "api key is too short, must have %d characters at least", minAPIKeyLen)
+	}
+	return kP.addToDB(tenantID, ownerID, name, secret, scopes, rateLimit)
+}
+
+// RevokeKey usecase revokes a key, the requests using it are rejected from then on
+func (kP KeyProcessor) RevokeKey(id uint64) error {
+	err := kP.KeyRepo.Revoke(id, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "KeyProcessor couldn't revoke key")
+	}
+	return nil
+}
+
+// ListKeys usecase returns the keys of a tenant, including the revoked ones
+func (kP KeyProcessor) ListKeys(tenantID uint64) ([]APIKey, error) {
+	keys, err := kP.KeyRepo.FindByTenant(tenantID)
+	if err != nil {
+		return keys, errors.Wrap(err, "KeyProcessor couldn't find keys")
+	}
+	return keys, nil
+}
+
+// Authenticate usecase returns the stored key of a request, or an error caused by ErrUnauthorized
+// when the key is missing, unknown or revoked
+func (kP KeyProcessor) Authenticate(secret string) (APIKey, error) {
+	if secret == "" {
+		return APIKey{}, errors.Wrap(ErrUnauthorized, "api key is missing")
+	}
+
+	key, err := kP.KeyRepo.FindByHash(hashAPIKey(secret))
+	if errors.Cause(err) == ErrNotFound {
+		return key, errors.Wrap(ErrUnauthorized, "unknown api key")
+	}
+	if err != nil {
+		return key, errors.Wrap(err, "KeyProcessor couldn't find key")
+	}
+	if key.RevokedAt != nil {
+		return key, errors.Wrapf(ErrUnauthorized, "api key %s... is revoked", key.Prefix)
+	}
+	return key, nil
+}
+
+// validate returns an error caused by ErrValidation describing the first bad field of the key
+func (kP KeyProcessor) validate(tenantID, ownerID uint64, name string, scopes []string, rateLimit int) error {
+	if name == "" {
+		return errors.Wrap(ErrValidation, "key name is missing")
+	}
+	if len(scopes) == 0 {
+		return errors.Wrap(ErrValidation, "key scopes are missing")
+	}
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return errors.Wrapf(ErrValidation, "bad scope: %q, must be one of %v", scope, Scopes)
+		}
+		// the tenants are managed by the operator of the deployment, not by the tenants themselves
+		if scope == ScopeAdmin && tenantID != 0 {
+			return errors.Wrapf(ErrValidation, "only the keys of the default tenant can have the %q scope", ScopeAdmin)
+		}
+	}
+	if rateLimit < 0 {
+		return errors.Wrapf(ErrValidation, "bad rate limit: %d", rateLimit)
+	}
+	if err := validTenant(kP.TenantRepo, tenantID); err != nil {
+		return err
+	}
+	return validOwner(kP.OwnerRepo, tenantID, ownerID)
+}
+
+// addToDB stores the hash of a new key, along with its prefix in clear
+func (kP KeyProcessor) addToDB(tenantID, ownerID uint64, name, secret string, scopes []string, rateLimit int) (APIKey, error) {
+	key := NewAPIKey(tenantID, ownerID, name, secret[:apiKeyPrefixLen], hashAPIKey(secret), scopes, rateLimit)
+	err := kP.KeyRepo.Save(key)
+	if err != nil {
+		log.Printf("KeyProcessor unable to insert key: (name: %s, err: %v)", name, err)
+		return *key, errors.Wrap(err, "KeyProcessor couldn't insert key")
+	}
+	return *key, nil
+}
+
+// generateAPIKey returns a new random key, eg: "rem_3f9a...", with 192 bits of entropy
+func generateAPIKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "rem_" + hex.EncodeToString(b), nil
+}
+
+// hashAPIKey returns the hex sha256 of a key. The keys are random enough for a plain hash, unlike
+// passwords they don't need a slow salted one.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PropertyTypeApartment = "apartment"
+	PropertyTypeHouse     = "house"
+	PropertyTypeTownhouse = "townhouse"
+	PropertyTypeStudio    = "studio"
+	PropertyTypeVilla     = "villa"
+)
+
+// PropertyTypes are all the types a Property can be of
+var PropertyTypes = []string{PropertyTypeApartment, PropertyTypeHouse, PropertyTypeTownhouse, PropertyTypeStudio, PropertyTypeVilla}
+
+const (
+	FurnishingUnfurnished   = "unfurnished"
+	FurnishingSemiFurnished = "semi_furnished"
+	FurnishingFurnished     = "furnished"
+)
+
+// Furnishings are all the furnishings a Property can have
+var Furnishings = []string{FurnishingUnfurnished, FurnishingSemiFurnished, FurnishingFurnished}
+
+const (
+	AmenityPetsAllowed     = "pets_allowed"
+	AmenityGym             = "gym"
+	AmenityPool            = "pool"
+	AmenityElevator        = "elevator"
+	AmenityBalcony         = "balcony"
+	AmenityGarden          = "garden"
+	AmenitySecurity        = "security"
+	AmenityAirConditioning = "air_conditioning"
+)
+
+// Amenities are all the amenities a Property can have
+var Amenities = []string{AmenityPetsAllowed, AmenityGym, AmenityPool, AmenityElevator, AmenityBalcony, AmenityGarden, AmenitySecurity, AmenityAirConditioning}
+
+// PropertyAttributes are the optional details of a listing on top of its price and rooms. The
+// empty PropertyType and Furnishing and the 0 Area mean unknown, eg: for the listings stored
+// before the attributes existed, and never keep a listing out of the base filtering.
+type PropertyAttributes struct {
+	// PropertyType is one of PropertyTypes
+	PropertyType string `json:"property_type"`
+	// Area is the floor area in square feet
+	Area          float32 `json:"area"`
+	ParkingSpaces uint16  `json:"parking_spaces"`
+	// Furnishing is one of Furnishings
+	Furnishing string     `json:"furnishing"`
+	Amenities  AmenitySet `json:"amenities"`
+}
+
+// RequirementAttributes are the optional asks of a requirement about the PropertyAttributes of the
+// listings. PropertyTypes, Furnishings, the area range, MinParking and MustHaveAmenities are part
+// of the base filtering, while NiceToHaveAmenities only add to the score of the V3 algorithms.
+// The empty sets and the 0 numbers mean no ask.
+type RequirementAttributes struct {
+	// PropertyTypes are the accepted property types
+	PropertyTypes PropertyTypeSet `json:"property_types"`
+	// MinArea and MaxArea are in square feet, either of them can be missing (0)
+	MinArea    float32 `json:"min_area"`
+	MaxArea    float32 `json:"max_area"`
+	MinParking uint16  `json:"min_parking_spaces"`
+	// Furnishings are the accepted furnishings
+	Furnishings         FurnishingSet `json:"furnishings"`
+	MustHaveAmenities   AmenitySet    `json:"must_have_amenities"`
+	NiceToHaveAmenities AmenitySet    `json:"nice_to_have_amenities"`
+}
+
+// PropertyTypeSet is a set of PropertyTypes, stored as a bitmask where the bit i is set when the
+// i-th of the PropertyTypes is in the set, so that the sql stores can match it with a bitwise and.
+// It is a list of names in JSON, eg: ["apartment", "studio"].
+type PropertyTypeSet uint32
+
+// FurnishingSet is a set of Furnishings, stored like a PropertyTypeSet
+type FurnishingSet uint32
+
+// AmenitySet is a set of Amenities, stored like a PropertyTypeSet
+type AmenitySet uint32
+
+// PropertyTypeSetOf returns the set of a single property type, which is empty for an unknown type
+func PropertyTypeSetOf(propertyType string) PropertyTypeSet {
+	return PropertyTypeSet(attributeBit(propertyType, PropertyTypes))
+}
+
+func (s PropertyTypeSet) Has(propertyType string) bool {
+	bit := PropertyTypeSetOf(propertyType)
+	return bit != 0 && s&bit != 0
+}
+
+// Names returns the property types in the set
+func (s PropertyTypeSet) Names() []string {
+	return attributeNames(uint32(s), PropertyTypes)
+}
+
+// orAll returns the set, or the set of all the property types when it is empty
+func (s PropertyTypeSet) orAll() PropertyTypeSet {
+	if s == 0 {
+		return PropertyTypeSet(allAttributes(PropertyTypes))
+	}
+	return s
+}
+
+func (s PropertyTypeSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Names())
+}
+
+func (s *PropertyTypeSet) UnmarshalJSON(data []byte) error {
+	bits, err := parseAttributeSet(data, PropertyTypes, "property type")
+	*s = PropertyTypeSet(bits)
+	return err
+}
+
+// FurnishingSetOf returns the set of a single furnishing, which is empty for an unknown furnishing
+func FurnishingSetOf(furnishing string) FurnishingSet {
+	return FurnishingSet(attributeBit(furnishing, Furnishings))
+}
+
+func (s FurnishingSet) Has(furnishing string) bool {
+	bit := FurnishingSetOf(furnishing)
+	return bit != 0 && s&bit != 0
+}
+
+// Names returns the furnishings in the set
+func (s FurnishingSet) Names() []string {
+	return attributeNames(uint32(s), Furnishings)
+}
+
+// orAll returns the set, or the set of all the furnishings when it is empty
+func (s FurnishingSet) orAll() FurnishingSet {
+	if s == 0 {
+		return FurnishingSet(allAttributes(Furnishings))
+	}
+	return s
+}
+
+func (s FurnishingSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Names())
+}
+
+func (s *FurnishingSet) UnmarshalJSON(data []byte) error {
+	bits, err := parseAttributeSet(data, Furnishings, "furnishing")
+	*s = FurnishingSet(bits)
+	return err
+}
+
+// Names returns the amenities in the set
+func (s AmenitySet) Names() []string {
+	return attributeNames(uint32(s), Amenities)
+}
+
+// Contains tells if all the amenities of other are in the set
+func (s AmenitySet) Contains(other AmenitySet) bool {
+	return s&other == other
+}
+
+func (s AmenitySet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Names())
+}
+
+func (s *AmenitySet) UnmarshalJSON(data []byte) error {
+	bits, err := parseAttributeSet(data, Amenities, "amenity")
+	*s = AmenitySet(bits)
+	return err
+}
+
+// attributeBit returns the bit of the value in the set of the attribute having the given values,
+// 0 for an empty or unknown value
+func attributeBit(value string, values []string) uint32 {
+	for i, v := range values {
+		if v == value {
+			return 1 << uint(i)
+		}
+	}
+	return 0
+}
+
+func allAttributes(values []string) uint32 {
+	return 1<<uint(len(values)) - 1
+}
+
+func attributeNames(bits uint32, values []string) []string {
+	names := []string{}
+	for i, v := range values {
+		if bits&(1<<uint(i)) != 0 {
+			names = append(names, v)
+		}
+	}
+	return names
+}
+
+// parseAttributeSet parses a JSON list of values of an attribute into its set, or returns an
+// error caused by ErrValidation for an unknown value
+func parseAttributeSet(data []byte, values []string, noun string) (uint32, error) {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return 0, err
+	}
+
+	var bits uint32
+	for _, name := range names {
+		bit := attributeBit(name, values)
+		if bit == 0 {
+			return 0, errors.Wrapf(ErrValidation, "bad %s: %q, must be one of %s", noun, name, strings.Join(values, ", "))
+		}
+		bits |= bit
+	}
+	return bits, nil
+}
+
+// AttributeWindows are the attribute conditions of the base filtering query, the counterpart of
+// ReqMargins for the PropertyAttributes and RequirementAttributes. The properties are candidates
+// when their attributes fall inside the windows, the requirements when their asks overlap them.
+type AttributeWindows struct {
+	PropertyTypes PropertyTypeSet
+	Furnishings   FurnishingSet
+	MinArea       float32
+	MaxArea       float32
+	MinParking    uint16
+	MaxParking    uint16
+	// Amenities are the must have amenities of a requirement, the candidate properties have all of
+	// them, or the amenities of a listing, the candidate requirements must have none but them
+	Amenities AmenitySet
+}
+
+func NewAttributeWindows(propertyTypes PropertyTypeSet, furnishings FurnishingSet, minArea, maxArea float32, minParking, maxParking uint16, amenities AmenitySet) AttributeWindows {
+	return AttributeWindows{
+		PropertyTypes: propertyTypes,
+		Furnishings:   furnishings,
+		MinArea:       minArea,
+		MaxArea:       maxArea,
+		MinParking:    minParking,
+		MaxParking:    maxParking,
+		Amenities:     amenities,
+	}
+}
+
+// RequirementAttributeWindows returns the windows of the properties meeting the asks of a
+// requirement, the area range being widened by the fraction margin
+func RequirementAttributeWindows(r RequirementAttributes, margin float32) AttributeWindows {
+	minArea, maxArea := float32(0), float32(math.MaxFloat32)
+	if r.MinArea > 0 {
+		minArea = r.MinArea - (margin * r.MinArea)
+	}
+	if r.MaxArea > 0 {
+		maxArea = r.MaxArea + (margin * r.MaxArea)
+	}
+	return NewAttributeWindows(r.PropertyTypes.orAll(), r.Furnishings.orAll(), minArea, maxArea, r.MinParking, math.MaxUint16, r.MustHaveAmenities)
+}
+
+// PropertyAttributeWindows returns the windows of the requirements a listing meets the asks of,
+// the counterpart of RequirementAttributeWindows. The unknown attributes of the listing meet any ask.
+func PropertyAttributeWindows(p PropertyAttributes, margin float32) AttributeWindows {
+	propertyTypes := PropertyTypeSetOf(p.PropertyType).orAll()
+	furnishings := FurnishingSetOf(p.Furnishing).orAll()
+
+	// the min area of a requirement can be up to area/(1-margin) and its max area down to area/(1+margin)
+	minArea, maxArea := float32(0), float32(math.MaxFloat32)
+	if p.Area > 0 {
+		minArea, maxArea = p.Area/(1+margin), p.Area/(1-margin)
+	}
+	return NewAttributeWindows(propertyTypes, furnishings, minArea, maxArea, 0, p.ParkingSpaces, p.Amenities)
+}
+
+// propAttributesWithin is the go equivalent of the attribute conditions of the base filtering query
+// on the properties table
+func propAttributesWithin(p PropertyAttributes, w AttributeWindows) bool {
+	return (p.PropertyType == "" || w.PropertyTypes.Has(p.PropertyType)) &&
+		(p.Furnishing == "" || w.Furnishings.Has(p.Furnishing)) &&
+		(p.Area == 0 || (p.Area >= w.MinArea && p.Area <= w.MaxArea)) &&
+		p.ParkingSpaces >= w.MinParking && p.ParkingSpaces <= w.MaxParking &&
+		p.Amenities.Contains(w.Amenities)
+}
+
+// reqAttributesOverlap is the go equivalent of the attribute conditions of the base filtering query
+// on the requirements table
+func reqAttributesOverlap(r RequirementAttributes, w AttributeWindows) bool {
+	return (r.PropertyTypes == 0 || r.PropertyTypes&w.PropertyTypes != 0) &&
+		(r.Furnishings == 0 || r.Furnishings&w.Furnishings != 0) &&
+		(r.MinArea == 0 || r.MinArea <= w.MaxArea) &&
+		(r.MaxArea == 0 || r.MaxArea >= w.MinArea) &&
+		r.MinParking >= w.MinParking && r.MinParking <= w.MaxParking &&
+		w.Amenities.Contains(r.MustHaveAmenities)
+}
+
+func validPropertyAttributes(p PropertyAttributes) error {
+	if p.PropertyType != "" && PropertyTypeSetOf(p.PropertyType) == 0 {
+		return errors.Wrapf(ErrValidation, "bad property type: %q, must be one of %s", p.PropertyType, strings.Join(PropertyTypes, ", "))
+	}
+	if p.Furnishing != "" && FurnishingSetOf(p.Furnishing) == 0 {
+		return errors.Wrapf(ErrValidation, "bad furnishing: %q, must be one of %s", p.Furnishing, strings.Join(Furnishings, ", "))
+	}
+	if p.Area < 0 {
+		return errors.Wrapf(ErrValidation, "bad area: %f", p.Area)
+	}
+	return nil
+}
+
+func validRequirementAttributes(r RequirementAttributes) error {
+	if r.MinArea < 0 || r.MaxArea < 0 || (r.MaxArea > 0 && r.MinArea > r.MaxArea) {
+		return errors.Wrapf(ErrValidation, "bad area range min: %f - max: %f", r.MinArea, r.MaxArea)
+	}
+	if r.MustHaveAmenities&r.NiceToHaveAmenities != 0 {
+		return errors.Wrapf(ErrValidation, "amenities can't be both must have and nice to have: %s",
+			strings.Join((r.MustHaveAmenities&r.NiceToHaveAmenities).Names(), ", "))
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Authenticator is the auth layer of the api. Every request must carry an API key, either as
+// "Authorization: Bearer <key>" or in the X-API-Key header, having the scope needed by the route,
+// and stay within the rate limit of the key. Rejected requests never reach the usecase processors.
+// The tenant of the key is the tenant the request is made for, and the owner of the key, if any, is
+// the owner it acts for.
+type Authenticator struct {
+	Keys    KeyProcessor
+	Limiter *RateLimiter
+}
+
+func NewAuthenticator(keys KeyProcessor, limiter *RateLimiter) *Authenticator {
+	return &Authenticator{
+		Keys:    keys,
+		Limiter: limiter,
+	}
+}
+
+// Wrap returns the handler which authenticates and authorizes the requests before passing them to next
+func (a *Authenticator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := a.Keys.Authenticate(apiKeyOf(r))
+		if err == nil {
+			err = authorize(key, requiredScope(r))
+		}
+		if err != nil {
+			writeProcessorError(w, err)
+			return
+		}
+
+		if ok, retryAfter := a.Limiter.Allow(key.KeyID, key.RateLimit, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+1)))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withActor(r.Context(), key.TenantID, key.OwnerID)))
+	})
+}
+
+// apiKeyOf returns the API key of a request, empty if it has none
+func apiKeyOf(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// authorize returns an error caused by ErrForbidden if the key lacks the scope
+func authorize(key APIKey, scope string) error {
+	if scope == "" || key.HasScope(scope) {
+		return nil
+	}
+	return errors.Wrapf(ErrForbidden, "api key %s... lacks the %q scope", key.Prefix, scope)
+}
+
+// requiredScope returns the scope needed by the route of the request, see APIServer.Routes.
+// The dry runs and re-scores only read matches, so they need ScopeMatchesRead and not the write scopes.
+func requiredScope(r *http.Request) string {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	readsMatches := len(parts) > 1 && (parts[1] == "search" || parts[len(parts)-1] == "matches")
+
+	switch parts[0] {
+	case "requirements":
+		if readsMatches {
+			return ScopeMatchesRead
+		}
+		return ScopeRequirementsWrite
+	case "properties":
+		if readsMatches {
+			return ScopeMatchesRead
+		}
+		return ScopePropertiesWrite
+	case "owners":
+		if r.Method == http.MethodPost {
+			return ScopeOwnersWrite
+		}
+		return ScopeMatchesRead
+	case "inbox":
+		return ScopeMatchesRead
+	case "tenants":
+		return ScopeAdmin
+	default:
+		return ""
+	}
+}
+
+// RateLimiter counts the requests of every API key in fixed windows of Window, eg: a minute. The
+// windows which are over are swept once per Window, so that the revoked and idle keys don't pile up.
+type RateLimiter struct {
+	Window time.Duration
+
+	mu        sync.Mutex
+	windows   map[uint64]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func NewRateLimiter(window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Window:  window,
+		windows: make(map[uint64]*rateWindow),
+	}
+}
+
+// Allow records a request of the key at time now if it is within limit requests per Window (0
+// means no limit), otherwise it returns false along with the time left until the next window
+func (l *RateLimiter) Allow(keyID uint64, limit int, now time.Time) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= l.Window {
+		l.sweep(now)
+	}
+	w, ok := l.windows[keyID]
+	if !ok || now.Sub(w.start) >= l.Window {
+		w = &rateWindow{start: now}
+		l.windows[keyID] = w
+	}
+	if w.count >= limit {
+		return false, w.start.Add(l.Window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// sweep drops the windows which are over at time now, a request of their key starts a new one anyway
+func (l *RateLimiter) sweep(now time.Time) {
+	for keyID, w := range l.windows {
+		if now.Sub(w.start) >= l.Window {
+			delete(l.windows, keyID)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         string
+	}{
+		{http.MethodPost, "/requirements", ScopeRequirementsWrite},
+		{http.MethodPut, "/requirements/1", ScopeRequirementsWrite},
+		{http.MethodPost, "/requirements/search", ScopeMatchesRead},
+		{http.MethodPost, "/requirements/1/matches", ScopeMatchesRead},
+		{http.MethodPost, "/properties", ScopePropertiesWrite},
+		{http.MethodDelete, "/properties/1", ScopePropertiesWrite},
+		{http.MethodPost, "/properties/search", ScopeMatchesRead},
+		{http.MethodPost, "/properties/1/matches", ScopeMatchesRead},
+		{http.MethodPost, "/owners", ScopeOwnersWrite},
+		{http.MethodGet, "/owners/1", ScopeMatchesRead},
+		{http.MethodGet, "/inbox/owner-1", ScopeMatchesRead},
+		{http.MethodGet, "/tenants", ScopeAdmin},
+		{http.MethodPost, "/tenants/1/agreements", ScopeAdmin},
+		{http.MethodGet, "/health", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if got := requiredScope(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+				t.Errorf("requiredScope(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorWrap(t *testing.T) {
+	keys := NewKeyProcessor(NewMemoryAPIKeyRepo(), NewMemoryTenantRepo(), NewMemoryOwnerRepo())
+	issue := func(name string, scopes []string, rateLimit int) string {
+		_, secret, err := keys.IssueKey(0, 0, name, scopes, rateLimit)
+		if err != nil {
+			t.Fatalf("couldn't issue the %s key: %v", name, err)
+		}
+		return secret
+	}
+	writer := issue("writer", []string{ScopePropertiesWrite, ScopeMatchesRead}, 0)
+	reader := issue("reader", []string{ScopeMatchesRead}, 0)
+	admin := issue("admin", []string{ScopeAdmin}, 0)
+	limited := issue("limited", []string{ScopeMatchesRead}, 1)
+	revoked, revokedSecret, err := keys.IssueKey(0, 0, "revoked", []string{ScopeMatchesRead}, 0)
+	if err != nil {
+		t.Fatalf("couldn't issue the revoked key: %v", err)
+	}
+	if err := keys.RevokeKey(revoked.KeyID); err != nil {
+		t.Fatalf("couldn't revoke the key: %v", err)
+	}
+
+	handler := NewAuthenticator(keys, NewRateLimiter(time.Minute)).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := []struct {
+		name         string
+		method, path string
+		header, key  string
+		want         int
+	}{
+		{"missing key", http.MethodPost, "/properties", "", "", http.StatusUnauthorized},
+		{"unknown key", http.MethodPost, "/properties", "X-API-Key", "rem_unknown", http.StatusUnauthorized},
+		{"revoked key", http.MethodPost, "/properties/search", "X-API-Key", revokedSecret, http.StatusUnauthorized},
+		{"bearer key", http.MethodPost, "/properties", "Authorization", "Bearer " + writer, http.StatusOK},
+		{"header key", http.MethodPost, "/properties", "X-API-Key", writer, http.StatusOK},
+		{"wrong scope", http.MethodPost, "/properties", "X-API-Key", reader, http.StatusForbidden},
+		{"read scope", http.MethodPost, "/properties/search", "X-API-Key", reader, http.StatusOK},
+		{"tenants without admin", http.MethodGet, "/tenants", "X-API-Key", writer, http.StatusForbidden},
+		{"tenants with admin", http.MethodGet, "/tenants", "X-API-Key", admin, http.StatusOK},
+		{"admin only", http.MethodPost, "/properties", "X-API-Key", admin, http.StatusForbidden},
+		{"within rate limit", http.MethodGet, "/inbox/owner-1", "X-API-Key", limited, http.StatusOK},
+		{"over rate limit", http.MethodGet, "/inbox/owner-1", "X-API-Key", limited, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.key)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		keyID      uint64
+		limit      int
+		after      time.Duration
+		want       bool
+		retryAfter time.Duration
+	}{
+		{"first request", 1, 2, 0, true, 0},
+		{"within limit", 1, 2, 10 * time.Second, true, 0},
+		{"over limit", 1, 2, 20 * time.Second, false, 40 * time.Second},
+		{"other key", 2, 2, 20 * time.Second, true, 0},
+		{"no limit", 3, 0, 20 * time.Second, true, 0},
+		{"still over limit", 1, 2, 59 * time.Second, false, time.Second},
+		{"next window", 1, 2, time.Minute, true, 0},
+		{"over limit again", 1, 1, time.Minute + time.Second, false, 59 * time.Second},
+	}
+	l := NewRateLimiter(time.Minute)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, retryAfter := l.Allow(tt.keyID, tt.limit, start.Add(tt.after))
+			if ok != tt.want || retryAfter != tt.retryAfter {
+				t.Errorf("Allow(%d) after %v = (%v, %v), want (%v, %v)", tt.keyID, tt.after, ok, retryAfter, tt.want, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(time.Minute)
+	for keyID := uint64(1); keyID <= 3; keyID++ {
+		l.Allow(keyID, 1, start.Add(time.Duration(keyID)*time.Second))
+	}
+
+	// the windows which are over are dropped by the first request a Window after the last sweep
+	l.Allow(4, 1, start.Add(time.Minute+2*time.Second))
+	if len(l.windows) != 2 {
+		t.Errorf("%d windows after the sweep, want the ones of the keys 3 and 4", len(l.windows))
+	}
+	for _, keyID := range []uint64{3, 4} {
+		if _, ok := l.windows[keyID]; !ok {
+			t.Errorf("the window of the key %d was swept", keyID)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const cliUsage = `usage:
+  matcher                          start the api server
+  matcher keys issue [flags]       issue an api key, -h for the flags
+  matcher keys revoke -id <id>     revoke an api key
+  matcher keys list -tenant <id>   list the api keys of a tenant
+`
+
+// runCommand runs the admin command given by args, eg: "keys issue -tenant 1 -name crm", against
+// the configured store and returns the exit code of the process
+func runCommand(cfg Config, args []string, out io.Writer) int {
+	if len(args) < 2 || args[0] != "keys" {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	if cfg.Store == "memory" {
+		fmt.Fprintln(os.Stderr, "the api keys of the memory store only live as long as the server, it logs one on startup")
+		return 1
+	}
+
+	repos := newRepositories(cfg)
+	keys := NewKeyProcessor(repos.APIKeys, repos.Tenants, repos.Owners)
+
+	var err error
+	switch args[1] {
+	case "issue":
+		err = issueKeyCommand(keys, args[2:], out)
+	case "revoke":
+		err = revokeKeyCommand(keys, args[2:], out)
+	case "list":
+		err = listKeysCommand(keys, args[2:], out)
+	default:
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func issueKeyCommand(keys KeyProcessor, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("keys issue", flag.ExitOnError)
+	tenantID := flags.Uint64("tenant", 0, "the tenant the key acts for, 0 is the default tenant")
+	ownerID := flags.Uint64("owner", 0, "the owner of the tenant the key acts for, 0 acts for the whole tenant")
+	name := flags.String("name", "", "what the key is used for, eg: \"crm sync\"")
+	scopes := flags.String("scopes", ScopeMatchesRead, "comma separated scopes, any of "+strings.Join(Scopes, ","))
+	rateLimit := flags.Int("rate-limit", 60, "max requests per minute, 0 means no limit")
+	flags.Parse(args)
+
+	key, secret, err := keys.IssueKey(*tenantID, *ownerID, *name, strings.Split(*scopes, ","), *rateLimit)
+	if err != nil {
+		return err
+	}
+	if key.OwnerID != 0 {
+		fmt.Fprintf(out, "issued key %d %q for owner %d of tenant %d with scopes %q\n", key.KeyID, key.Name, key.OwnerID, key.TenantID, key.Scopes)
+	} else {
+		fmt.Fprintf(out, "issued key %d %q for tenant %d with scopes %q\n", key.KeyID, key.Name, key.TenantID, key.Scopes)
+	}
+	fmt.Fprintf(out, "%s\n", secret)
+	fmt.Fprintln(out, "the key is not stored and can't be shown again")
+	return nil
+}
+
+func revokeKeyCommand(keys KeyProcessor, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("keys revoke", flag.ExitOnError)
+	id := flags.Uint64("id", 0, "the id of the key to revoke")
+	flags.Parse(args)
+
+	if err := keys.RevokeKey(*id); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "revoked key %d\n", *id)
+	return nil
+}
+
+func listKeysCommand(keys KeyProcessor, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("keys list", flag.ExitOnError)
+	tenantID := flags.Uint64("tenant", 0, "the tenant whose keys to list")
+	flags.Parse(args)
+
+	list, err := keys.ListKeys(*tenantID)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPREFIX\tNAME\tOWNER\tSCOPES\tRATE LIMIT\tADDED\tREVOKED")
+	for _, k := range list {
+		revoked := "-"
+		if k.RevokedAt != nil {
+			revoked = k.RevokedAt.Format("2006-01-02 15:04")
+		}
+		owner := "-"
+		if k.OwnerID != 0 {
+			owner = strconv.FormatUint(k.OwnerID, 10)
+		}
+		fmt.Fprintf(tw, "%d\t%s...\t%s\t%s\t%s\t%d/min\t%s\t%s\n", k.KeyID, k.Prefix, k.Name, owner, k.Scopes, k.RateLimit, k.AddedDate.Format("2006-01-02 15:04"), revoked)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ConstraintBudget    = "budget"
+	ConstraintBedrooms  = "bedrooms"
+	ConstraintBathrooms = "bathrooms"
+	ConstraintArea      = "area"
+)
+
+// Constraints are all the fields of a requirement which can be marked as hard constraints
+var Constraints = []string{ConstraintBudget, ConstraintBedrooms, ConstraintBathrooms, ConstraintArea}
+
+// ConstraintSet is the set of the fields a requirement marked as hard constraints, ie: deal-breakers.
+// A listing outside the bounds of a hard field is never a match, instead of scoring less the further
+// out it is like for the soft ones. It is stored like a PropertyTypeSet, eg: ["budget", "bedrooms"].
+type ConstraintSet uint32
+
+func (s ConstraintSet) Has(field string) bool {
+	return uint32(s)&attributeBit(field, Constraints) != 0
+}
+
+// Names returns the fields in the set
+func (s ConstraintSet) Names() []string {
+	return attributeNames(uint32(s), Constraints)
+}
+
+func (s ConstraintSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Names())
+}
+
+func (s *ConstraintSet) UnmarshalJSON(data []byte) error {
+	bits, err := parseAttributeSet(data, Constraints, "hard constraint")
+	*s = ConstraintSet(bits)
+	return err
+}
+
+// MeetsHardConstraints tells if a listing having the given price, rooms and area is within the bounds
+// of every field the requirement marked as hard. A missing (0) bound is no constraint, but an unknown
+// area never meets a hard area.
+func MeetsHardConstraints(r PropRequirement, price Money, bedrooms, bathrooms uint16, area float32) bool {
+	hard := r.HardConstraints
+	if hard == 0 {
+		return true
+	}
+	return (!hard.Has(ConstraintBudget) || withinBoundsMoney(r.MinBudget, r.MaxBudget, price)) &&
+		(!hard.Has(ConstraintBedrooms) || withinBounds(r.MinBedrooms, r.MaxBedrooms, bedrooms)) &&
+		(!hard.Has(ConstraintBathrooms) || withinBounds(r.MinBathrooms, r.MaxBathrooms, bathrooms)) &&
+		(!hard.Has(ConstraintArea) || (area > 0 && withinBoundsF(r.MinArea, r.MaxArea, area)))
+}
+
+// withinBoundsF tells if x is within [min, max], either of which can be missing (0)
+func withinBoundsF(min, max, x float32) bool {
+	return (min == 0 || x >= min) && (max == 0 || x <= max)
+}
+
+func withinBoundsMoney(min, max, x Money) bool {
+	return (min == 0 || x >= min) && (max == 0 || x <= max)
+}
+
+func withinBounds(min, max, x uint16) bool {
+	return (min == 0 || x >= min) && (max == 0 || x <= max)
+}
+
+// hardWindowMoney narrows the [lo, hi] margins window down to the [min, max] bounds of a hard field,
+// either of which can be missing (0)
+func hardWindowMoney(lo, hi, min, max Money) (Money, Money) {
+	if min > 0 {
+		lo = MaxMoney(lo, min)
+	}
+	if max > 0 && max < hi {
+		hi = max
+	}
+	return lo, hi
+}
+
+func hardWindow(lo, hi, min, max uint16) (uint16, uint16) {
+	if min > 0 {
+		lo = Max(lo, min)
+	}
+	if max > 0 && max < hi {
+		hi = max
+	}
+	return lo, hi
+}
+
+func validHardConstraints(r PropRequirement) error {
+	if r.HardConstraints.Has(ConstraintArea) && r.MinArea == 0 && r.MaxArea == 0 {
+		return errors.Wrap(ErrValidation, "a hard area constraint needs a min_area or a max_area")
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestConstraintSetJSON(t *testing.T) {
+	var s ConstraintSet
+	if err := json.Unmarshal([]byte(`["area", "budget"]`), &s); err != nil {
+		t.Fatalf("Unmarshal error = %v", err)
+	}
+	if !s.Has(ConstraintBudget) || !s.Has(ConstraintArea) || s.Has(ConstraintBedrooms) {
+		t.Errorf("Unmarshal = %v, want budget and area", s.Names())
+	}
+	data, err := json.Marshal(s)
+	if err != nil || string(data) != `["budget","area"]` {
+		t.Errorf("Marshal = (%s, %v), want [\"budget\",\"area\"]", data, err)
+	}
+	if err := json.Unmarshal([]byte(`["budget", "garden"]`), &s); err == nil {
+		t.Error("Unmarshal of an unknown field succeeded, want an error")
+	}
+}
+
+func TestMeetsHardConstraints(t *testing.T) {
+	hard := func(min, max Money, fields ...string) PropRequirement {
+		r := PropRequirement{MinBudget: min, MaxBudget: max, MinBedrooms: 2, MaxBedrooms: 3, MinBathrooms: 1, MaxBathrooms: 2}
+		r.MinArea, r.MaxArea = 900, 1200
+		data, _ := json.Marshal(fields)
+		if err := json.Unmarshal(data, &r.HardConstraints); err != nil {
+			t.Fatalf("couldn't parse the hard constraints %v: %v", fields, err)
+		}
+		return r
+	}
+	tests := []struct {
+		name      string
+		r         PropRequirement
+		price     Money
+		bedrooms  uint16
+		bathrooms uint16
+		area      float32
+		want      bool
+	}{
+		{"no hard constraint", hard(testMinBand, testMaxBand), testMaxPrice, 5, 5, 0, true},
+		{"on min budget", hard(testMinBand, testMaxBand, ConstraintBudget), testMinBand, 5, 5, 0, true},
+		{"on max budget", hard(testMinBand, testMaxBand, ConstraintBudget), testMaxBand, 5, 5, 0, true},
+		{"under min budget", hard(testMinBand, testMaxBand, ConstraintBudget), testMinBand - 1, 2, 1, 1000, false},
+		{"over max budget", hard(testMinBand, testMaxBand, ConstraintBudget), testMaxBand + 1, 2, 1, 1000, false},
+		{"no max budget", hard(testMinBand, 0, ConstraintBudget), MaxAmount, 2, 1, 1000, true},
+		{"no min budget", hard(0, testMaxBand, ConstraintBudget), 1, 2, 1, 1000, true},
+		{"within bedrooms", hard(testMinBand, testMaxBand, ConstraintBedrooms), testMaxPrice, 3, 5, 0, true},
+		{"out of bedrooms", hard(testMinBand, testMaxBand, ConstraintBedrooms), testBudget, 4, 1, 1000, false},
+		{"out of bathrooms", hard(testMinBand, testMaxBand, ConstraintBathrooms), testBudget, 2, 3, 1000, false},
+		{"within area", hard(testMinBand, testMaxBand, ConstraintArea), testMaxPrice, 5, 5, 1200, true},
+		{"out of area", hard(testMinBand, testMaxBand, ConstraintArea), testBudget, 2, 1, 1201, false},
+		{"unknown area", hard(testMinBand, testMaxBand, ConstraintArea), testBudget, 2, 1, 0, false},
+		{"all within", hard(testMinBand, testMaxBand, Constraints...), testBudget, 2, 1, 900, true},
+		{"one out of all", hard(testMinBand, testMaxBand, Constraints...), testBudget, 2, 3, 900, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MeetsHardConstraints(tt.r, tt.price, tt.bedrooms, tt.bathrooms, tt.area); got != tt.want {
+				t.Errorf("MeetsHardConstraints(%v, %v, %d, %d, %v) = %v, want %v", tt.r.HardConstraints.Names(), tt.price, tt.bedrooms, tt.bathrooms, tt.area, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHardWindow(t *testing.T) {
+	money := []struct {
+		name           string
+		min, max       Money
+		wantLo, wantHi Money
+	}{
+		{"narrowed", testMinBand, testMaxBand, testMinBand, testMaxBand},
+		{"no min", 0, testMaxBand, testMinPrice, testMaxBand},
+		{"no max", testMinBand, 0, testMinBand, testMaxPrice},
+		{"bounds wider than the margins", testMinPrice - 1, testMaxPrice + 1, testMinPrice, testMaxPrice},
+	}
+	for _, tt := range money {
+		t.Run("money "+tt.name, func(t *testing.T) {
+			if lo, hi := hardWindowMoney(testMinPrice, testMaxPrice, tt.min, tt.max); lo != tt.wantLo || hi != tt.wantHi {
+				t.Errorf("hardWindowMoney(%v, %v) = [%v, %v], want [%v, %v]", tt.min, tt.max, lo, hi, tt.wantLo, tt.wantHi)
+			}
+		})
+	}
+
+	rooms := []struct {
+		name           string
+		min, max       uint16
+		wantLo, wantHi uint16
+	}{
+		{"narrowed", 2, 3, 2, 3},
+		{"no min", 0, 3, 1, 3},
+		{"no max", 2, 0, 2, 5},
+		{"bounds wider than the margins", 1, 6, 1, 5},
+	}
+	for _, tt := range rooms {
+		t.Run("rooms "+tt.name, func(t *testing.T) {
+			if lo, hi := hardWindow(1, 5, tt.min, tt.max); lo != tt.wantLo || hi != tt.wantHi {
+				t.Errorf("hardWindow(%d, %d) = [%d, %d], want [%d, %d]", tt.min, tt.max, lo, hi, tt.wantLo, tt.wantHi)
+			}
+		})
+	}
+}
+
+func TestExcludeViolatingReqs(t *testing.T) {
+	// a listing of 100000.00 in the base currency, where 1 USD is 0.8 EUR, with 2 bedrooms and 3 bathrooms
+	rates := NewRateSnapshot("USD", testAsOf, map[string]float64{"EUR": 0.8})
+	listing := NewPropListingOf(*NewProperty(0, 0, 12.9, 77.6, testBudget, 2, 3))
+	requirement := func(id uint64, currency string, minBudget, maxBudget Money, hard ConstraintSet) ReqWithDistance {
+		r := ReqWithDistance{Requirement: *NewRequirement(0, 0, 12.9, 77.6, minBudget, maxBudget, 2, 3, 1, 2)}
+		r.RequirementID, r.Currency, r.HardConstraints = id, currency, hard
+		return r
+	}
+	budget := ConstraintSet(attributeBit(ConstraintBudget, Constraints))
+	bedrooms := ConstraintSet(attributeBit(ConstraintBedrooms, Constraints))
+
+	requirements := reqsInBase(rates, []ReqWithDistance{
+		requirement(1, "USD", testMinBand, testMaxBand, budget),
+		requirement(2, "USD", testMaxBand, 0, budget),
+		// a max of 85000.00 EUR is 106250.00 USD, above the price
+		requirement(3, "EUR", 0, 8500000, budget),
+		// a min of 90000.00 EUR is 112500.00 USD, above the price
+		requirement(4, "EUR", 9000000, 0, budget),
+		// nor does a soft budget
+		requirement(5, "EUR", 9000000, 0, bedrooms),
+		requirement(6, "USD", 0, 0, ConstraintSet(attributeBit(ConstraintBathrooms, Constraints))),
+	})
+	kept := []uint64{}
+	for _, r := range excludeViolatingReqs(listing, requirements) {
+		kept = append(kept, r.RequirementID)
+	}
+	if want := []uint64{1, 3, 5}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("excludeViolatingReqs kept %v, want %v", kept, want)
+	}
+}
+
+func TestMatchExcludesViolatingProps(t *testing.T) {
+	rates := NewRateSnapshot("USD", testAsOf, map[string]float64{"EUR": 0.8})
+	property := func(id uint64, currency string, price Money, bedrooms uint16) PropWithDistance {
+		p := PropWithDistance{Property: *NewProperty(0, 0, 12.9, 77.6, price, bedrooms, 2), Distance: 1}
+		p.PropertyID, p.Currency = id, currency
+		return p
+	}
+	properties := propsInBase(rates, []PropWithDistance{
+		property(1, "USD", testBudget, 2),
+		property(2, "USD", testMaxBand+1, 2),
+		// 85000.00 EUR is 106250.00 USD, within the budget
+		property(3, "EUR", 8500000, 2),
+		// 90000.00 EUR is 112500.00 USD, over the budget
+		property(4, "EUR", 9000000, 2),
+		property(5, "USD", testBudget, 4),
+	})
+	r := PropRequirement{Latitude: 12.9, Longitude: 77.6, MinBudget: testMinBand, MaxBudget: testMaxBand, MinBedrooms: 2, MaxBedrooms: 3, MinBathrooms: 1, MaxBathrooms: 2}
+	r.HardConstraints = ConstraintSet(attributeBit(ConstraintBudget, Constraints) | attributeBit(ConstraintBedrooms, Constraints))
+	policy := DefaultMatchPolicy()
+	policy.MinScore = 0
+
+	// every scoring component must be done for the match to return, so it is bounded in time
+	done := make(chan []MatchedProperty)
+	go func() {
+		done <- NewReqMatchingAlgoV3().Match(r, properties, NewReqMargins(0, 0, 0, 0, testMinPrice, testMaxPrice, 1, 5, 1, 5), policy)
+	}()
+	select {
+	case matches := <-done:
+		matched := []uint64{}
+		for _, m := range matches {
+			matched = append(matched, m.PropertyID)
+		}
+		sort.Slice(matched, func(i, j int) bool { return matched[i] < matched[j] })
+		if !reflect.DeepEqual(matched, []uint64{1, 3}) {
+			t.Errorf("Match matched %v, want the properties 1 and 3", matched)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Match didn't return")
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RateSnapshot is a table of exchange rates at a point in time. Rates has the units of each currency
+// which one unit of the Base currency buys, eg: {"USD": 1, "EUR": 0.92} for a USD base. The prices and
+// budgets of different currencies are compared in the base currency, and the empty currency of the
+// records stored before currencies existed is the base one.
+type RateSnapshot struct {
+	Base  string             `json:"base"`
+	AsOf  time.Time          `json:"as_of"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+func NewRateSnapshot(base string, asOf time.Time, rates map[string]float64) RateSnapshot {
+	all := map[string]float64{base: 1}
+	for currency, rate := range rates {
+		if currency != base {
+			all[currency] = rate
+		}
+	}
+	return RateSnapshot{
+		Base:  base,
+		AsOf:  asOf,
+		Rates: all,
+	}
+}
+
+// Rate returns the units of the currency one unit of the base currency buys, or false when the
+// snapshot has no rate for the currency
+func (s RateSnapshot) Rate(currency string) (float64, bool) {
+	if currency == "" || currency == s.Base {
+		return 1, true
+	}
+	rate, ok := s.Rates[currency]
+	return rate, ok
+}
+
+// ToBase converts an amount in the currency to the base currency, or returns false when the
+// snapshot has no rate for the currency or the converted amount doesn't fit in a Money
+func (s RateSnapshot) ToBase(currency string, amount Money) (Money, bool) {
+	rate, ok := s.Rate(currency)
+	if !ok || rate <= 0 {
+		return 0, false
+	}
+	return moneyOf(float64(amount) / rate)
+}
+
+// FromBase converts an amount in the base currency to the currency, the counterpart of ToBase
+func (s RateSnapshot) FromBase(currency string, amount Money) (Money, bool) {
+	rate, ok := s.Rate(currency)
+	if !ok || rate <= 0 {
+		return 0, false
+	}
+	return moneyOf(float64(amount) * rate)
+}
+
+// moneyOf rounds a converted amount to the nearest hundredth, or returns false when it doesn't fit
+// in a Money
+func moneyOf(amount float64) (Money, bool) {
+	rounded := math.Round(amount)
+	if math.IsNaN(rounded) || math.Abs(rounded) >= math.MaxInt64 {
+		return 0, false
+	}
+	return Money(rounded), true
+}
+
+// Currencies returns the currencies having a rate, the base one first and the others sorted
+func (s RateSnapshot) Currencies() []string {
+	currencies := make([]string, 0, len(s.Rates))
+	for currency := range s.Rates {
+		if currency != s.Base {
+			currencies = append(currencies, currency)
+		}
+	}
+	sort.Strings(currencies)
+	return append([]string{s.Base}, currencies...)
+}
+
+// Of returns the snapshot having only the rates of the currencies, ie: the rates a match was
+// scored with, which are recorded alongside it
+func (s RateSnapshot) Of(currencies ...string) RateSnapshot {
+	rates := map[string]float64{}
+	for _, currency := range currencies {
+		if rate, ok := s.Rate(currency); ok && currency != "" {
+			rates[currency] = rate
+		}
+	}
+	return NewRateSnapshot(s.Base, s.AsOf, rates)
+}
+
+// Rebased returns the snapshot converting to the base currency instead, or an error when it has
+// no rate for it
+func (s RateSnapshot) Rebased(base string) (RateSnapshot, error) {
+	if base == s.Base {
+		return s, nil
+	}
+	baseRate, ok := s.Rate(base)
+	if !ok || baseRate <= 0 {
+		return s, errors.Errorf("no %s rate in the %s exchange rates", base, s.Base)
+	}
+	rates := make(map[string]float64, len(s.Rates))
+	for currency, rate := range s.Rates {
+		rates[currency] = rate / baseRate
+	}
+	return NewRateSnapshot(base, s.AsOf, rates), nil
+}
+
+// validate returns an error describing the first bad currency code or rate of the snapshot
+func (s RateSnapshot) validate() error {
+	if !validCurrencyCode(s.Base) {
+		return errors.Errorf("bad base currency: %q, must be an ISO 4217 code, eg: USD", s.Base)
+	}
+	for currency, rate := range s.Rates {
+		if !validCurrencyCode(currency) {
+			return errors.Errorf("bad currency: %q, must be an ISO 4217 code, eg: EUR", currency)
+		}
+		if rate <= 0 {
+			return errors.Errorf("bad %s rate: %v, must be positive", currency, rate)
+		}
+	}
+	return nil
+}
+
+// RateProvider gives the exchange rates the prices and budgets are compared with. A snapshot is taken
+// for every match, so that all its amounts are converted with the same rates, and Base never changes
+// as the records stored without a currency are in it.
+type RateProvider interface {
+	Base() string
+	Snapshot() (RateSnapshot, error)
+}
+
+// StaticRateProvider only knows about the base currency, ie: every record is in the same currency.
+// It is the provider of the single currency deployments.
+type StaticRateProvider struct {
+	snapshot RateSnapshot
+}
+
+func NewStaticRateProvider(base string) StaticRateProvider {
+	return StaticRateProvider{
+		snapshot: NewRateSnapshot(base, time.Now().UTC(), nil),
+	}
+}
+
+func (p StaticRateProvider) Base() string {
+	return p.snapshot.Base
+}
+
+func (p StaticRateProvider) Snapshot() (RateSnapshot, error) {
+	return p.snapshot, nil
+}
+
+// FileRateProvider reads the rates from a json file for the deployments without access to an exchange
+// rates api, eg: {"base": "EUR", "as_of": "2024-05-02T16:00:00Z", "rates": {"USD": 1.07, "GBP": 0.85}}.
+// The rates are rebased to the base currency of the provider, and the file is read again whenever it
+// changes so that it can be updated without a restart. A bad update keeps the last good rates.
+type FileRateProvider struct {
+	base string
+	path string
+
+	mu       sync.Mutex
+	modTime  time.Time
+	snapshot RateSnapshot
+}
+
+func NewFileRateProvider(base, path string) (*FileRateProvider, error) {
+	p := &FileRateProvider{
+		base: base,
+		path: path,
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "FileRateProvider couldn't stat the rates file")
+	}
+	if err = p.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *FileRateProvider) Base() string {
+	return p.base
+}
+
+func (p *FileRateProvider) Snapshot() (RateSnapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		log.Printf("FileRateProvider couldn't stat %s, keeping the rates as of %s: %v", p.path, p.snapshot.AsOf, err)
+		return p.snapshot, nil
+	}
+	if !info.ModTime().Equal(p.modTime) {
+		if err = p.load(info.ModTime()); err != nil {
+			log.Printf("FileRateProvider couldn't reload %s, keeping the rates as of %s: %v", p.path, p.snapshot.AsOf, err)
+		}
+	}
+	return p.snapshot, nil
+}
+
+// load reads the rates file, modified at modTime, and replaces the snapshot of the provider
+func (p *FileRateProvider) load(modTime time.Time) error {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return errors.Wrap(err, "FileRateProvider couldn't read the rates file")
+	}
+	var s RateSnapshot
+	if err = json.Unmarshal(data, &s); err != nil {
+		return errors.Wrap(err, "FileRateProvider couldn't parse the rates file")
+	}
+	s = NewRateSnapshot(s.Base, s.AsOf, s.Rates)
+	if err = s.validate(); err != nil {
+		return errors.Wrap(err, "FileRateProvider got bad rates")
+	}
+	if s, err = s.Rebased(p.base); err != nil {
+		return errors.Wrap(err, "FileRateProvider couldn't rebase the rates")
+	}
+	p.modTime, p.snapshot = modTime, s
+	return nil
+}
+
+// validCurrencyCode only checks the shape of an ISO 4217 code, ie: three upper case letters
+func validCurrencyCode(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, c := range currency {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// validCurrency allows an empty currency, the base one, or any other one having an exchange rate
+func validCurrency(rates RateProvider, currency string) error {
+	if currency == "" || currency == rates.Base() {
+		return nil
+	}
+	snapshot, err := rates.Snapshot()
+	if err != nil {
+		return errors.Wrap(err, "couldn't get the exchange rates")
+	}
+	if _, ok := snapshot.Rate(currency); !ok || !validCurrencyCode(currency) {
+		return errors.Wrapf(ErrValidation, "bad currency: %q, must be %v", currency, snapshot.Currencies())
+	}
+	return nil
+}
+
+// withCurrency returns the requirement request in the base currency when it has no currency, or
+// else in its currency in upper case
+func (p PropRequirement) withCurrency(base string) PropRequirement {
+	p.Currency = currencyOr(p.Currency, base)
+	return p
+}
+
+// inBase returns the requirement request having its budgets in the base currency of the rates, or
+// false when they can't be converted, eg: the rate of its currency was dropped since it was validated
+func (p PropRequirement) inBase(rates RateSnapshot) (PropRequirement, bool) {
+	minBudget, minOK := rates.ToBase(p.Currency, p.MinBudget)
+	maxBudget, maxOK := rates.ToBase(p.Currency, p.MaxBudget)
+	p.MinBudget, p.MaxBudget, p.Currency = minBudget, maxBudget, rates.Base
+	return p, minOK && maxOK
+}
+
+// withCurrency returns the listing request in the base currency when it has no currency, or else
+// in its currency in upper case
+func (p PropListing) withCurrency(base string) PropListing {
+	p.Currency = currencyOr(p.Currency, base)
+	return p
+}
+
+// inBase returns the listing request having its price in the base currency of the rates, or false
+// when it can't be converted, the counterpart of PropRequirement.inBase
+func (p PropListing) inBase(rates RateSnapshot) (PropListing, bool) {
+	price, ok := rates.ToBase(p.Currency, p.Price)
+	p.Price, p.Currency = price, rates.Base
+	return p, ok
+}
+
+// propsInBase sets the price in the base currency of the rates of every candidate property, and
+// drops the ones whose price can't be converted
+func propsInBase(rates RateSnapshot, properties []PropWithDistance) []PropWithDistance {
+	converted := make([]PropWithDistance, 0, len(properties))
+	for _, p := range properties {
+		var ok bool
+		if p.BasePrice, ok = rates.ToBase(p.Currency, p.Price); !ok {
+			log.Printf("dropping the candidate property %d, its price of %v %s can't be converted to %s", p.PropertyID, p.Price, p.Currency, rates.Base)
+			continue
+		}
+		converted = append(converted, p)
+	}
+	return converted
+}
+
+// reqsInBase sets the budgets in the base currency of the rates of every candidate requirement, and
+// drops the ones whose budgets can't be converted
+func reqsInBase(rates RateSnapshot, requirements []ReqWithDistance) []ReqWithDistance {
+	converted := make([]ReqWithDistance, 0, len(requirements))
+	for _, r := range requirements {
+		var minOK, maxOK bool
+		r.BaseMinBudget, minOK = rates.ToBase(r.Currency, r.MinBudget)
+		r.BaseMaxBudget, maxOK = rates.ToBase(r.Currency, r.MaxBudget)
+		if !minOK || !maxOK {
+			log.Printf("dropping the candidate requirement %d, its budgets of %v - %v %s can't be converted to %s", r.RequirementID, r.MinBudget, r.MaxBudget, r.Currency, rates.Base)
+			continue
+		}
+		converted = append(converted, r)
+	}
+	return converted
+}
+
+func currencyOr(currency, base string) string {
+	if currency == "" {
+		return base
+	}
+	return strings.ToUpper(currency)
+}
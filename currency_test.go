@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testAsOf = time.Date(2024, 5, 2, 16, 0, 0, 0, time.UTC)
+
+func TestRateSnapshotRebased(t *testing.T) {
+	usd := NewRateSnapshot("USD", testAsOf, map[string]float64{"EUR": 0.5, "GBP": 0.25})
+	tests := []struct {
+		name    string
+		base    string
+		want    map[string]float64
+		invalid bool
+	}{
+		{"same base", "USD", map[string]float64{"USD": 1, "EUR": 0.5, "GBP": 0.25}, false},
+		{"other base", "EUR", map[string]float64{"EUR": 1, "USD": 2, "GBP": 0.5}, false},
+		{"no rate", "JPY", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := usd.Rebased(tt.base)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("Rebased(%s) succeeded, want an error", tt.base)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Rebased(%s) error = %v", tt.base, err)
+			}
+			if got.Base != tt.base || !got.AsOf.Equal(testAsOf) || !reflect.DeepEqual(got.Rates, tt.want) {
+				t.Errorf("Rebased(%s) = %+v, want the %s rates %v", tt.base, got, tt.base, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateSnapshotConversion(t *testing.T) {
+	rates := NewRateSnapshot("USD", testAsOf, map[string]float64{"EUR": 0.8, "JPY": 150, "XTS": 1e-9})
+	tests := []struct {
+		name     string
+		currency string
+		amount   Money
+		base     Money
+		ok       bool
+	}{
+		{"base currency", "USD", 12345, 12345, true},
+		{"no currency", "", 12345, 12345, true},
+		{"other currency", "EUR", 8000000, 10000000, true},
+		{"rounded", "JPY", 100050, 667, true},
+		{"no rate", "GBP", 12345, 0, false},
+		{"overflow", "XTS", MaxAmount, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rates.ToBase(tt.currency, tt.amount)
+			if got != tt.base || ok != tt.ok {
+				t.Errorf("ToBase(%s, %v) = (%v, %v), want (%v, %v)", tt.currency, tt.amount, got, ok, tt.base, tt.ok)
+			}
+		})
+	}
+
+	// the margins of a base amount are converted the other way round
+	back := []struct {
+		name     string
+		currency string
+		base     Money
+		amount   Money
+		ok       bool
+	}{
+		{"base currency", "USD", 12345, 12345, true},
+		{"other currency", "EUR", 10000000, 8000000, true},
+		{"rounded", "JPY", 667, 100050, true},
+		{"no rate", "GBP", 12345, 0, false},
+		{"overflow", "JPY", 1 << 62, 0, false},
+	}
+	for _, tt := range back {
+		t.Run("from base "+tt.name, func(t *testing.T) {
+			got, ok := rates.FromBase(tt.currency, tt.base)
+			if got != tt.amount || ok != tt.ok {
+				t.Errorf("FromBase(%s, %v) = (%v, %v), want (%v, %v)", tt.currency, tt.base, got, ok, tt.amount, tt.ok)
+			}
+		})
+	}
+}
+
+func TestInBaseDropsUnconvertibleCandidates(t *testing.T) {
+	rates := NewRateSnapshot("USD", testAsOf, map[string]float64{"EUR": 0.8})
+	property := func(id uint64, currency string) PropWithDistance {
+		p := PropWithDistance{Property: *NewProperty(0, 0, 12.9, 77.6, 8000000, 2, 2)}
+		p.PropertyID, p.Currency = id, currency
+		return p
+	}
+	requirement := func(id uint64, currency string) ReqWithDistance {
+		r := ReqWithDistance{Requirement: *NewRequirement(0, 0, 12.9, 77.6, 0, 8000000, 2, 3, 1, 2)}
+		r.RequirementID, r.Currency = id, currency
+		return r
+	}
+
+	props := propsInBase(rates, []PropWithDistance{property(1, "USD"), property(2, "GBP"), property(3, "EUR")})
+	if len(props) != 2 || props[0].PropertyID != 1 || props[1].PropertyID != 3 {
+		t.Fatalf("propsInBase kept %+v, want the properties 1 and 3", props)
+	}
+	if props[0].BasePrice != 8000000 || props[1].BasePrice != 10000000 {
+		t.Errorf("propsInBase base prices = (%v, %v), want (80000, 100000)", props[0].BasePrice, props[1].BasePrice)
+	}
+
+	reqs := reqsInBase(rates, []ReqWithDistance{requirement(1, "GBP"), requirement(2, "EUR"), requirement(3, "")})
+	if len(reqs) != 2 || reqs[0].RequirementID != 2 || reqs[1].RequirementID != 3 {
+		t.Fatalf("reqsInBase kept %+v, want the requirements 2 and 3", reqs)
+	}
+	if reqs[0].BaseMaxBudget != 10000000 || reqs[1].BaseMaxBudget != 8000000 {
+		t.Errorf("reqsInBase base max budgets = (%v, %v), want (100000, 80000)", reqs[0].BaseMaxBudget, reqs[1].BaseMaxBudget)
+	}
+}
+
+func TestFileRateProviderKeepsLastGoodRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	modTime := testAsOf
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("couldn't write the rates file: %v", err)
+		}
+		// the file is only read again when its modification time changes
+		modTime = modTime.Add(time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("couldn't touch the rates file: %v", err)
+		}
+	}
+
+	write(`{"base": "EUR", "as_of": "2024-05-02T16:00:00Z", "rates": {"USD": 1.25}}`)
+	p, err := NewFileRateProvider("USD", path)
+	if err != nil {
+		t.Fatalf("NewFileRateProvider error = %v", err)
+	}
+	steps := []struct {
+		name string
+		file string
+		want float64
+	}{
+		{"first rates", "", 0.8},
+		{"not json", `{"base": "EUR", "rates": `, 0.8},
+		{"bad rate", `{"base": "EUR", "rates": {"USD": -1}}`, 0.8},
+		{"no base rate", `{"base": "EUR", "rates": {"GBP": 0.85}}`, 0.8},
+		{"good rates", `{"base": "EUR", "rates": {"USD": 2}}`, 0.5},
+	}
+	for _, step := range steps {
+		if step.file != "" {
+			write(step.file)
+		}
+		s, err := p.Snapshot()
+		if err != nil {
+			t.Fatalf("%s: Snapshot error = %v", step.name, err)
+		}
+		if rate, ok := s.Rate("EUR"); !ok || rate != step.want {
+			t.Errorf("%s: EUR rate = (%v, %v), want %v", step.name, rate, ok, step.want)
+		}
+	}
+}
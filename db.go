@@ -4,35 +4,47 @@ package main
 
 import (
 	"log"
-	"os"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 )
 
-// EstabilishConnection takes the database config object loaded from YAML file and
-// creates a connection with mysql server. It also takes care of connection pool.
-func NewDBClient() (*gorm.DB, error) {
+// NewDBClient takes the config object loaded from the environment and creates a connection with the
+// configured sql database, either a mysql server, a postgres server or a sqlite file. It also takes care of connection pool.
+func NewDBClient(cfg Config) (*gorm.DB, error) {
 	var db *gorm.DB
 	var err error
 
-	host := os.Getenv("MYSQL_HOST")
-	port := os.Getenv("MYSQL_PORT")
-	user := os.Getenv("MYSQL_USER")
-	pass := os.Getenv("MYSQL_PASS")
-	dbName := os.Getenv("MYSQL_DATABASE")
-
-	dbURL := user + ":" + pass + "@/tcp(" + host + ":" + port + ")/" + dbName + "?charset=utf8&parseTime=True&loc=Local"
-
-	db, err = gorm.Open("mysql", dbURL)
-	if err != nil {
-		log.Println("Error :: Could not estabilish connection to mysql server")
-		return db, err
+	switch cfg.Store {
+	case "sqlite":
+		db, err = gorm.Open("sqlite3", cfg.SQLitePath)
+		if err != nil {
+			log.Printf("Error :: Could not open sqlite database %s", cfg.SQLitePath)
+			return db, err
+		}
+		// sqlite only allows a single writer, so sharing one connection avoids "database is locked" errors
+		db.DB().SetMaxOpenConns(1)
+	case "postgres":
+		db, err = gorm.Open("postgres", cfg.PostgresURL)
+		if err != nil {
+			log.Println("Error :: Could not estabilish connection to postgres server")
+			return db, err
+		}
+	default:
+		dbURL := cfg.MySQLUser + ":" + cfg.MySQLPass + "@tcp(" + cfg.MySQLHost + ":" + cfg.MySQLPort + ")/" + cfg.MySQLDatabase + "?charset=utf8&parseTime=True&loc=Local"
+
+		db, err = gorm.Open("mysql", dbURL)
+		if err != nil {
+			log.Println("Error :: Could not estabilish connection to mysql server")
+			return db, err
+		}
+		// db.DB().SetMaxIdleConns(0)
+		// db.DB().SetMaxOpenConns(20)
 	}
-	defer db.Close()
+	// the connection pool lives as long as the application, so it is not closed here
 
-	// db.DB().SetMaxIdleConns(0)
-	// db.DB().SetMaxOpenConns(20)
 	db.LogMode(true)
 
 	return db, nil
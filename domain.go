@@ -12,48 +12,108 @@
 package main
 
 import (
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
+// ErrValidation is the cause of every error returned by the usecase processors when the
+// incoming request fails validation, so that callers (eg: the API layer) can tell bad input
+// apart from infrastructure failures using errors.Cause
+var ErrValidation = errors.New("validation failed")
+
+// ErrNotFound is the cause of every error returned when a property or requirement to be read,
+// updated or deleted does not exist in the store
+var ErrNotFound = errors.New("record not found")
+
+// ErrUnauthorized is the cause of every error returned when a request has no API key, or an
+// unknown or revoked one
+var ErrUnauthorized = errors.New("unauthorized")
+
+// ErrForbidden is the cause of every error returned when the API key of a request lacks the scope
+// needed by the request, or when a request acts for an owner it can't act for, eg: files a record
+// for another owner
+var ErrForbidden = errors.New("forbidden")
+
 // Transaction is an aggregrate which specifies what is the Order on which a transaction is taking place
 // alongs with information like payment details
 type Property struct {
-	PropertyID uint64  `gorm:"primary_key"`
-	Latitude   float32 `gorm:"index:idx_properties_latitude_longitude"`
-	Longitude  float32 `gorm:"index:idx_properties_latitude_longitude"`
-	Price      float32
-	Bedrooms   uint16
-	Bathrooms  uint16
-	AddedDate  time.Time
+	PropertyID uint64    `gorm:"primary_key" json:"property_id"`
+	TenantID   uint64    `gorm:"index:idx_properties_tenant_id" json:"tenant_id"`
+	OwnerID    uint64    `gorm:"index:idx_properties_owner_id" json:"owner_id"`
+	Latitude   float32   `gorm:"index:idx_properties_latitude_longitude" json:"latitude"`
+	Longitude  float32   `gorm:"index:idx_properties_latitude_longitude" json:"longitude"`
+	Price      Money     `json:"price"`
+	Bedrooms   uint16    `json:"bedrooms"`
+	Bathrooms  uint16    `json:"bathrooms"`
+	AddedDate  time.Time `json:"added_date"`
+	// Currency is the ISO 4217 code of the price, empty for the base currency of the listings stored
+	// before currencies existed
+	Currency string `json:"currency"`
+	// Status is one of StatusActive, StatusPaused, StatusFulfilled or StatusExpired
+	Status string `gorm:"index:idx_properties_status" json:"status"`
+	// ExpiresAt is when the ExpirySweeper expires the listing, nil for the listings stored before
+	// expiry existed, which expire a TTL after their AddedDate instead
+	ExpiresAt *time.Time `json:"expires_at"`
+	PropertyAttributes
 }
 
-func NewProperty(lat, lon, price float32, bedrooms, bathrooms uint16) *Property {
+func NewProperty(tenantID, ownerID uint64, lat, lon float32, price Money, bedrooms, bathrooms uint16) *Property {
 	p := Property{
+		TenantID:  tenantID,
+		OwnerID:   ownerID,
 		Latitude:  lat,
 		Longitude: lon,
 		Price:     price,
 		Bedrooms:  bedrooms,
 		Bathrooms: bathrooms,
 		AddedDate: time.Now().UTC(),
+		Status:    StatusActive,
 	}
 	return &p
 }
 
 type Requirement struct {
-	RequirementID uint64  `gorm:"primary_key"`
-	Latitude      float32 `gorm:"index:idx_requirements_latitude_longitude"`
-	Longitude     float32 `gorm:"index:idx_requirements_latitude_longitude"`
-	MinBudget     float32
-	MaxBudget     float32
-	MinBedrooms   uint16
-	MaxBedrooms   uint16
-	MinBathrooms  uint16
-	MaxBathrooms  uint16
-	AddedDate     time.Time
-}
-
-func NewRequirement(lat, lon, minBudget, maxBudget float32, minBedrooms, maxBedrooms, minBathrooms, maxBathrooms uint16) *Requirement {
+	RequirementID uint64    `gorm:"primary_key" json:"requirement_id"`
+	TenantID      uint64    `gorm:"index:idx_requirements_tenant_id" json:"tenant_id"`
+	OwnerID       uint64    `gorm:"index:idx_requirements_owner_id" json:"owner_id"`
+	Latitude      float32   `gorm:"index:idx_requirements_latitude_longitude" json:"latitude"`
+	Longitude     float32   `gorm:"index:idx_requirements_latitude_longitude" json:"longitude"`
+	MinBudget     Money     `json:"min_budget"`
+	MaxBudget     Money     `json:"max_budget"`
+	MinBedrooms   uint16    `json:"min_bedrooms"`
+	MaxBedrooms   uint16    `json:"max_bedrooms"`
+	MinBathrooms  uint16    `json:"min_bathrooms"`
+	MaxBathrooms  uint16    `json:"max_bathrooms"`
+	AddedDate     time.Time `json:"added_date"`
+	// Currency is the ISO 4217 code of the budgets, empty like the one of a Property
+	Currency string `json:"currency"`
+	// Status and ExpiresAt work the same way as the ones of a Property
+	Status    string     `gorm:"index:idx_requirements_status" json:"status"`
+	ExpiresAt *time.Time `json:"expires_at"`
+
+	// HardConstraints are the fields whose bounds a listing must never be out of to be a match
+	HardConstraints ConstraintSet `json:"hard_constraints"`
+	// Weights are the custom weights the matches of the requirement are scored with, nil for the
+	// weights of the match policy
+	Weights *MatchWeights `json:"weights"`
+	// SearchRadius and FullScoreRadius are the radii in miles of the requirement, 0 for the radii of
+	// the match policy
+	SearchRadius    float32 `json:"search_radius"`
+	FullScoreRadius float32 `json:"full_score_radius"`
+	// Polygons are the areas the requirement looks for listings in, if any. Its Latitude and
+	// Longitude are then the center of the polygons, all of which are within PolygonsRadius miles of it.
+	Polygons       Polygons `json:"polygons"`
+	PolygonsRadius float32  `json:"-"`
+	RequirementAttributes
+}
+
+func NewRequirement(tenantID, ownerID uint64, lat, lon float32, minBudget, maxBudget Money, minBedrooms, maxBedrooms, minBathrooms, maxBathrooms uint16) *Requirement {
 	r := Requirement{
+		TenantID:     tenantID,
+		OwnerID:      ownerID,
 		Latitude:     lat,
 		Longitude:    lon,
 		MinBudget:    minBudget,
@@ -63,10 +123,192 @@ func NewRequirement(lat, lon, minBudget, maxBudget float32, minBedrooms, maxBedr
 		MinBathrooms: minBathrooms,
 		MaxBathrooms: maxBathrooms,
 		AddedDate:    time.Now().UTC(),
+		Status:       StatusActive,
 	}
 	return &r
 }
 
+const (
+	// StatusActive records are matched against the new records of the other side
+	StatusActive = "active"
+	// StatusPaused records are kept but not matched, eg: a listing on hold during negotiations
+	StatusPaused = "paused"
+	// StatusFulfilled records are done, eg: the property is sold or the client found a home
+	StatusFulfilled = "fulfilled"
+	// StatusExpired records were not fulfilled before their ExpiresAt, they are set by the ExpirySweeper
+	StatusExpired = "expired"
+)
+
+const (
+	// OwnerAgent is a real estate agent listing properties and/or looking for them on behalf of clients
+	OwnerAgent = "agent"
+	// OwnerClient is an individual buyer/tenant or seller/landlord
+	OwnerClient = "client"
+)
+
+// Owner is the agent or client who created a property listing or a requirement. Matches are
+// routed to the owners, and an owner never gets matched against their own records.
+// The OwnerID 0 means no owner, eg: records created before owners existed.
+type Owner struct {
+	OwnerID    uint64    `gorm:"primary_key" json:"owner_id"`
+	TenantID   uint64    `json:"tenant_id"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	WebhookURL string    `json:"webhook_url"`
+	AddedDate  time.Time `json:"added_date"`
+}
+
+func NewOwner(tenantID uint64, kind, name, email, webhookURL string) *Owner {
+	o := Owner{
+		TenantID:   tenantID,
+		Kind:       kind,
+		Name:       name,
+		Email:      email,
+		WebhookURL: webhookURL,
+		AddedDate:  time.Now().UTC(),
+	}
+	return &o
+}
+
+// Tenant is a brokerage the matcher is run for. Every property, requirement and owner belongs to a
+// tenant and a tenant only sees its own inventory, unless another tenant shares its listings with it.
+// The TenantID 0 is the default tenant of single tenant deployments.
+type Tenant struct {
+	TenantID uint64 `gorm:"primary_key" json:"tenant_id"`
+	Name     string `json:"name"`
+	// Policy is the json MatchPolicy of the tenant applied over the deployment policy, empty for none
+	Policy    string    `gorm:"type:text" json:"policy"`
+	AddedDate time.Time `json:"added_date"`
+}
+
+func NewTenant(name, policy string) *Tenant {
+	t := Tenant{
+		Name:      name,
+		Policy:    policy,
+		AddedDate: time.Now().UTC(),
+	}
+	return &t
+}
+
+// SharingAgreement lets the requirements of the PartnerTenantID match against the property listings
+// of the SharingTenantID, and so the new listings of the sharing tenant reach the partner's requirements
+type SharingAgreement struct {
+	SharingTenantID uint64    `gorm:"primary_key;auto_increment:false" json:"sharing_tenant_id"`
+	PartnerTenantID uint64    `gorm:"primary_key;auto_increment:false" json:"partner_tenant_id"`
+	AddedDate       time.Time `json:"added_date"`
+}
+
+func NewSharingAgreement(sharingTenantID, partnerTenantID uint64) *SharingAgreement {
+	a := SharingAgreement{
+		SharingTenantID: sharingTenantID,
+		PartnerTenantID: partnerTenantID,
+		AddedDate:       time.Now().UTC(),
+	}
+	return &a
+}
+
+const (
+	// ScopePropertiesWrite allows adding, updating and deleting property listings
+	ScopePropertiesWrite = "properties:write"
+	// ScopeRequirementsWrite allows adding, updating and deleting requirements
+	ScopeRequirementsWrite = "requirements:write"
+	// ScopeMatchesRead allows the dry runs, the re-scores, the owner listings and the inbox
+	ScopeMatchesRead = "matches:read"
+	// ScopeOwnersWrite allows registering owners
+	ScopeOwnersWrite = "owners:write"
+	// ScopeAdmin allows managing the tenants, their policies and their sharing agreements
+	ScopeAdmin = "admin"
+)
+
+// Scopes are all the scopes an APIKey can be given
+var Scopes = []string{ScopePropertiesWrite, ScopeRequirementsWrite, ScopeMatchesRead, ScopeOwnersWrite, ScopeAdmin}
+
+// APIKey authenticates the requests of a client of a tenant. Only the sha256 Hash of the key is
+// stored, the key itself is shown once when it is issued. Prefix is the start of the key, so that
+// the keys of a tenant can be told apart without revealing them.
+type APIKey struct {
+	KeyID    uint64 `gorm:"primary_key" json:"key_id"`
+	TenantID uint64 `json:"tenant_id"`
+	Name     string `json:"name"`
+	Prefix   string `json:"prefix"`
+	Hash     string `gorm:"unique_index:idx_api_keys_hash" json:"-"`
+	// OwnerID is the owner the key acts for, whose records it files and updates. The OwnerID 0 acts
+	// for the whole tenant and files records without an owner.
+	OwnerID uint64 `json:"owner_id"`
+	// Scopes are space separated, eg: "properties:write matches:read"
+	Scopes string `json:"scopes"`
+	// RateLimit is the max number of requests per minute, 0 means no limit
+	RateLimit int        `json:"rate_limit"`
+	AddedDate time.Time  `json:"added_date"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+func NewAPIKey(tenantID, ownerID uint64, name, prefix, hash string, scopes []string, rateLimit int) *APIKey {
+	k := APIKey{
+		TenantID:  tenantID,
+		OwnerID:   ownerID,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    strings.Join(scopes, " "),
+		RateLimit: rateLimit,
+		AddedDate: time.Now().UTC(),
+	}
+	return &k
+}
+
+// HasScope tells if the key was given the scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range strings.Fields(k.Scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func validScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// validStatus allows the statuses a client can set, StatusExpired is only set by the ExpirySweeper
+func validStatus(status string) bool {
+	return status == StatusActive || status == StatusPaused || status == StatusFulfilled
+}
+
+// validExpiresAt allows an optional expiry in the future
+func validExpiresAt(expiresAt *time.Time) bool {
+	return expiresAt == nil || expiresAt.After(time.Now())
+}
+
+func validOwnerKind(kind string) bool {
+	return kind == OwnerAgent || kind == OwnerClient
+}
+
+// validEmail only checks the shape of an optional email address, the SMTP server has the final say
+func validEmail(email string) bool {
+	if email == "" {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	return at > 0 && at < len(email)-1 && !strings.ContainsAny(email, " \r\n")
+}
+
+// validWebhookURL allows an optional absolute http(s) url
+func validWebhookURL(rawURL string) bool {
+	if rawURL == "" {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 func validCoordinate(lat, long float32) bool {
 	if lat < -90 || lat > 90 || long < -180 || long > 180 {
 		return false
@@ -74,8 +316,8 @@ func validCoordinate(lat, long float32) bool {
 	return true
 }
 
-func validBudget(minBudget, maxBudget float32) bool {
-	if minBudget < 0 || maxBudget < 0 || minBudget > maxBudget {
+func validBudget(minBudget, maxBudget Money) bool {
+	if minBudget < 0 || maxBudget < 0 || minBudget > maxBudget || maxBudget > MaxAmount {
 		return false
 	}
 	return true
@@ -96,8 +338,21 @@ func validIntRange(x, y uint16) bool {
 	return true
 }
 
-func validPrice(price float32) bool {
-	return price > 0
+// validSearchRadius allows the search radius to be missing (0)
+func validSearchRadius(searchRadius float32) bool {
+	return searchRadius >= 0 && searchRadius <= MaxSearchRadius
+}
+
+// validFullScoreRadius allows the full score radius to be missing (0), it needs a search radius otherwise
+func validFullScoreRadius(searchRadius, fullScoreRadius float32) bool {
+	if fullScoreRadius == 0 {
+		return true
+	}
+	return fullScoreRadius > 0 && fullScoreRadius < searchRadius
+}
+
+func validPrice(price Money) bool {
+	return price > 0 && price <= MaxAmount
 }
 
 func validBedrooms(bedRooms uint16) bool {
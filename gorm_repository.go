@@ -0,0 +1,655 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// propertyColumns and requirementColumns are the columns selected by the raw candidate queries
+const (
+	propertyColumns = "property_id, tenant_id, owner_id, latitude, longitude, price, currency, bedrooms, bathrooms, added_date, status, expires_at, " +
+		"property_type, area, parking_spaces, furnishing, amenities"
+	requirementColumns = "requirement_id, tenant_id, owner_id, latitude, longitude, min_budget, max_budget, currency, min_bedrooms, max_bedrooms, " +
+		"min_bathrooms, max_bathrooms, added_date, status, expires_at, " +
+		"property_types, min_area, max_area, min_parking, furnishings, must_have_amenities, nice_to_have_amenities, hard_constraints, weights, " +
+		"search_radius, full_score_radius, polygons, polygons_radius"
+)
+
+// The base filtering conditions are the same for every sql store, only the way the distance is
+// calculated differs. They are kept here so that every store filters candidates the same way.
+const (
+	boundingBoxCondition = "latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?"
+
+	propWindowsCondition = "bedrooms BETWEEN ? AND ? AND bathrooms BETWEEN ? AND ?"
+
+	// A requirement is a candidate when its [min, max] range overlaps the margins window. A missing
+	// max is stored as 0 so the range overlap fails for it, hence the extra check on the min alone.
+	reqWindowsCondition = "((min_bedrooms <= ? AND max_bedrooms >= ?) OR (min_bedrooms BETWEEN ? AND ?)) " +
+		"AND ((min_bathrooms <= ? AND max_bathrooms >= ?) OR (min_bathrooms BETWEEN ? AND ?))"
+
+	// The price and budget windows are in the base currency, so they are repeated for each currency
+	// with the margins converted to it, see currencyCondition. gorm expands the slice of currencies.
+	currencyWindowCondition = "(currency IN (?) AND %s)"
+	propPriceCondition      = "price BETWEEN ? AND ?"
+	reqBudgetCondition      = "((min_budget <= ? AND max_budget >= ?) OR (min_budget BETWEEN ? AND ?))"
+
+	// The attributes of a property are candidates when they fall inside the attribute windows, the
+	// empty property_type and furnishing and the 0 area being unknown. gorm expands the slices of names.
+	propAttributesCondition = "property_type IN (?) AND furnishing IN (?) AND (area = 0 OR area BETWEEN ? AND ?) " +
+		"AND parking_spaces BETWEEN ? AND ? AND (amenities & ?) = ?"
+
+	// The asks of a requirement are candidates when they overlap the attribute windows, the sets are
+	// bitmasks of which 0 means any and the must have amenities must all be in the windows.
+	reqAttributesCondition = "(property_types = 0 OR (property_types & ?) <> 0) AND (furnishings = 0 OR (furnishings & ?) <> 0) " +
+		"AND (min_area = 0 OR min_area <= ?) AND (max_area = 0 OR max_area >= ?) " +
+		"AND min_parking BETWEEN ? AND ? AND (must_have_amenities & ?) = must_have_amenities"
+
+	// the search radius of a requirement, or the default radius given as argument if it has none,
+	// widened by the radius of its polygons
+	reqRadiusSelect = "(CASE WHEN search_radius > 0 THEN search_radius ELSE ? END + polygons_radius)"
+
+	// only the records of the tenants of the query are candidates, gorm expands the slice of ids
+	tenantCondition = "tenant_id IN (?)"
+
+	// only the active records are candidates, the paused, fulfilled and expired ones are kept out
+	statusCondition = "status = ?"
+
+	// the filters conditions are all the non spatial conditions a candidate must meet, on top of the
+	// price or budget condition of the currencies
+	propFiltersCondition = propWindowsCondition + " AND " + propAttributesCondition + " AND " + tenantCondition + " AND " + statusCondition
+	reqFiltersCondition  = reqWindowsCondition + " AND " + reqAttributesCondition + " AND " + tenantCondition + " AND " + statusCondition
+
+	// the active records past their expires_at, or without one and older than the ttl
+	expireDueCondition = "status = ? AND (expires_at <= ? OR (expires_at IS NULL AND added_date <= ?))"
+)
+
+func boundingBoxArgs(rMargins ReqMargins) []interface{} {
+	return []interface{}{rMargins.MinLat, rMargins.MaxLat, rMargins.MinLon, rMargins.MaxLon}
+}
+
+func propWindowsArgs(rMargins ReqMargins) []interface{} {
+	return []interface{}{rMargins.MinBeds, rMargins.MaxBeds, rMargins.MinBaths, rMargins.MaxBaths}
+}
+
+func reqWindowsArgs(rMargins ReqMargins) []interface{} {
+	return []interface{}{
+		rMargins.MaxBeds, rMargins.MinBeds, rMargins.MinBeds, rMargins.MaxBeds,
+		rMargins.MaxBaths, rMargins.MinBaths, rMargins.MinBaths, rMargins.MaxBaths,
+	}
+}
+
+func propPriceArgs(minPrice, maxPrice Money) []interface{} {
+	return []interface{}{minPrice, maxPrice}
+}
+
+func reqBudgetArgs(minPrice, maxPrice Money) []interface{} {
+	return []interface{}{maxPrice, minPrice, minPrice, maxPrice}
+}
+
+// currencyCondition repeats the condition on the amounts of a record for each currency of the rates,
+// along with its args built from the margins converted to the currency. The base currency includes the
+// records without a currency, and the records in a currency without a rate, or which the margins can't
+// be converted to, are never candidates.
+func currencyCondition(condition string, rates RateSnapshot, rMargins ReqMargins, args func(minPrice, maxPrice Money) []interface{}) (string, []interface{}) {
+	conditions := []string{}
+	conditionArgs := []interface{}{}
+	for _, currency := range rates.Currencies() {
+		minPrice, minOK := rates.FromBase(currency, rMargins.MinPrice)
+		maxPrice, maxOK := rates.FromBase(currency, rMargins.MaxPrice)
+		if !minOK || !maxOK {
+			log.Printf("skipping the %s candidates, the margins can't be converted from %s", currency, rates.Base)
+			continue
+		}
+		currencies := []string{currency}
+		if currency == rates.Base {
+			currencies = append(currencies, "")
+		}
+		conditions = append(conditions, fmt.Sprintf(currencyWindowCondition, condition))
+		conditionArgs = append(conditionArgs, currencies)
+		conditionArgs = append(conditionArgs, args(minPrice, maxPrice)...)
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", conditionArgs
+}
+
+func propAttributesArgs(w AttributeWindows) []interface{} {
+	// the unknown property type and furnishing are always within the windows
+	return []interface{}{
+		append(w.PropertyTypes.Names(), ""), append(w.Furnishings.Names(), ""), w.MinArea, w.MaxArea,
+		w.MinParking, w.MaxParking, w.Amenities, w.Amenities,
+	}
+}
+
+func reqAttributesArgs(w AttributeWindows) []interface{} {
+	return []interface{}{
+		w.PropertyTypes, w.Furnishings, w.MaxArea, w.MinArea,
+		w.MinParking, w.MaxParking, w.Amenities,
+	}
+}
+
+// propFilters returns the filters condition of the query on the properties table along with its args
+func propFilters(q CandidateQuery) (string, []interface{}) {
+	condition, args := currencyCondition(propPriceCondition, q.Rates, q.Margins, propPriceArgs)
+	args = append(args, propWindowsArgs(q.Margins)...)
+	args = append(args, propAttributesArgs(q.Attributes)...)
+	return condition + " AND " + propFiltersCondition, append(args, q.TenantIDs, StatusActive)
+}
+
+// reqFilters returns the filters condition of the query on the requirements table along with its args
+func reqFilters(q CandidateQuery) (string, []interface{}) {
+	condition, args := currencyCondition(reqBudgetCondition, q.Rates, q.Margins, reqBudgetArgs)
+	args = append(args, reqWindowsArgs(q.Margins)...)
+	args = append(args, reqAttributesArgs(q.Attributes)...)
+	return condition + " AND " + reqFiltersCondition, append(args, q.TenantIDs, StatusActive)
+}
+
+// propCandidates returns the bounding box and the filters condition of the query on the properties
+// table along with its args
+func propCandidates(q CandidateQuery) (string, []interface{}) {
+	condition, args := propFilters(q)
+	return boundingBoxCondition + " AND " + condition, append(boundingBoxArgs(q.Margins), args...)
+}
+
+func reqCandidates(q CandidateQuery) (string, []interface{}) {
+	condition, args := reqFilters(q)
+	return boundingBoxCondition + " AND " + condition, append(boundingBoxArgs(q.Margins), args...)
+}
+
+// gormPropertyStore has the parts of a PropertyRepository which are the same for every sql
+// database supported by gorm. The sql repositories embed it and only add FindCandidates.
+type gormPropertyStore struct {
+	DB *gorm.DB
+}
+
+func (store gormPropertyStore) Save(p *Property) error {
+	err := store.DB.Create(p).Error
+	if err != nil {
+		log.Printf("PropertyRepository unable to insert property: (prop: %v, err: %v)", p, err)
+		return errors.Wrap(err, "PropertyRepository couldn't insert property")
+	}
+	return nil
+}
+
+func (store gormPropertyStore) SaveWithEvents(p *Property, events []MatchEvent) error {
+	tx := store.DB.Begin()
+	// a stored property has its PropertyID already, it is overwritten like with Update
+	save := tx.Create
+	if p.PropertyID != 0 {
+		save = tx.Save
+	}
+	if err := save(p).Error; err != nil {
+		tx.Rollback()
+		log.Printf("PropertyRepository unable to save property: (prop: %v, err: %v)", p, err)
+		return errors.Wrap(err, "PropertyRepository couldn't save property")
+	}
+	for i := range events {
+		events[i].PropertyID = p.PropertyID
+	}
+	if err := insertOutboxEvents(tx, events); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "PropertyRepository couldn't insert match events")
+	}
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("PropertyRepository unable to commit property: (prop: %v, err: %v)", p, err)
+		return errors.Wrap(err, "PropertyRepository couldn't commit property")
+	}
+	return nil
+}
+
+func (store gormPropertyStore) FindByID(id uint64) (Property, error) {
+	var p Property
+	err := store.DB.Where("property_id = ?", id).First(&p).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return p, errors.Wrapf(ErrNotFound, "property %d", id)
+	}
+	if err != nil {
+		log.Printf("PropertyRepository unable to find property: (id: %d, err: %v)", id, err)
+		return p, errors.Wrap(err, "PropertyRepository couldn't find property")
+	}
+	return p, nil
+}
+
+func (store gormPropertyStore) FindByOwner(ownerID uint64) ([]Property, error) {
+	properties := []Property{}
+	err := store.DB.Where("owner_id = ?", ownerID).Order("property_id").Find(&properties).Error
+	if err != nil {
+		log.Printf("PropertyRepository unable to find properties of owner: (owner: %d, err: %v)", ownerID, err)
+		return properties, errors.Wrap(err, "PropertyRepository couldn't find properties of owner")
+	}
+	return properties, nil
+}
+
+func (store gormPropertyStore) Update(p *Property) error {
+	err := store.DB.Save(p).Error
+	if err != nil {
+		log.Printf("PropertyRepository unable to update property: (prop: %v, err: %v)", p, err)
+		return errors.Wrap(err, "PropertyRepository couldn't update property")
+	}
+	return nil
+}
+
+func (store gormPropertyStore) Delete(id uint64) error {
+	res := store.DB.Where("property_id = ?", id).Delete(&Property{})
+	if res.Error != nil {
+		log.Printf("PropertyRepository unable to delete property: (id: %d, err: %v)", id, res.Error)
+		return errors.Wrap(res.Error, "PropertyRepository couldn't delete property")
+	}
+	if res.RowsAffected == 0 {
+		return errors.Wrapf(ErrNotFound, "property %d", id)
+	}
+	return nil
+}
+
+func (store gormPropertyStore) ExpireDue(now, addedBefore time.Time, limit int) ([]Property, error) {
+	properties := []Property{}
+
+	tx := store.DB.Begin()
+	err := tx.Where(expireDueCondition, StatusActive, now.UTC(), addedBefore.UTC()).Order("property_id").Limit(limit).Find(&properties).Error
+	if err != nil || len(properties) == 0 {
+		tx.Rollback()
+		return properties, errors.Wrap(err, "PropertyRepository couldn't find due properties")
+	}
+
+	// every row is expired only if it is still due, so that the ones paused, fulfilled or renewed since
+	// they were found are left alone and get no expiry event
+	expired := properties[:0]
+	events := []StatusEvent{}
+	for _, x := range properties {
+		res := tx.Model(&Property{}).Where("property_id = ?", x.PropertyID).Where(expireDueCondition, StatusActive, now.UTC(), addedBefore.UTC()).UpdateColumn("status", StatusExpired)
+		if res.Error != nil {
+			tx.Rollback()
+			log.Printf("PropertyRepository unable to expire property: (id: %d, err: %v)", x.PropertyID, res.Error)
+			return nil, errors.Wrap(res.Error, "PropertyRepository couldn't expire properties")
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		x.Status = StatusExpired
+		expired = append(expired, x)
+		events = append(events, NewPropertyExpiredEvent(x, now))
+	}
+	if err = insertStatusEvents(tx, events); err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "PropertyRepository couldn't insert status events")
+	}
+	if err = tx.Commit().Error; err != nil {
+		return nil, errors.Wrap(err, "PropertyRepository couldn't commit expired properties")
+	}
+	return expired, nil
+}
+
+// gormRequirementStore is the requirements counterpart of gormPropertyStore
+type gormRequirementStore struct {
+	DB *gorm.DB
+}
+
+func (store gormRequirementStore) Save(r *Requirement) error {
+	err := store.DB.Create(r).Error
+	if err != nil {
+		log.Printf("RequirementRepository unable to insert requirement: (req: %v, err: %v)", r, err)
+		return errors.Wrap(err, "RequirementRepository couldn't insert requirement")
+	}
+	return nil
+}
+
+func (store gormRequirementStore) SaveWithEvents(r *Requirement, events []MatchEvent) error {
+	tx := store.DB.Begin()
+	// a stored requirement has its RequirementID already, it is overwritten like with Update
+	save := tx.Create
+	if r.RequirementID != 0 {
+		save = tx.Save
+	}
+	if err := save(r).Error; err != nil {
+		tx.Rollback()
+		log.Printf("RequirementRepository unable to save requirement: (req: %v, err: %v)", r, err)
+		return errors.Wrap(err, "RequirementRepository couldn't save requirement")
+	}
+	for i := range events {
+		events[i].RequirementID = r.RequirementID
+	}
+	if err := insertOutboxEvents(tx, events); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "RequirementRepository couldn't insert match events")
+	}
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("RequirementRepository unable to commit requirement: (req: %v, err: %v)", r, err)
+		return errors.Wrap(err, "RequirementRepository couldn't commit requirement")
+	}
+	return nil
+}
+
+func (store gormRequirementStore) FindByID(id uint64) (Requirement, error) {
+	var r Requirement
+	err := store.DB.Where("requirement_id = ?", id).First(&r).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return r, errors.Wrapf(ErrNotFound, "requirement %d", id)
+	}
+	if err != nil {
+		log.Printf("RequirementRepository unable to find requirement: (id: %d, err: %v)", id, err)
+		return r, errors.Wrap(err, "RequirementRepository couldn't find requirement")
+	}
+	return r, nil
+}
+
+func (store gormRequirementStore) FindByOwner(ownerID uint64) ([]Requirement, error) {
+	requirements := []Requirement{}
+	err := store.DB.Where("owner_id = ?", ownerID).Order("requirement_id").Find(&requirements).Error
+	if err != nil {
+		log.Printf("RequirementRepository unable to find requirements of owner: (owner: %d, err: %v)", ownerID, err)
+		return requirements, errors.Wrap(err, "RequirementRepository couldn't find requirements of owner")
+	}
+	return requirements, nil
+}
+
+func (store gormRequirementStore) Update(r *Requirement) error {
+	err := store.DB.Save(r).Error
+	if err != nil {
+		log.Printf("RequirementRepository unable to update requirement: (req: %v, err: %v)", r, err)
+		return errors.Wrap(err, "RequirementRepository couldn't update requirement")
+	}
+	return nil
+}
+
+func (store gormRequirementStore) Delete(id uint64) error {
+	res := store.DB.Where("requirement_id = ?", id).Delete(&Requirement{})
+	if res.Error != nil {
+		log.Printf("RequirementRepository unable to delete requirement: (id: %d, err: %v)", id, res.Error)
+		return errors.Wrap(res.Error, "RequirementRepository couldn't delete requirement")
+	}
+	if res.RowsAffected == 0 {
+		return errors.Wrapf(ErrNotFound, "requirement %d", id)
+	}
+	return nil
+}
+
+func (store gormRequirementStore) ExpireDue(now, addedBefore time.Time, limit int) ([]Requirement, error) {
+	requirements := []Requirement{}
+
+	tx := store.DB.Begin()
+	err := tx.Where(expireDueCondition, StatusActive, now.UTC(), addedBefore.UTC()).Order("requirement_id").Limit(limit).Find(&requirements).Error
+	if err != nil || len(requirements) == 0 {
+		tx.Rollback()
+		return requirements, errors.Wrap(err, "RequirementRepository couldn't find due requirements")
+	}
+
+	// every row is expired only if it is still due, so that the ones paused, fulfilled or renewed since
+	// they were found are left alone and get no expiry event
+	expired := requirements[:0]
+	events := []StatusEvent{}
+	for _, x := range requirements {
+		res := tx.Model(&Requirement{}).Where("requirement_id = ?", x.RequirementID).Where(expireDueCondition, StatusActive, now.UTC(), addedBefore.UTC()).UpdateColumn("status", StatusExpired)
+		if res.Error != nil {
+			tx.Rollback()
+			log.Printf("RequirementRepository unable to expire requirement: (id: %d, err: %v)", x.RequirementID, res.Error)
+			return nil, errors.Wrap(res.Error, "RequirementRepository couldn't expire requirements")
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		x.Status = StatusExpired
+		expired = append(expired, x)
+		events = append(events, NewRequirementExpiredEvent(x, now))
+	}
+	if err = insertStatusEvents(tx, events); err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "RequirementRepository couldn't insert status events")
+	}
+	if err = tx.Commit().Error; err != nil {
+		return nil, errors.Wrap(err, "RequirementRepository couldn't commit expired requirements")
+	}
+	return expired, nil
+}
+
+// GormOwnerRepo is the OwnerRepository of every sql store
+type GormOwnerRepo struct {
+	DB *gorm.DB
+}
+
+func NewGormOwnerRepo(db *gorm.DB) GormOwnerRepo {
+	return GormOwnerRepo{
+		DB: db,
+	}
+}
+
+func (repo GormOwnerRepo) Save(o *Owner) error {
+	err := repo.DB.Create(o).Error
+	if err != nil {
+		log.Printf("OwnerRepository unable to insert owner: (owner: %v, err: %v)", o, err)
+		return errors.Wrap(err, "OwnerRepository couldn't insert owner")
+	}
+	return nil
+}
+
+func (repo GormOwnerRepo) FindByID(id uint64) (Owner, error) {
+	var o Owner
+	err := repo.DB.Where("owner_id = ?", id).First(&o).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return o, errors.Wrapf(ErrNotFound, "owner %d", id)
+	}
+	if err != nil {
+		log.Printf("OwnerRepository unable to find owner: (id: %d, err: %v)", id, err)
+		return o, errors.Wrap(err, "OwnerRepository couldn't find owner")
+	}
+	return o, nil
+}
+
+// GormTenantRepo is the TenantRepository of every sql store
+type GormTenantRepo struct {
+	DB *gorm.DB
+}
+
+func NewGormTenantRepo(db *gorm.DB) GormTenantRepo {
+	return GormTenantRepo{
+		DB: db,
+	}
+}
+
+func (repo GormTenantRepo) Save(t *Tenant) error {
+	err := repo.DB.Create(t).Error
+	if err != nil {
+		log.Printf("TenantRepository unable to insert tenant: (tenant: %v, err: %v)", t, err)
+		return errors.Wrap(err, "TenantRepository couldn't insert tenant")
+	}
+	return nil
+}
+
+func (repo GormTenantRepo) FindByID(id uint64) (Tenant, error) {
+	var t Tenant
+	err := repo.DB.Where("tenant_id = ?", id).First(&t).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return t, errors.Wrapf(ErrNotFound, "tenant %d", id)
+	}
+	if err != nil {
+		log.Printf("TenantRepository unable to find tenant: (id: %d, err: %v)", id, err)
+		return t, errors.Wrap(err, "TenantRepository couldn't find tenant")
+	}
+	return t, nil
+}
+
+func (repo GormTenantRepo) Update(t *Tenant) error {
+	err := repo.DB.Save(t).Error
+	if err != nil {
+		log.Printf("TenantRepository unable to update tenant: (tenant: %v, err: %v)", t, err)
+		return errors.Wrap(err, "TenantRepository couldn't update tenant")
+	}
+	return nil
+}
+
+func (repo GormTenantRepo) SaveAgreement(a *SharingAgreement) error {
+	var count int
+	err := repo.DB.Model(&SharingAgreement{}).
+		Where("sharing_tenant_id = ? AND partner_tenant_id = ?", a.SharingTenantID, a.PartnerTenantID).Count(&count).Error
+	if err == nil && count == 0 {
+		err = repo.DB.Create(a).Error
+	}
+	if err != nil {
+		log.Printf("TenantRepository unable to insert sharing agreement: (agreement: %v, err: %v)", a, err)
+		return errors.Wrap(err, "TenantRepository couldn't insert sharing agreement")
+	}
+	return nil
+}
+
+func (repo GormTenantRepo) DeleteAgreement(sharingTenantID, partnerTenantID uint64) error {
+	res := repo.DB.Where("sharing_tenant_id = ? AND partner_tenant_id = ?", sharingTenantID, partnerTenantID).Delete(&SharingAgreement{})
+	if res.Error != nil {
+		log.Printf("TenantRepository unable to delete sharing agreement: (sharing: %d, partner: %d, err: %v)", sharingTenantID, partnerTenantID, res.Error)
+		return errors.Wrap(res.Error, "TenantRepository couldn't delete sharing agreement")
+	}
+	if res.RowsAffected == 0 {
+		return errors.Wrapf(ErrNotFound, "sharing agreement of tenant %d with %d", sharingTenantID, partnerTenantID)
+	}
+	return nil
+}
+
+func (repo GormTenantRepo) SharingTenants(partnerTenantID uint64) ([]uint64, error) {
+	ids := []uint64{}
+	err := repo.DB.Model(&SharingAgreement{}).Where("partner_tenant_id = ?", partnerTenantID).Order("sharing_tenant_id").Pluck("sharing_tenant_id", &ids).Error
+	if err != nil {
+		return ids, errors.Wrap(err, "TenantRepository couldn't find sharing tenants")
+	}
+	return ids, nil
+}
+
+func (repo GormTenantRepo) PartnerTenants(sharingTenantID uint64) ([]uint64, error) {
+	ids := []uint64{}
+	err := repo.DB.Model(&SharingAgreement{}).Where("sharing_tenant_id = ?", sharingTenantID).Order("partner_tenant_id").Pluck("partner_tenant_id", &ids).Error
+	if err != nil {
+		return ids, errors.Wrap(err, "TenantRepository couldn't find partner tenants")
+	}
+	return ids, nil
+}
+
+// GormAPIKeyRepo is the APIKeyRepository of every sql store
+type GormAPIKeyRepo struct {
+	DB *gorm.DB
+}
+
+func NewGormAPIKeyRepo(db *gorm.DB) GormAPIKeyRepo {
+	return GormAPIKeyRepo{
+		DB: db,
+	}
+}
+
+func (repo GormAPIKeyRepo) Save(k *APIKey) error {
+	err := repo.DB.Create(k).Error
+	if err != nil {
+		log.Printf("APIKeyRepository unable to insert key: (name: %s, err: %v)", k.Name, err)
+		return errors.Wrap(err, "APIKeyRepository couldn't insert key")
+	}
+	return nil
+}
+
+func (repo GormAPIKeyRepo) FindByHash(hash string) (APIKey, error) {
+	var k APIKey
+	err := repo.DB.Where("hash = ?", hash).First(&k).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return k, errors.Wrap(ErrNotFound, "api key")
+	}
+	if err != nil {
+		log.Printf("APIKeyRepository unable to find key: %v", err)
+		return k, errors.Wrap(err, "APIKeyRepository couldn't find key")
+	}
+	return k, nil
+}
+
+func (repo GormAPIKeyRepo) FindByTenant(tenantID uint64) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := repo.DB.Where("tenant_id = ?", tenantID).Order("key_id").Find(&keys).Error
+	if err != nil {
+		log.Printf("APIKeyRepository unable to find keys: (tenant: %d, err: %v)", tenantID, err)
+		return keys, errors.Wrap(err, "APIKeyRepository couldn't find keys")
+	}
+	return keys, nil
+}
+
+func (repo GormAPIKeyRepo) Revoke(id uint64, at time.Time) error {
+	var count int
+	err := repo.DB.Model(&APIKey{}).Where("key_id = ?", id).Count(&count).Error
+	if err == nil && count == 0 {
+		return errors.Wrapf(ErrNotFound, "api key %d", id)
+	}
+	if err == nil {
+		// a key revoked again keeps the time it was first revoked at
+		err = repo.DB.Model(&APIKey{}).Where("key_id = ? AND revoked_at IS NULL", id).UpdateColumn("revoked_at", at.UTC()).Error
+	}
+	if err != nil {
+		log.Printf("APIKeyRepository unable to revoke key: (id: %d, err: %v)", id, err)
+		return errors.Wrap(err, "APIKeyRepository couldn't revoke key")
+	}
+	return nil
+}
+
+// insertOutboxEvents stores the match events in the outbox as part of the transaction tx
+func insertOutboxEvents(tx *gorm.DB, events []MatchEvent) error {
+	for _, e := range events {
+		row, err := NewOutboxEvent(e)
+		if err != nil {
+			return err
+		}
+		if err = tx.Create(&row).Error; err != nil {
+			log.Printf("OutboxRepository unable to insert event: (event: %v, err: %v)", e, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// insertStatusEvents stores the status events in the outbox as part of the transaction tx
+func insertStatusEvents(tx *gorm.DB, events []StatusEvent) error {
+	for _, e := range events {
+		row, err := NewStatusOutboxEvent(e)
+		if err != nil {
+			return err
+		}
+		if err = tx.Create(&row).Error; err != nil {
+			log.Printf("OutboxRepository unable to insert event: (event: %v, err: %v)", e, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// GormOutboxRepo is the OutboxRepository of every sql store, the outbox queries need no dialect specifics
+type GormOutboxRepo struct {
+	DB *gorm.DB
+}
+
+func NewGormOutboxRepo(db *gorm.DB) GormOutboxRepo {
+	return GormOutboxRepo{
+		DB: db,
+	}
+}
+
+func (repo GormOutboxRepo) FetchPending(now time.Time, limit int) ([]OutboxEvent, error) {
+	events := []OutboxEvent{}
+	err := repo.DB.Where("published_at IS NULL AND next_attempt_at <= ?", now.UTC()).Order("event_id").Limit(limit).Find(&events).Error
+	if err != nil {
+		log.Printf("OutboxRepository unable to fetch pending events: %v", err)
+		return events, errors.Wrap(err, "OutboxRepository couldn't fetch pending events")
+	}
+	return events, nil
+}
+
+func (repo GormOutboxRepo) MarkPublished(id uint64, at time.Time) error {
+	err := repo.DB.Model(&OutboxEvent{}).Where("event_id = ?", id).UpdateColumn("published_at", at.UTC()).Error
+	if err != nil {
+		return errors.Wrap(err, "OutboxRepository couldn't mark event as published")
+	}
+	return nil
+}
+
+func (repo GormOutboxRepo) MarkFailed(id uint64, nextAttemptAt time.Time, reason string) error {
+	err := repo.DB.Model(&OutboxEvent{}).Where("event_id = ?", id).UpdateColumns(map[string]interface{}{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"next_attempt_at": nextAttemptAt.UTC(),
+		"last_error":      reason,
+	}).Error
+	if err != nil {
+		return errors.Wrap(err, "OutboxRepository couldn't mark event as failed")
+	}
+	return nil
+}
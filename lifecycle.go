@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// EventPropertyExpired is published when the ExpirySweeper expires a property listing
+	EventPropertyExpired = "property_expired"
+	// EventRequirementExpired is published when the ExpirySweeper expires a requirement
+	EventRequirementExpired = "requirement_expired"
+)
+
+// StatusEvent tells that a stored record changed its lifecycle status, eg: that it expired.
+// Only one of RequirementID and PropertyID is set.
+type StatusEvent struct {
+	Kind          string    `json:"kind"`
+	TenantID      uint64    `json:"tenant_id"`
+	RequirementID uint64    `json:"requirement_id,omitempty"`
+	PropertyID    uint64    `json:"property_id,omitempty"`
+	Status        string    `json:"status"`
+	ChangedAt     time.Time `json:"changed_at"`
+}
+
+func NewPropertyExpiredEvent(p Property, at time.Time) StatusEvent {
+	return StatusEvent{
+		Kind:       EventPropertyExpired,
+		TenantID:   p.TenantID,
+		PropertyID: p.PropertyID,
+		Status:     StatusExpired,
+		ChangedAt:  at,
+	}
+}
+
+func NewRequirementExpiredEvent(r Requirement, at time.Time) StatusEvent {
+	return StatusEvent{
+		Kind:          EventRequirementExpired,
+		TenantID:      r.TenantID,
+		RequirementID: r.RequirementID,
+		Status:        StatusExpired,
+		ChangedAt:     at,
+	}
+}
+
+// ExpirySweeper expires the active properties and requirements past their ExpiresAt every Interval,
+// so that they stop being matched. The records stored before expiry existed have no ExpiresAt and
+// expire PropertyTTL or RequirementTTL after their AddedDate.
+type ExpirySweeper struct {
+	PropRepo       PropertyRepository
+	ReqRepo        RequirementRepository
+	PropertyTTL    time.Duration
+	RequirementTTL time.Duration
+	Interval       time.Duration
+	BatchSize      int
+}
+
+func NewExpirySweeper(propRepo PropertyRepository, reqRepo RequirementRepository, propertyTTL, requirementTTL, interval time.Duration) ExpirySweeper {
+	return ExpirySweeper{
+		PropRepo:       propRepo,
+		ReqRepo:        reqRepo,
+		PropertyTTL:    propertyTTL,
+		RequirementTTL: requirementTTL,
+		Interval:       interval,
+		BatchSize:      500,
+	}
+}
+
+// Run sweeps every Interval until stop is closed. It is meant to be run in its own goroutine.
+func (sweeper ExpirySweeper) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(sweeper.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := sweeper.Sweep(now); err != nil {
+				log.Printf("ExpirySweeper couldn't expire records: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Sweep expires all the properties and requirements due at time now, one batch at a time
+func (sweeper ExpirySweeper) Sweep(now time.Time) error {
+	for {
+		props, err := sweeper.PropRepo.ExpireDue(now, now.Add(-sweeper.PropertyTTL), sweeper.BatchSize)
+		if err != nil {
+			return errors.Wrap(err, "ExpirySweeper couldn't expire properties")
+		}
+		if len(props) > 0 {
+			log.Printf("ExpirySweeper expired %d properties", len(props))
+		}
+		if len(props) < sweeper.BatchSize {
+			break
+		}
+	}
+
+	for {
+		reqs, err := sweeper.ReqRepo.ExpireDue(now, now.Add(-sweeper.RequirementTTL), sweeper.BatchSize)
+		if err != nil {
+			return errors.Wrap(err, "ExpirySweeper couldn't expire requirements")
+		}
+		if len(reqs) > 0 {
+			log.Printf("ExpirySweeper expired %d requirements", len(reqs))
+		}
+		if len(reqs) < sweeper.BatchSize {
+			return nil
+		}
+	}
+}
+
+// StatusRequest is the DTO used to change the status of a stored record, eg: to pause a listing or
+// to mark a requirement as fulfilled. ExpiresAt optionally sets a new expiry at the same time.
+type StatusRequest struct {
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// validate returns an error caused by ErrValidation describing the first bad field of the request
+func (s StatusRequest) validate() error {
+	if !validStatus(s.Status) {
+		return errors.Wrapf(ErrValidation, "bad status: %q, must be %q, %q or %q", s.Status, StatusActive, StatusPaused, StatusFulfilled)
+	}
+	if !validExpiresAt(s.ExpiresAt) {
+		return errors.Wrapf(ErrValidation, "bad expires_at: %s, must be in the future", s.ExpiresAt)
+	}
+	return nil
+}
+
+// expiryOf returns when a new record expires, ie: at the given expiry if any, or a ttl from now
+func expiryOf(expiresAt *time.Time, ttl time.Duration, now time.Time) *time.Time {
+	if expiresAt != nil {
+		at := expiresAt.UTC()
+		return &at
+	}
+	at := now.Add(ttl).UTC()
+	return &at
+}
+
+// renewedExpiry returns when a record having the current expiry expires after its status changed.
+// A record made active again without a new expiry gets a fresh ttl if it has no expiry or a past one,
+// so that the ExpirySweeper does not expire it again right away.
+func renewedExpiry(current *time.Time, s StatusRequest, ttl time.Duration, now time.Time) *time.Time {
+	if s.ExpiresAt != nil || (s.Status == StatusActive && (current == nil || !current.After(now))) {
+		return expiryOf(s.ExpiresAt, ttl, now)
+	}
+	return current
+}
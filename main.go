@@ -1,36 +1,258 @@
 package main
 
-import "log"
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
 
 func main() {
 	// step 1: read configs
+	cfg := LoadConfig()
+
+	// the admin commands, eg: "matcher keys issue -tenant 1 -name crm", run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, os.Args[1:], os.Stdout))
+	}
+
+	policy, err := LoadMatchPolicy(cfg.PolicyFile)
+	if err != nil {
+		log.Fatalf("Unable to load the match policy: %v", err)
+	}
 
 	// step 2: add dependecies (Dependency Injections)
-	reqProcessor, propProcessor := dependencgInjections()
+	app := dependencgInjections(cfg, policy)
+
+	// step 3: start the background workers, they run for the lifetime of the app
+	go app.Notifications.Run(nil)
+	go app.OutboxRelay.Run(nil)
+	go app.ExpirySweeper.Run(nil)
 
-	// Simulate using the above usecase processors to do something
-	log.Printf("Got %v and %v", reqProcessor, propProcessor)
+	// step 4: add routes and attach controllers to web app and start server
+	api := NewAPIServer(app.ReqProcessor, app.PropProcessor, app.OwnerProcessor, app.TenantProcessor, app.Inbox, newAuthenticator(cfg, app.KeyProcessor))
+	server := &http.Server{
+		Addr:         cfg.HTTPAddr,
+		Handler:      api.Routes(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	log.Printf("Starting api server on %s", cfg.HTTPAddr)
+	log.Fatal(server.ListenAndServe())
+}
 
-	// step 3: add routes and attach controllers to web app and start server
+// App holds the usecase processors and the infrastructure shared by the api and the background workers
+type App struct {
+	ReqProcessor    ReqProcessor
+	PropProcessor   PropProcessor
+	OwnerProcessor  OwnerProcessor
+	TenantProcessor TenantProcessor
+	KeyProcessor    KeyProcessor
+	Notifications   *NotificationDispatcher
+	Inbox           *Inbox
+	OutboxRelay     OutboxRelay
+	ExpirySweeper   ExpirySweeper
+	// Broker is where in process consumers subscribe to the match events when OUTBOX_SINK is "broker"
+	Broker *LocalBroker
 }
 
 // dependencgInjections is like a dependency injector which initiates all different
 // infrastructre objects and instances and adds its to the App instance which can
 // be passed anywhere down the dependency tree
-func dependencgInjections() (ReqProcessor, PropProcessor) {
-	// get a single DB connection/pool
-	db, err := NewDBClient()
-	if err != nil {
-		panic("Unable to get a DB connection")
-	}
+func dependencgInjections(cfg Config, policy MatchPolicy) App {
+	repos := newRepositories(cfg)
+
+	rAlgo, pAlgo := newMatchingAlgos(cfg)
+	rates := newRateProvider(cfg)
 
-	rAlgo := NewReqMatchingAlgo()
-	pAlgo := NewPropMatchingAlgo()
+	inbox := NewInbox(100)
+	notifications := NewNotificationDispatcher(NewOwnerRecipientResolver(repos.Owners), newNotificationChannels(cfg, inbox), cfg.NotifyDigestInterval, cfg.NotifyRateLimit, time.Hour)
 
-	reqProcessor := NewReqProcessor(db, rAlgo)
-	propProcessor := NewPropProcessor(db, pAlgo)
+	broker := NewLocalBroker()
+	relay := NewOutboxRelay(repos.Outbox, newEventSink(cfg, broker), cfg.OutboxPollInterval)
+	sweeper := NewExpirySweeper(repos.Properties, repos.Requirements, cfg.PropertyTTL, cfg.RequirementTTL, cfg.ExpirySweepInterval)
+
+	reqProcessor := NewReqProcessor(repos.Requirements, repos.Properties, repos.Owners, repos.Tenants, rAlgo, policy, cfg.RequirementTTL, notifications, rates)
+	propProcessor := NewPropProcessor(repos.Properties, repos.Requirements, repos.Owners, repos.Tenants, pAlgo, policy, cfg.PropertyTTL, notifications, rates)
+	ownerProcessor := NewOwnerProcessor(repos.Owners, repos.Requirements, repos.Properties, repos.Tenants)
+	tenantProcessor := NewTenantProcessor(repos.Tenants, policy)
+	keyProcessor := NewKeyProcessor(repos.APIKeys, repos.Tenants, repos.Owners)
 
 	// Here we use r and p to perform the usecasaes
 	// API handler/cotrollers will have access to r and p to perform the usecases
-	return reqProcessor, propProcessor
+	return App{
+		ReqProcessor:    reqProcessor,
+		PropProcessor:   propProcessor,
+		OwnerProcessor:  ownerProcessor,
+		TenantProcessor: tenantProcessor,
+		KeyProcessor:    keyProcessor,
+		Notifications:   notifications,
+		Inbox:           inbox,
+		OutboxRelay:     relay,
+		ExpirySweeper:   sweeper,
+		Broker:          broker,
+	}
+}
+
+// newAuthenticator returns the auth layer of the api, or nil if it is disabled. The keys of the memory
+// store can't be issued by the admin cli, so its key is taken from the config or else generated, and
+// only printed to stdout when asked to since the logs are usually shipped elsewhere.
+func newAuthenticator(cfg Config, keys KeyProcessor) *Authenticator {
+	if !cfg.AuthEnabled {
+		log.Println("The api auth is disabled, the tenant of a request is taken from its X-Tenant-ID header")
+		return nil
+	}
+	if cfg.Store == "memory" {
+		issueMemoryAPIKey(cfg, keys, os.Stdout)
+	}
+	return NewAuthenticator(keys, NewRateLimiter(time.Minute))
+}
+
+// issueMemoryAPIKey stores the API key of the memory store, which has the scopes of the config and
+// no admin scope unless they include it
+func issueMemoryAPIKey(cfg Config, keys KeyProcessor, out io.Writer) {
+	scopes := strings.Split(cfg.MemoryAPIKeyScopes, ",")
+	if cfg.MemoryAPIKey != "" {
+		key, err := keys.ImportKey(0, 0, "memory store", cfg.MemoryAPIKey, scopes, 0)
+		if err != nil {
+			log.Printf("Unable to import the memory store api key: %v", err)
+			panic("Unable to import the api key")
+		}
+		log.Printf("Imported the api key %s... of the memory store with scopes %q", key.Prefix, key.Scopes)
+		return
+	}
+
+	key, secret, err := keys.IssueKey(0, 0, "memory store", scopes, 0)
+	if err != nil {
+		log.Printf("Unable to issue the memory store api key: %v", err)
+		panic("Unable to issue an api key")
+	}
+	if !cfg.PrintMemoryAPIKey {
+		log.Printf("Issued the api key %s... of the memory store with scopes %q, set PRINT_MEMORY_API_KEY=true to print it or MEMORY_API_KEY to choose it", key.Prefix, key.Scopes)
+		return
+	}
+	log.Printf("Issued the api key %s... of the memory store with scopes %q", key.Prefix, key.Scopes)
+	fmt.Fprintf(out, "memory store api key: %s\n", secret)
+}
+
+// newNotificationChannels returns the in-app inbox along with the webhook and email channels which are configured
+func newNotificationChannels(cfg Config, inbox *Inbox) []NotificationChannel {
+	channels := []NotificationChannel{inbox}
+	if cfg.NotifyWebhookURL != "" {
+		channels = append(channels, NewWebhookChannel(cfg.NotifyWebhookURL))
+	}
+	if cfg.NotifySMTPAddr != "" {
+		channels = append(channels, NewEmailChannel(cfg.NotifySMTPAddr, cfg.NotifyEmailFrom))
+	}
+	return channels
+}
+
+// newMatchingAlgos returns the matching algorithms selected by MATCH_ALGORITHM
+func newMatchingAlgos(cfg Config) (ReqMatchingAlgo, PropMatchingAlgo) {
+	switch cfg.MatchAlgorithm {
+	case "v1":
+		return NewReqMatchingAlgo(), NewPropMatchingAlgo()
+	case "v2":
+		return NewReqMatchingAlgoV2(), NewPropMatchingAlgoV2()
+	case "v3":
+		return NewReqMatchingAlgoV3(), NewPropMatchingAlgoV3()
+	default:
+		panic("Unknown match algorithm: " + cfg.MatchAlgorithm)
+	}
+}
+
+// newRateProvider returns the exchange rates of EXCHANGE_RATES_FILE, or only the base currency without one
+func newRateProvider(cfg Config) RateProvider {
+	if !validCurrencyCode(cfg.BaseCurrency) {
+		panic("Bad BASE_CURRENCY, must be an ISO 4217 code: " + cfg.BaseCurrency)
+	}
+	if cfg.ExchangeRatesFile == "" {
+		return NewStaticRateProvider(cfg.BaseCurrency)
+	}
+	rates, err := NewFileRateProvider(cfg.BaseCurrency, cfg.ExchangeRatesFile)
+	if err != nil {
+		log.Printf("Unable to load the exchange rates: %v", err)
+		panic("Unable to create the rate provider")
+	}
+	return rates
+}
+
+// newEventSink creates the configured sink of the outbox relay
+func newEventSink(cfg Config, broker *LocalBroker) EventSink {
+	switch cfg.OutboxSink {
+	case "log":
+		sink, err := NewLogFileSink(cfg.OutboxLogPath)
+		if err != nil {
+			log.Printf("Unable to open the outbox log: %v", err)
+			panic("Unable to create the outbox sink")
+		}
+		return sink
+	case "webhook":
+		if cfg.OutboxWebhookURL == "" {
+			panic("OUTBOX_WEBHOOK_URL is needed by the webhook outbox sink")
+		}
+		return NewWebhookSink(cfg.OutboxWebhookURL)
+	case "broker":
+		return NewBrokerSink(broker, "match_events")
+	default:
+		panic("Unknown outbox sink: " + cfg.OutboxSink)
+	}
+}
+
+// Repositories are the storage gateways of the configured store
+type Repositories struct {
+	Requirements RequirementRepository
+	Properties   PropertyRepository
+	Owners       OwnerRepository
+	Tenants      TenantRepository
+	APIKeys      APIKeyRepository
+	Outbox       OutboxRepository
+}
+
+// newRepositories creates the repositories of the configured store
+func newRepositories(cfg Config) Repositories {
+	switch cfg.Store {
+	case "memory":
+		log.Println("Using the in memory store, nothing will be persisted")
+		outbox := NewMemoryOutboxRepo()
+		return Repositories{
+			Requirements: NewMemoryRequirementRepo(outbox),
+			Properties:   NewMemoryPropertyRepo(outbox),
+			Owners:       NewMemoryOwnerRepo(),
+			Tenants:      NewMemoryTenantRepo(),
+			APIKeys:      NewMemoryAPIKeyRepo(),
+			Outbox:       outbox,
+		}
+	case "mysql", "postgres", "sqlite":
+		// get a single DB connection/pool
+		db, err := NewDBClient(cfg)
+		if err != nil {
+			panic("Unable to get a DB connection")
+		}
+		if err = RunMigrations(db); err != nil {
+			log.Printf("Migrations failed: %v", err)
+			panic("Unable to migrate the DB schema")
+		}
+		repos := Repositories{
+			Owners:  NewGormOwnerRepo(db),
+			Tenants: NewGormTenantRepo(db),
+			APIKeys: NewGormAPIKeyRepo(db),
+			Outbox:  NewGormOutboxRepo(db),
+		}
+		switch cfg.Store {
+		case "postgres":
+			repos.Requirements, repos.Properties = NewPostgresRequirementRepo(db), NewPostgresPropertyRepo(db)
+		case "sqlite":
+			repos.Requirements, repos.Properties = NewSQLiteRequirementRepo(db), NewSQLitePropertyRepo(db)
+		default:
+			repos.Requirements, repos.Properties = NewMySQLRequirementRepo(db), NewMySQLPropertyRepo(db)
+		}
+		return repos
+	default:
+		panic("Unknown store: " + cfg.Store)
+	}
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ScoreComponent is the score a single matching parameter contributed to the total match score,
+// out of the maximum it could have contributed, with a human readable reason for it
+type ScoreComponent struct {
+	Score  float32 `json:"score"`
+	Max    float32 `json:"max"`
+	Reason string  `json:"reason"`
+}
+
+func NewScoreComponent(score, max float32, reason string) ScoreComponent {
+	return ScoreComponent{
+		Score:  score,
+		Max:    max,
+		Reason: reason,
+	}
+}
+
+// ScoreBreakdown explains a match score, so that agents can tell their clients why a listing or
+// a requirement was suggested
+type ScoreBreakdown struct {
+	Distance  ScoreComponent `json:"distance"`
+	Budget    ScoreComponent `json:"budget"`
+	Bedrooms  ScoreComponent `json:"bedrooms"`
+	Bathrooms ScoreComponent `json:"bathrooms"`
+	// Freshness is only given by the V2 and V3 matching algorithms
+	Freshness *ScoreComponent `json:"freshness,omitempty"`
+	// Area and Features are only given by the V3 matching algorithms
+	Area     *ScoreComponent `json:"area,omitempty"`
+	Features *ScoreComponent `json:"features,omitempty"`
+}
+
+// NewScoreBreakdown builds the breakdown of a computed Score, w being the weights it was scored with
+func NewScoreBreakdown(s Score, w MatchWeights) ScoreBreakdown {
+	return ScoreBreakdown{
+		Distance:  NewScoreComponent(s.DistanceScore, w.Distance, s.DistanceReason),
+		Budget:    NewScoreComponent(s.BudgetScore, w.Budget, s.BudgetReason),
+		Bedrooms:  NewScoreComponent(s.BedroomScore, w.Bedrooms, s.BedroomReason),
+		Bathrooms: NewScoreComponent(s.BathroomScore, w.Bathrooms, s.BathroomReason),
+	}
+}
+
+// NewFreshScoreBreakdown builds the breakdown of a Score computed by the V2 matching algorithms,
+// which also has the freshness component
+func NewFreshScoreBreakdown(s Score, w MatchWeights) ScoreBreakdown {
+	breakdown := NewScoreBreakdown(s, w)
+	freshness := NewScoreComponent(s.FreshnessScore, w.Freshness, s.FreshnessReason)
+	breakdown.Freshness = &freshness
+	return breakdown
+}
+
+// NewAttributeScoreBreakdown builds the breakdown of a Score computed by the V3 matching algorithms,
+// which also has the area and features components
+func NewAttributeScoreBreakdown(s Score, w MatchWeights) ScoreBreakdown {
+	breakdown := NewFreshScoreBreakdown(s, w)
+	area := NewScoreComponent(s.AreaScore, w.Area, s.AreaReason)
+	features := NewScoreComponent(s.FeaturesScore, w.Features, s.FeaturesReason)
+	breakdown.Area = &area
+	breakdown.Features = &features
+	return breakdown
+}
+
+// DistanceReason explains the distance score in the units, eg: "3.4 miles away" or "5.5 km away",
+// from the distances in miles
+func DistanceReason(distance, baseDistance float32, units DistanceUnits) string {
+	if distance <= baseDistance {
+		return fmt.Sprintf("%.1f %s away, within %.3g %s", units.FromMiles(distance), units.Label(), units.FromMiles(baseDistance), units.Label())
+	}
+	return fmt.Sprintf("%.1f %s away", units.FromMiles(distance), units.Label())
+}
+
+// BudgetReason explains the budget score from the point of view of the requirement, eg:
+// "price 3% above max budget". It follows the same cases as GetBudgetScore.
+func BudgetReason(minBudget, maxBudget, price Money, band float32) string {
+	// case 1: when both minBudget and maxBudget is given
+	if minBudget > 0 && maxBudget > 0 {
+		if price < minBudget {
+			return fmt.Sprintf("price %s below min budget", percentOff(float32(price), float32(minBudget)))
+		}
+		if price > maxBudget {
+			return fmt.Sprintf("price %s above max budget", percentOff(float32(price), float32(maxBudget)))
+		}
+		return "price within budget"
+	}
+
+	// case 2 and 3: only one of minBudget or maxBudget given
+	budget, label := minBudget, "min budget"
+	if minBudget <= 0 {
+		budget, label = maxBudget, "max budget"
+	}
+	if price >= budget.Scaled(-band) && price <= budget.Scaled(band) {
+		return fmt.Sprintf("price within %g%% of %s", band*100, label)
+	}
+	if price < budget {
+		return fmt.Sprintf("price %s below %s", percentOff(float32(price), float32(budget)), label)
+	}
+	return fmt.Sprintf("price %s above %s", percentOff(float32(price), float32(budget)), label)
+}
+
+// RoomsReason explains the bedrooms or bathrooms score, eg: "1 bedroom short". noun is the
+// singular name of the room. It follows the same cases as GetBedroomScore.
+func RoomsReason(minRooms, maxRooms, rooms uint16, noun string) string {
+	// when only one of min or max is given, that exact number is what is wanted
+	if minRooms == 0 {
+		minRooms = maxRooms
+	}
+	if maxRooms == 0 {
+		maxRooms = minRooms
+	}
+
+	if rooms < minRooms {
+		return fmt.Sprintf("%s short", countOf(minRooms-rooms, noun))
+	}
+	if rooms > maxRooms {
+		return fmt.Sprintf("%s more than wanted", countOf(rooms-maxRooms, noun))
+	}
+	if minRooms == maxRooms {
+		return fmt.Sprintf("%s as wanted", countOf(rooms, noun))
+	}
+	return fmt.Sprintf("%ss within range", noun)
+}
+
+// FreshnessReason explains the freshness score, eg: "listed 3 days ago". verb tells what was done
+// at the AddedDate, eg: "listed" or "added".
+func FreshnessReason(age time.Duration, verb string) string {
+	days := int(age.Hours() / 24)
+	switch {
+	case days <= 0:
+		return verb + " today"
+	case days == 1:
+		return verb + " 1 day ago"
+	default:
+		return fmt.Sprintf("%s %d days ago", verb, days)
+	}
+}
+
+// AreaReason explains the area score from the point of view of the requirement, eg:
+// "1100 sq ft, 8% below min area". It follows the same cases as GetAreaScore.
+func AreaReason(minArea, maxArea, area float32) string {
+	if minArea == 0 && maxArea == 0 {
+		return "no area wanted"
+	}
+	if area == 0 {
+		return "area unknown"
+	}
+	if minArea > 0 && area < minArea {
+		return fmt.Sprintf("%.0f sq ft, %s below min area", area, percentOff(area, minArea))
+	}
+	if maxArea > 0 && area > maxArea {
+		return fmt.Sprintf("%.0f sq ft, %s above max area", area, percentOff(area, maxArea))
+	}
+	return fmt.Sprintf("%.0f sq ft, within range", area)
+}
+
+// FeaturesReason explains the features score, eg: "missing pool, gym"
+func FeaturesReason(r RequirementAttributes, p PropertyAttributes) string {
+	wanted, missing := wantedFeatures(r, p)
+	switch {
+	case wanted == 0:
+		return "no features wanted"
+	case len(missing) == 0:
+		return "has all wanted features"
+	default:
+		return "missing " + strings.Join(missing, ", ")
+	}
+}
+
+// percentOff returns how far x is from ref in percent, eg: "3%"
+func percentOff(x, ref float32) string {
+	return fmt.Sprintf("%.0f%%", math.Abs(float64((x-ref)/ref))*100)
+}
+
+func countOf(n uint16, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,764 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// gridCellSize is the size in degrees of a cell of the in memory spatial index. 0.1 degree is
+// roughly 7 miles of latitude, so a 10 miles search only has to visit a handful of cells.
+const gridCellSize = 0.1
+
+type gridCell struct {
+	Lat int
+	Lon int
+}
+
+// gridIndex is a simple spatial index which buckets record ids into fixed size lat/lon cells,
+// so that a bounding box lookup only has to look at the records of the cells it overlaps
+type gridIndex struct {
+	cells map[gridCell][]uint64
+}
+
+func newGridIndex() gridIndex {
+	return gridIndex{
+		cells: make(map[gridCell][]uint64),
+	}
+}
+
+func (g gridIndex) cellOf(lat, lon float32) gridCell {
+	return gridCell{
+		Lat: int(math.Floor(float64(lat) / gridCellSize)),
+		Lon: int(math.Floor(float64(lon) / gridCellSize)),
+	}
+}
+
+func (g gridIndex) insert(id uint64, lat, lon float32) {
+	c := g.cellOf(lat, lon)
+	g.cells[c] = append(g.cells[c], id)
+}
+
+func (g gridIndex) remove(id uint64, lat, lon float32) {
+	c := g.cellOf(lat, lon)
+	ids := g.cells[c]
+	for i := range ids {
+		if ids[i] == id {
+			g.cells[c] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(g.cells[c]) == 0 {
+		delete(g.cells, c)
+	}
+}
+
+// query returns the ids of all the records in the cells overlapping the bounding box. The
+// latitudes are clamped at the poles, but a box crossing the antimeridian, eg: 179 to 181,
+// wraps around to the cells on the other side of it, ie: 179 to 180 and -180 to -179.
+func (g gridIndex) query(minLat, maxLat, minLon, maxLon float32) []uint64 {
+	ids := []uint64{}
+	for _, lons := range lonRanges(minLat, maxLat, minLon, maxLon) {
+		bottomLeft := g.cellOf(clampF(minLat, -90, 90), lons[0])
+		topRight := g.cellOf(clampF(maxLat, -90, 90), lons[1])
+
+		for lat := bottomLeft.Lat; lat <= topRight.Lat; lat++ {
+			for lon := bottomLeft.Lon; lon <= topRight.Lon; lon++ {
+				ids = append(ids, g.cells[gridCell{Lat: lat, Lon: lon}]...)
+			}
+		}
+	}
+	return ids
+}
+
+// spansAllLons tells if a bounding box covers every longitude, ie: when its window is 360 degrees
+// or more, which is what the margins of a search near the poles come to, or when it reaches past
+// a pole, where all the longitudes meet
+func spansAllLons(minLat, maxLat, minLon, maxLon float32) bool {
+	return minLat <= -90 || maxLat >= 90 || !(maxLon-minLon < 360)
+}
+
+// lonRanges splits the [minLon, maxLon] window of a bounding box into the ranges of valid
+// longitudes it covers, one unless it crosses the antimeridian
+func lonRanges(minLat, maxLat, minLon, maxLon float32) [][2]float32 {
+	if spansAllLons(minLat, maxLat, minLon, maxLon) {
+		return [][2]float32{{-180, 180}}
+	}
+	min, max := wrapLon(minLon), wrapLon(maxLon)
+	if min <= max {
+		return [][2]float32{{min, max}}
+	}
+	return [][2]float32{{min, 180}, {-180, max}}
+}
+
+// wrapLon brings a longitude back into [-180, 180), eg: 181 is -179
+func wrapLon(lon float32) float32 {
+	l := math.Mod(float64(lon)+180, 360)
+	if l < 0 {
+		l += 360
+	}
+	return float32(l - 180)
+}
+
+// lonWithin tells if the longitude falls inside the longitudes of the margins, wrapping around
+// the antimeridian the same way as the grid query does
+func lonWithin(lon float32, rMargins ReqMargins) bool {
+	if spansAllLons(rMargins.MinLat, rMargins.MaxLat, rMargins.MinLon, rMargins.MaxLon) {
+		return true
+	}
+	offset := math.Mod(float64(lon)-float64(rMargins.MinLon), 360)
+	if offset < 0 {
+		offset += 360
+	}
+	return offset <= float64(rMargins.MaxLon-rMargins.MinLon)
+}
+
+// MemoryPropertyRepo is an in process implementation of PropertyRepository backed by a grid
+// spatial index. It needs no database at all, which makes it handy for tests, demos and
+// small deployments. It is safe for concurrent use.
+type MemoryPropertyRepo struct {
+	Outbox *MemoryOutboxRepo
+
+	mu         sync.RWMutex
+	lastID     uint64
+	properties map[uint64]Property
+	index      gridIndex
+}
+
+func NewMemoryPropertyRepo(outbox *MemoryOutboxRepo) *MemoryPropertyRepo {
+	return &MemoryPropertyRepo{
+		Outbox:     outbox,
+		properties: make(map[uint64]Property),
+		index:      newGridIndex(),
+	}
+}
+
+func (repo *MemoryPropertyRepo) Save(p *Property) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.lastID++
+	p.PropertyID = repo.lastID
+	repo.properties[p.PropertyID] = *p
+	repo.index.insert(p.PropertyID, p.Latitude, p.Longitude)
+	return nil
+}
+
+// SaveWithEvents can't fail half way, as neither storing the property nor its events can fail once
+// a stored property is found
+func (repo *MemoryPropertyRepo) SaveWithEvents(p *Property, events []MatchEvent) error {
+	save := repo.Save
+	if p.PropertyID != 0 {
+		save = repo.Update
+	}
+	if err := save(p); err != nil {
+		return err
+	}
+	for i := range events {
+		events[i].PropertyID = p.PropertyID
+	}
+	return repo.Outbox.append(events)
+}
+
+func (repo *MemoryPropertyRepo) FindByID(id uint64) (Property, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	p, ok := repo.properties[id]
+	if !ok {
+		return p, errors.Wrapf(ErrNotFound, "property %d", id)
+	}
+	return p, nil
+}
+
+func (repo *MemoryPropertyRepo) FindByOwner(ownerID uint64) ([]Property, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	properties := []Property{}
+	for _, p := range repo.properties {
+		if p.OwnerID == ownerID {
+			properties = append(properties, p)
+		}
+	}
+	sort.Slice(properties, func(i, j int) bool { return properties[i].PropertyID < properties[j].PropertyID })
+	return properties, nil
+}
+
+func (repo *MemoryPropertyRepo) Update(p *Property) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	old, ok := repo.properties[p.PropertyID]
+	if !ok {
+		return errors.Wrapf(ErrNotFound, "property %d", p.PropertyID)
+	}
+	repo.index.remove(old.PropertyID, old.Latitude, old.Longitude)
+	repo.properties[p.PropertyID] = *p
+	repo.index.insert(p.PropertyID, p.Latitude, p.Longitude)
+	return nil
+}
+
+func (repo *MemoryPropertyRepo) Delete(id uint64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	old, ok := repo.properties[id]
+	if !ok {
+		return errors.Wrapf(ErrNotFound, "property %d", id)
+	}
+	repo.index.remove(old.PropertyID, old.Latitude, old.Longitude)
+	delete(repo.properties, id)
+	return nil
+}
+
+func (repo *MemoryPropertyRepo) FindCandidates(q CandidateQuery) ([]PropWithDistance, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	properties := []PropWithDistance{}
+	for _, id := range repo.index.query(q.Margins.MinLat, q.Margins.MaxLat, q.Margins.MinLon, q.Margins.MaxLon) {
+		p := repo.properties[id]
+		if p.Status != StatusActive || !propWithinMargins(p, q.Margins, q.Rates) || !propAttributesWithin(p.PropertyAttributes, q.Attributes) || !inTenants(p.TenantID, q.TenantIDs) {
+			continue
+		}
+		distance := GreatCircleDistance(q.Center, NewCoordinate(p.Latitude, p.Longitude))
+		if distance > q.Radius {
+			continue
+		}
+		properties = append(properties, PropWithDistance{Property: p, Distance: distance})
+	}
+	return properties, nil
+}
+
+func (repo *MemoryPropertyRepo) ExpireDue(now, addedBefore time.Time, limit int) ([]Property, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	properties := []Property{}
+	for _, p := range repo.properties {
+		if isDue(p.Status, p.ExpiresAt, p.AddedDate, now, addedBefore) {
+			properties = append(properties, p)
+		}
+	}
+	sort.Slice(properties, func(i, j int) bool { return properties[i].PropertyID < properties[j].PropertyID })
+	if len(properties) > limit {
+		properties = properties[:limit]
+	}
+
+	events := make([]StatusEvent, len(properties))
+	for i := range properties {
+		properties[i].Status = StatusExpired
+		repo.properties[properties[i].PropertyID] = properties[i]
+		events[i] = NewPropertyExpiredEvent(properties[i], now)
+	}
+	return properties, repo.Outbox.appendStatus(events)
+}
+
+// MemoryRequirementRepo is the in process counterpart of MemoryPropertyRepo for requirements
+type MemoryRequirementRepo struct {
+	Outbox *MemoryOutboxRepo
+
+	mu           sync.RWMutex
+	lastID       uint64
+	requirements map[uint64]Requirement
+	index        gridIndex
+}
+
+func NewMemoryRequirementRepo(outbox *MemoryOutboxRepo) *MemoryRequirementRepo {
+	return &MemoryRequirementRepo{
+		Outbox:       outbox,
+		requirements: make(map[uint64]Requirement),
+		index:        newGridIndex(),
+	}
+}
+
+func (repo *MemoryRequirementRepo) Save(r *Requirement) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.lastID++
+	r.RequirementID = repo.lastID
+	repo.requirements[r.RequirementID] = *r
+	repo.index.insert(r.RequirementID, r.Latitude, r.Longitude)
+	return nil
+}
+
+// SaveWithEvents can't fail half way, as neither storing the requirement nor its events can fail once
+// a stored requirement is found
+func (repo *MemoryRequirementRepo) SaveWithEvents(r *Requirement, events []MatchEvent) error {
+	save := repo.Save
+	if r.RequirementID != 0 {
+		save = repo.Update
+	}
+	if err := save(r); err != nil {
+		return err
+	}
+	for i := range events {
+		events[i].RequirementID = r.RequirementID
+	}
+	return repo.Outbox.append(events)
+}
+
+func (repo *MemoryRequirementRepo) FindByID(id uint64) (Requirement, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	r, ok := repo.requirements[id]
+	if !ok {
+		return r, errors.Wrapf(ErrNotFound, "requirement %d", id)
+	}
+	return r, nil
+}
+
+func (repo *MemoryRequirementRepo) FindByOwner(ownerID uint64) ([]Requirement, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	requirements := []Requirement{}
+	for _, r := range repo.requirements {
+		if r.OwnerID == ownerID {
+			requirements = append(requirements, r)
+		}
+	}
+	sort.Slice(requirements, func(i, j int) bool { return requirements[i].RequirementID < requirements[j].RequirementID })
+	return requirements, nil
+}
+
+func (repo *MemoryRequirementRepo) Update(r *Requirement) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	old, ok := repo.requirements[r.RequirementID]
+	if !ok {
+		return errors.Wrapf(ErrNotFound, "requirement %d", r.RequirementID)
+	}
+	repo.index.remove(old.RequirementID, old.Latitude, old.Longitude)
+	repo.requirements[r.RequirementID] = *r
+	repo.index.insert(r.RequirementID, r.Latitude, r.Longitude)
+	return nil
+}
+
+func (repo *MemoryRequirementRepo) Delete(id uint64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	old, ok := repo.requirements[id]
+	if !ok {
+		return errors.Wrapf(ErrNotFound, "requirement %d", id)
+	}
+	repo.index.remove(old.RequirementID, old.Latitude, old.Longitude)
+	delete(repo.requirements, id)
+	return nil
+}
+
+func (repo *MemoryRequirementRepo) FindCandidates(q CandidateQuery) ([]ReqWithDistance, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	requirements := []ReqWithDistance{}
+	for _, id := range repo.index.query(q.Margins.MinLat, q.Margins.MaxLat, q.Margins.MinLon, q.Margins.MaxLon) {
+		r := repo.requirements[id]
+		if r.Status != StatusActive || !reqOverlapsMargins(r, q.Margins, q.Rates) || !reqAttributesOverlap(r.RequirementAttributes, q.Attributes) || !inTenants(r.TenantID, q.TenantIDs) {
+			continue
+		}
+		distance := GreatCircleDistance(q.Center, NewCoordinate(r.Latitude, r.Longitude))
+		if distance > q.RadiusOf(r) {
+			continue
+		}
+		requirements = append(requirements, ReqWithDistance{Requirement: r, Distance: distance})
+	}
+	return requirements, nil
+}
+
+// MemoryOwnerRepo is the in process OwnerRepository
+type MemoryOwnerRepo struct {
+	mu     sync.RWMutex
+	lastID uint64
+	owners map[uint64]Owner
+}
+
+func NewMemoryOwnerRepo() *MemoryOwnerRepo {
+	return &MemoryOwnerRepo{
+		owners: make(map[uint64]Owner),
+	}
+}
+
+func (repo *MemoryOwnerRepo) Save(o *Owner) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.lastID++
+	o.OwnerID = repo.lastID
+	repo.owners[o.OwnerID] = *o
+	return nil
+}
+
+func (repo *MemoryOwnerRepo) FindByID(id uint64) (Owner, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	o, ok := repo.owners[id]
+	if !ok {
+		return o, errors.Wrapf(ErrNotFound, "owner %d", id)
+	}
+	return o, nil
+}
+
+func (repo *MemoryRequirementRepo) ExpireDue(now, addedBefore time.Time, limit int) ([]Requirement, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	requirements := []Requirement{}
+	for _, r := range repo.requirements {
+		if isDue(r.Status, r.ExpiresAt, r.AddedDate, now, addedBefore) {
+			requirements = append(requirements, r)
+		}
+	}
+	sort.Slice(requirements, func(i, j int) bool { return requirements[i].RequirementID < requirements[j].RequirementID })
+	if len(requirements) > limit {
+		requirements = requirements[:limit]
+	}
+
+	events := make([]StatusEvent, len(requirements))
+	for i := range requirements {
+		requirements[i].Status = StatusExpired
+		repo.requirements[requirements[i].RequirementID] = requirements[i]
+		events[i] = NewRequirementExpiredEvent(requirements[i], now)
+	}
+	return requirements, repo.Outbox.appendStatus(events)
+}
+
+// MemoryAPIKeyRepo is the in process APIKeyRepository
+type MemoryAPIKeyRepo struct {
+	mu     sync.RWMutex
+	lastID uint64
+	keys   map[uint64]APIKey
+}
+
+func NewMemoryAPIKeyRepo() *MemoryAPIKeyRepo {
+	return &MemoryAPIKeyRepo{
+		keys: make(map[uint64]APIKey),
+	}
+}
+
+func (repo *MemoryAPIKeyRepo) Save(k *APIKey) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.lastID++
+	k.KeyID = repo.lastID
+	repo.keys[k.KeyID] = *k
+	return nil
+}
+
+func (repo *MemoryAPIKeyRepo) FindByHash(hash string) (APIKey, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, k := range repo.keys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return APIKey{}, errors.Wrap(ErrNotFound, "api key")
+}
+
+func (repo *MemoryAPIKeyRepo) FindByTenant(tenantID uint64) ([]APIKey, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	keys := []APIKey{}
+	for _, k := range repo.keys {
+		if k.TenantID == tenantID {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KeyID < keys[j].KeyID })
+	return keys, nil
+}
+
+func (repo *MemoryAPIKeyRepo) Revoke(id uint64, at time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	k, ok := repo.keys[id]
+	if !ok {
+		return errors.Wrapf(ErrNotFound, "api key %d", id)
+	}
+	if k.RevokedAt == nil {
+		revokedAt := at.UTC()
+		k.RevokedAt = &revokedAt
+		repo.keys[id] = k
+	}
+	return nil
+}
+
+// MemoryTenantRepo is the in process TenantRepository
+type MemoryTenantRepo struct {
+	mu         sync.RWMutex
+	lastID     uint64
+	tenants    map[uint64]Tenant
+	agreements []SharingAgreement
+}
+
+func NewMemoryTenantRepo() *MemoryTenantRepo {
+	return &MemoryTenantRepo{
+		tenants: make(map[uint64]Tenant),
+	}
+}
+
+func (repo *MemoryTenantRepo) Save(t *Tenant) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.lastID++
+	t.TenantID = repo.lastID
+	repo.tenants[t.TenantID] = *t
+	return nil
+}
+
+func (repo *MemoryTenantRepo) FindByID(id uint64) (Tenant, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	t, ok := repo.tenants[id]
+	if !ok {
+		return t, errors.Wrapf(ErrNotFound, "tenant %d", id)
+	}
+	return t, nil
+}
+
+func (repo *MemoryTenantRepo) Update(t *Tenant) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.tenants[t.TenantID]; !ok {
+		return errors.Wrapf(ErrNotFound, "tenant %d", t.TenantID)
+	}
+	repo.tenants[t.TenantID] = *t
+	return nil
+}
+
+func (repo *MemoryTenantRepo) SaveAgreement(a *SharingAgreement) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, existing := range repo.agreements {
+		if existing.SharingTenantID == a.SharingTenantID && existing.PartnerTenantID == a.PartnerTenantID {
+			return nil
+		}
+	}
+	repo.agreements = append(repo.agreements, *a)
+	return nil
+}
+
+func (repo *MemoryTenantRepo) DeleteAgreement(sharingTenantID, partnerTenantID uint64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i, a := range repo.agreements {
+		if a.SharingTenantID == sharingTenantID && a.PartnerTenantID == partnerTenantID {
+			repo.agreements = append(repo.agreements[:i], repo.agreements[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrNotFound, "sharing agreement of tenant %d with %d", sharingTenantID, partnerTenantID)
+}
+
+func (repo *MemoryTenantRepo) SharingTenants(partnerTenantID uint64) ([]uint64, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	ids := []uint64{}
+	for _, a := range repo.agreements {
+		if a.PartnerTenantID == partnerTenantID {
+			ids = append(ids, a.SharingTenantID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (repo *MemoryTenantRepo) PartnerTenants(sharingTenantID uint64) ([]uint64, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	ids := []uint64{}
+	for _, a := range repo.agreements {
+		if a.SharingTenantID == sharingTenantID {
+			ids = append(ids, a.PartnerTenantID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// MemoryOutboxRepo is the in process OutboxRepository shared by the memory repositories
+type MemoryOutboxRepo struct {
+	mu     sync.Mutex
+	lastID uint64
+	events []OutboxEvent
+}
+
+func NewMemoryOutboxRepo() *MemoryOutboxRepo {
+	return &MemoryOutboxRepo{}
+}
+
+func (repo *MemoryOutboxRepo) append(events []MatchEvent) error {
+	rows := make([]OutboxEvent, 0, len(events))
+	for _, e := range events {
+		row, err := NewOutboxEvent(e)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	repo.appendRows(rows)
+	return nil
+}
+
+func (repo *MemoryOutboxRepo) appendStatus(events []StatusEvent) error {
+	rows := make([]OutboxEvent, 0, len(events))
+	for _, e := range events {
+		row, err := NewStatusOutboxEvent(e)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	repo.appendRows(rows)
+	return nil
+}
+
+func (repo *MemoryOutboxRepo) appendRows(rows []OutboxEvent) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range rows {
+		repo.lastID++
+		rows[i].EventID = repo.lastID
+	}
+	repo.events = append(repo.events, rows...)
+}
+
+// FetchPending returns the pending events, the published ones are dropped from memory on the way
+func (repo *MemoryOutboxRepo) FetchPending(now time.Time, limit int) ([]OutboxEvent, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	pending := repo.events[:0]
+	due := []OutboxEvent{}
+	for _, e := range repo.events {
+		if e.PublishedAt != nil {
+			continue
+		}
+		pending = append(pending, e)
+		if !e.NextAttemptAt.After(now) && len(due) < limit {
+			due = append(due, e)
+		}
+	}
+	repo.events = pending
+	return due, nil
+}
+
+func (repo *MemoryOutboxRepo) MarkPublished(id uint64, at time.Time) error {
+	return repo.update(id, func(e *OutboxEvent) {
+		e.PublishedAt = &at
+	})
+}
+
+func (repo *MemoryOutboxRepo) MarkFailed(id uint64, nextAttemptAt time.Time, reason string) error {
+	return repo.update(id, func(e *OutboxEvent) {
+		e.Attempts++
+		e.NextAttemptAt = nextAttemptAt
+		e.LastError = reason
+	})
+}
+
+func (repo *MemoryOutboxRepo) update(id uint64, change func(e *OutboxEvent)) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.events {
+		if repo.events[i].EventID == id {
+			change(&repo.events[i])
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrNotFound, "event %d", id)
+}
+
+// isDue is the go equivalent of the condition of the records due to expire
+func isDue(status string, expiresAt *time.Time, addedDate, now, addedBefore time.Time) bool {
+	if status != StatusActive {
+		return false
+	}
+	if expiresAt != nil {
+		return !expiresAt.After(now)
+	}
+	return !addedDate.After(addedBefore)
+}
+
+// propWithinMargins is the go equivalent of the price, bedrooms and bathrooms conditions
+// of the base filtering query on the properties table
+func propWithinMargins(p Property, rMargins ReqMargins, rates RateSnapshot) bool {
+	minPrice, maxPrice, ok := marginsIn(p.Currency, rMargins, rates)
+	return ok && p.Latitude >= rMargins.MinLat && p.Latitude <= rMargins.MaxLat &&
+		lonWithin(p.Longitude, rMargins) &&
+		p.Price >= minPrice && p.Price <= maxPrice &&
+		p.Bedrooms >= rMargins.MinBeds && p.Bedrooms <= rMargins.MaxBeds &&
+		p.Bathrooms >= rMargins.MinBaths && p.Bathrooms <= rMargins.MaxBaths
+}
+
+// reqOverlapsMargins is the go equivalent of the budget, bedrooms and bathrooms conditions
+// of the base filtering query on the requirements table
+func reqOverlapsMargins(r Requirement, rMargins ReqMargins, rates RateSnapshot) bool {
+	minPrice, maxPrice, ok := marginsIn(r.Currency, rMargins, rates)
+	return ok && r.Latitude >= rMargins.MinLat && r.Latitude <= rMargins.MaxLat &&
+		lonWithin(r.Longitude, rMargins) &&
+		rangeOverlapsMoney(r.MinBudget, r.MaxBudget, minPrice, maxPrice) &&
+		rangeOverlaps(r.MinBedrooms, r.MaxBedrooms, rMargins.MinBeds, rMargins.MaxBeds) &&
+		rangeOverlaps(r.MinBathrooms, r.MaxBathrooms, rMargins.MinBaths, rMargins.MaxBaths)
+}
+
+// marginsIn returns the price window of the margins converted to the currency, or false when it
+// can't be converted, the go equivalent of the currency conditions of the base filtering queries
+func marginsIn(currency string, rMargins ReqMargins, rates RateSnapshot) (Money, Money, bool) {
+	minPrice, minOK := rates.FromBase(currency, rMargins.MinPrice)
+	maxPrice, maxOK := rates.FromBase(currency, rMargins.MaxPrice)
+	return minPrice, maxPrice, minOK && maxOK
+}
+
+// inTenants is the go equivalent of the tenant condition of the base filtering queries
+func inTenants(tenantID uint64, tenantIDs []uint64) bool {
+	for _, id := range tenantIDs {
+		if id == tenantID {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeOverlapsMoney tells if the [min, max] range of a requirement overlaps the [lo, hi] window.
+// A missing max is stored as 0, in which case only the min has to fall inside the window.
+func rangeOverlapsMoney(min, max, lo, hi Money) bool {
+	return (min <= hi && max >= lo) || (min >= lo && min <= hi)
+}
+
+func rangeOverlaps(min, max, lo, hi uint16) bool {
+	return (min <= hi && max >= lo) || (min >= lo && min <= hi)
+}
+
+func clampF(x, min, max float32) float32 {
+	if x < min {
+		return min
+	}
+	if x > max {
+		return max
+	}
+	return x
+}
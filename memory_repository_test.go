@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGridIndex(t *testing.T) {
+	g := newGridIndex()
+	points := []struct {
+		id       uint64
+		lat, lon float32
+	}{
+		{1, 12.91, 77.61},
+		{2, 12.99, 77.69}, // same cell as 1
+		{3, 13.01, 77.61}, // the cell to the north
+		{4, -12.91, -77.61},
+		{5, 89.99, 10},
+		{6, -89.99, -10},
+		{7, 0.05, 179.95},
+		{8, 0.05, -179.95},
+	}
+	for _, p := range points {
+		g.insert(p.id, p.lat, p.lon)
+	}
+	if got := g.cells[g.cellOf(12.91, 77.61)]; !reflect.DeepEqual(got, []uint64{1, 2}) {
+		t.Errorf("cell of 1 = %v, want [1 2]", got)
+	}
+
+	tests := []struct {
+		name           string
+		minLat, maxLat float32
+		minLon, maxLon float32
+		want           []uint64
+	}{
+		{"one cell", 12.95, 12.96, 77.65, 77.66, []uint64{1, 2}},
+		{"two cells", 12.95, 13.05, 77.65, 77.66, []uint64{1, 2, 3}},
+		{"negative coordinates", -12.92, -12.90, -77.62, -77.60, []uint64{4}},
+		{"empty", 20, 21, 20, 21, []uint64{}},
+		{"past the north pole", 89.9, 90.1, 9.9, 10.1, []uint64{5}},
+		{"past the south pole", -90.1, -89.9, -10.1, -9.9, []uint64{6}},
+		// a box reaching past a pole covers the points on the other side of it
+		{"across the north pole", 89.9, 90.1, -170.1, -169.9, []uint64{5}},
+		{"east of the antimeridian", 0, 0.1, 179.9, 180.1, []uint64{7, 8}},
+		{"west of the antimeridian", 0, 0.1, -180.1, -179.9, []uint64{7, 8}},
+		{"all the longitudes", 0, 0.1, -200, 200, []uint64{7, 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := g.query(tt.minLat, tt.maxLat, tt.minLon, tt.maxLon)
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("query(%v, %v, %v, %v) = %v, want %v", tt.minLat, tt.maxLat, tt.minLon, tt.maxLon, got, tt.want)
+			}
+		})
+	}
+
+	// removing the last id of a cell drops the cell
+	g.remove(1, 12.91, 77.61)
+	if got := g.query(12.95, 12.96, 77.65, 77.66); !reflect.DeepEqual(got, []uint64{2}) {
+		t.Errorf("query after removing 1 = %v, want [2]", got)
+	}
+	g.remove(2, 12.99, 77.69)
+	if _, ok := g.cells[g.cellOf(12.91, 77.61)]; ok {
+		t.Error("the empty cell of 1 and 2 was kept")
+	}
+	g.remove(9, 13.01, 77.61)
+	if got := g.cells[g.cellOf(13.01, 77.61)]; !reflect.DeepEqual(got, []uint64{3}) {
+		t.Errorf("cell of 3 after removing an unknown id = %v, want [3]", got)
+	}
+}
+
+func TestLonWithin(t *testing.T) {
+	tests := []struct {
+		name           string
+		lon            float32
+		minLat, maxLat float32
+		minLon, maxLon float32
+		want           bool
+	}{
+		{"inside", 77.61, 12, 13, 77.6, 77.7, true},
+		{"on the edge", 77.6, 12, 13, 77.6, 77.7, true},
+		{"outside", 77.59, 12, 13, 77.6, 77.7, false},
+		{"east across the antimeridian", -179.95, 0, 1, 179.9, 180.1, true},
+		{"west across the antimeridian", 179.95, 0, 1, -180.1, -179.9, true},
+		{"outside across the antimeridian", -179.8, 0, 1, 179.9, 180.1, false},
+		{"all the longitudes", 10, 0, 1, -200, 200, true},
+		{"past a pole", -170, 89.9, 90.1, 9.9, 10.1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewReqMargins(tt.minLat, tt.maxLat, tt.minLon, tt.maxLon, 0, 0, 0, 0, 0, 0)
+			if got := lonWithin(tt.lon, m); got != tt.want {
+				t.Errorf("lonWithin(%v, [%v, %v]) = %v, want %v", tt.lon, tt.minLon, tt.maxLon, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Migration is a single versioned change of the database schema. Migrations are applied in
+// order of their Version and each one is applied only once, inside its own transaction.
+// Up must only use tx, the gorm dialect helpers like AddIndex run outside of the transaction.
+// MySQL commits implicitly after every DDL statement though, so a MySQL migration failing half way
+// stays half applied and is run again from the start on the next start: it must be idempotent,
+// ie: never change the existing rows in a way that would be wrong to do twice.
+type Migration struct {
+	Version     uint
+	Description string
+	Up          func(tx *gorm.DB) error
+}
+
+// schemaMigration is a row of the schema_migrations table which records the applied migrations
+type schemaMigration struct {
+	Version     uint `gorm:"primary_key;auto_increment:false"`
+	Description string
+	AppliedAt   time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migrations is the list of all the schema migrations, append new ones at the end with the next version.
+// Never change a migration once released, as it will not be applied again on existing databases.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create properties and requirements tables",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.CreateTable(&propertiesTableV1{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("CREATE INDEX idx_properties_latitude_longitude ON properties (latitude, longitude)").Error; err != nil {
+				return err
+			}
+			if err := tx.CreateTable(&requirementsTableV1{}).Error; err != nil {
+				return err
+			}
+			return tx.Exec("CREATE INDEX idx_requirements_latitude_longitude ON requirements (latitude, longitude)").Error
+		},
+	},
+	{
+		Version:     2,
+		Description: "add postgis geography locations to properties and requirements",
+		Up: func(tx *gorm.DB) error {
+			if !isPostgres(tx) {
+				return nil
+			}
+			return execAll(tx, postgisLocationStatements)
+		},
+	},
+	{
+		Version:     3,
+		Description: "create match_events outbox table",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.CreateTable(&matchEventsTableV3{}).Error; err != nil {
+				return err
+			}
+			return tx.Exec("CREATE INDEX idx_match_events_pending ON match_events (published_at, next_attempt_at)").Error
+		},
+	},
+	{
+		Version:     4,
+		Description: "create owners table and add owner_id to properties and requirements",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.CreateTable(&ownersTableV4{}).Error; err != nil {
+				return err
+			}
+			return execAll(tx, []string{
+				"ALTER TABLE properties ADD COLUMN owner_id BIGINT NOT NULL DEFAULT 0",
+				"CREATE INDEX idx_properties_owner_id ON properties (owner_id)",
+				"ALTER TABLE requirements ADD COLUMN owner_id BIGINT NOT NULL DEFAULT 0",
+				"CREATE INDEX idx_requirements_owner_id ON requirements (owner_id)",
+			})
+		},
+	},
+	{
+		Version:     5,
+		Description: "create tenants and sharing_agreements tables and add tenant_id to properties, requirements and owners",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.CreateTable(&tenantsTableV5{}).Error; err != nil {
+				return err
+			}
+			if err := tx.CreateTable(&sharingAgreementsTableV5{}).Error; err != nil {
+				return err
+			}
+			return execAll(tx, []string{
+				"CREATE INDEX idx_sharing_agreements_partner_tenant_id ON sharing_agreements (partner_tenant_id)",
+				"ALTER TABLE properties ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 0",
+				"CREATE INDEX idx_properties_tenant_id ON properties (tenant_id)",
+				"ALTER TABLE requirements ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 0",
+				"CREATE INDEX idx_requirements_tenant_id ON requirements (tenant_id)",
+				"ALTER TABLE owners ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 0",
+			})
+		},
+	},
+	{
+		Version:     6,
+		Description: "create api_keys table",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.CreateTable(&apiKeysTableV6{}).Error; err != nil {
+				return err
+			}
+			return execAll(tx, []string{
+				"CREATE UNIQUE INDEX idx_api_keys_hash ON api_keys (hash)",
+				"CREATE INDEX idx_api_keys_tenant_id ON api_keys (tenant_id)",
+			})
+		},
+	},
+	{
+		Version:     7,
+		Description: "add status and expires_at to properties and requirements",
+		Up: func(tx *gorm.DB) error {
+			// the existing records are active and have no expires_at, they expire a ttl after their added_date
+			return execAll(tx, []string{
+				"ALTER TABLE properties ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active'",
+				"ALTER TABLE properties ADD COLUMN expires_at " + nullTimestampType(tx),
+				"CREATE INDEX idx_properties_status ON properties (status)",
+				"ALTER TABLE requirements ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active'",
+				"ALTER TABLE requirements ADD COLUMN expires_at " + nullTimestampType(tx),
+				"CREATE INDEX idx_requirements_status ON requirements (status)",
+			})
+		},
+	},
+	{
+		Version:     8,
+		Description: "add the attributes of properties and the attribute asks of requirements",
+		Up: func(tx *gorm.DB) error {
+			// the sets are bitmasks, the existing records have unknown attributes and no asks
+			return execAll(tx, []string{
+				"ALTER TABLE properties ADD COLUMN property_type VARCHAR(16) NOT NULL DEFAULT ''",
+				"ALTER TABLE properties ADD COLUMN area REAL NOT NULL DEFAULT 0",
+				"ALTER TABLE properties ADD COLUMN parking_spaces INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE properties ADD COLUMN furnishing VARCHAR(16) NOT NULL DEFAULT ''",
+				"ALTER TABLE properties ADD COLUMN amenities BIGINT NOT NULL DEFAULT 0",
+				"ALTER TABLE requirements ADD COLUMN property_types BIGINT NOT NULL DEFAULT 0",
+				"ALTER TABLE requirements ADD COLUMN min_area REAL NOT NULL DEFAULT 0",
+				"ALTER TABLE requirements ADD COLUMN max_area REAL NOT NULL DEFAULT 0",
+				"ALTER TABLE requirements ADD COLUMN min_parking INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE requirements ADD COLUMN furnishings BIGINT NOT NULL DEFAULT 0",
+				"ALTER TABLE requirements ADD COLUMN must_have_amenities BIGINT NOT NULL DEFAULT 0",
+				"ALTER TABLE requirements ADD COLUMN nice_to_have_amenities BIGINT NOT NULL DEFAULT 0",
+			})
+		},
+	},
+	{
+		Version:     9,
+		Description: "add hard_constraints to requirements",
+		Up: func(tx *gorm.DB) error {
+			// a bitmask like the attribute sets, the existing requirements are all soft
+			return tx.Exec("ALTER TABLE requirements ADD COLUMN hard_constraints BIGINT NOT NULL DEFAULT 0").Error
+		},
+	},
+	{
+		Version:     10,
+		Description: "add custom weights to requirements",
+		Up: func(tx *gorm.DB) error {
+			// the json MatchWeights of the requirement, null for the weights of the match policy
+			return tx.Exec("ALTER TABLE requirements ADD COLUMN weights TEXT NULL").Error
+		},
+	},
+	{
+		Version:     11,
+		Description: "add the search and full score radii to requirements",
+		Up: func(tx *gorm.DB) error {
+			// 0 for the radii of the match policy
+			return execAll(tx, []string{
+				"ALTER TABLE requirements ADD COLUMN search_radius REAL NOT NULL DEFAULT 0",
+				"ALTER TABLE requirements ADD COLUMN full_score_radius REAL NOT NULL DEFAULT 0",
+			})
+		},
+	},
+	{
+		Version:     12,
+		Description: "add polygons to requirements",
+		Up: func(tx *gorm.DB) error {
+			// the GeoJSON MultiPolygon of the requirement, null for a circle around its coordinate
+			return execAll(tx, []string{
+				"ALTER TABLE requirements ADD COLUMN polygons TEXT NULL",
+				"ALTER TABLE requirements ADD COLUMN polygons_radius REAL NOT NULL DEFAULT 0",
+			})
+		},
+	},
+	{
+		Version:     13,
+		Description: "add currencies to properties and requirements",
+		Up: func(tx *gorm.DB) error {
+			// the ISO 4217 code of the amounts, empty for the base currency of the existing records
+			return execAll(tx, []string{
+				"ALTER TABLE properties ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT ''",
+				"ALTER TABLE requirements ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT ''",
+			})
+		},
+	},
+	{
+		Version:     14,
+		Description: "store the prices and budgets in minor units",
+		Up: func(tx *gorm.DB) error {
+			// eg: a price of 123.45 becomes 12345, and a missing budget stays 0
+			statements, err := hundredthsStatements(tx)
+			if err != nil {
+				return err
+			}
+			return execAll(tx, statements)
+		},
+	},
+}
+
+// postgisLocationStatements add a geography(Point) location with a GiST index to both tables.
+// The location is filled from latitude and longitude by a trigger, so the gorm inserts and
+// updates do not need to know about it.
+var postgisLocationStatements = []string{
+	"CREATE EXTENSION IF NOT EXISTS postgis",
+	`CREATE OR REPLACE FUNCTION set_location_from_lat_lon() RETURNS trigger AS $$
+	BEGIN
+		NEW.location := ST_SetSRID(ST_MakePoint(NEW.longitude, NEW.latitude), 4326)::geography;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+
+	"ALTER TABLE properties ADD COLUMN location geography(Point, 4326)",
+	"UPDATE properties SET location = ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)::geography",
+	"CREATE INDEX idx_properties_location ON properties USING GIST (location)",
+	"CREATE TRIGGER properties_set_location BEFORE INSERT OR UPDATE OF latitude, longitude ON properties " +
+		"FOR EACH ROW EXECUTE PROCEDURE set_location_from_lat_lon()",
+
+	"ALTER TABLE requirements ADD COLUMN location geography(Point, 4326)",
+	"UPDATE requirements SET location = ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)::geography",
+	"CREATE INDEX idx_requirements_location ON requirements USING GIST (location)",
+	"CREATE TRIGGER requirements_set_location BEFORE INSERT OR UPDATE OF latitude, longitude ON requirements " +
+		"FOR EACH ROW EXECUTE PROCEDURE set_location_from_lat_lon()",
+}
+
+func isPostgres(tx *gorm.DB) bool {
+	return tx.Dialect().GetName() == "postgres"
+}
+
+// nullTimestampType is the type gorm gives to a *time.Time column on the dialect of tx
+func nullTimestampType(tx *gorm.DB) string {
+	if isPostgres(tx) {
+		return "TIMESTAMP WITH TIME ZONE NULL"
+	}
+	return "DATETIME NULL"
+}
+
+// hundredthsStatements convert the prices and budgets of the existing rows from units of their currency
+// to hundredths, changing the float columns to BIGINT. sqlite can't change the type of a column, so
+// its REAL columns keep the hundredths, which are exact integers up to 2^53.
+func hundredthsStatements(tx *gorm.DB) ([]string, error) {
+	statements := []string{}
+	for _, c := range []struct{ table, column string }{
+		{"properties", "price"}, {"requirements", "min_budget"}, {"requirements", "max_budget"},
+	} {
+		switch tx.Dialect().GetName() {
+		case "postgres":
+			statements = append(statements, "ALTER TABLE "+c.table+" ALTER COLUMN "+c.column+" TYPE BIGINT USING ROUND("+c.column+" * 100)::BIGINT")
+		case "mysql":
+			mysqlStatements, err := mysqlHundredthsStatements(tx, c.table, c.column)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, mysqlStatements...)
+		default:
+			statements = append(statements, "UPDATE "+c.table+" SET "+c.column+" = ROUND("+c.column+" * 100)")
+		}
+	}
+	return statements, nil
+}
+
+// mysqlHundredthsStatements convert a float column to hundredths on MySQL without ever changing it in
+// place, so that the migration can be run again after failing half way: the hundredths are worked
+// out into a new column, which then replaces the float column in a single ALTER TABLE. The column
+// is left alone once it has been replaced, ie: when it is a BIGINT.
+func mysqlHundredthsStatements(tx *gorm.DB, table, column string) ([]string, error) {
+	hundredths := column + "_hundredths"
+	types, err := mysqlColumnTypes(tx, table, column, hundredths)
+	if err != nil {
+		return nil, err
+	}
+	if types[column] == "bigint" && types[hundredths] == "" {
+		return nil, nil
+	}
+
+	statements := []string{}
+	if types[hundredths] == "" {
+		statements = append(statements, "ALTER TABLE "+table+" ADD COLUMN "+hundredths+" BIGINT NULL")
+	}
+	return append(statements,
+		"UPDATE "+table+" SET "+hundredths+" = ROUND("+column+" * 100)",
+		"ALTER TABLE "+table+" DROP COLUMN "+column+", CHANGE "+hundredths+" "+column+" BIGINT",
+	), nil
+}
+
+// mysqlColumnTypes returns the data types of the existing columns of the table, eg: "float" or "bigint"
+func mysqlColumnTypes(tx *gorm.DB, table string, columns ...string) (map[string]string, error) {
+	rows, err := tx.Raw("SELECT column_name, data_type FROM information_schema.columns "+
+		"WHERE table_schema = DATABASE() AND table_name = ? AND column_name IN (?)", table, columns).Rows()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read the columns of %s", table)
+	}
+	defer rows.Close()
+
+	types := map[string]string{}
+	for rows.Next() {
+		var column, dataType string
+		if err := rows.Scan(&column, &dataType); err != nil {
+			return nil, errors.Wrapf(err, "couldn't read the columns of %s", table)
+		}
+		types[column] = strings.ToLower(dataType)
+	}
+	return types, rows.Err()
+}
+
+func execAll(tx *gorm.DB, statements []string) error {
+	for _, stmt := range statements {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunMigrations brings the schema of db up to date by applying all the pending migrations
+func RunMigrations(db *gorm.DB) error {
+	if err := db.AutoMigrate(&schemaMigration{}).Error; err != nil {
+		return errors.Wrap(err, "couldn't create schema_migrations table")
+	}
+
+	var current schemaMigration
+	err := db.Order("version desc").First(&current).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return errors.Wrap(err, "couldn't read current schema version")
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current.Version {
+			continue
+		}
+		log.Printf("Applying migration %d: %s", m.Version, m.Description)
+
+		tx := db.Begin()
+		if err := m.Up(tx); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "couldn't apply migration %d", m.Version)
+		}
+		applied := schemaMigration{Version: m.Version, Description: m.Description, AppliedAt: time.Now().UTC()}
+		if err := tx.Create(&applied).Error; err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "couldn't record migration %d", m.Version)
+		}
+		if err := tx.Commit().Error; err != nil {
+			return errors.Wrapf(err, "couldn't commit migration %d", m.Version)
+		}
+	}
+	return nil
+}
+
+// The tables are created from snapshots of the domain structs as they were at the time of the
+// migration, so that later changes of Property and Requirement do not change old migrations.
+
+type propertiesTableV1 struct {
+	PropertyID uint64 `gorm:"primary_key"`
+	Latitude   float32
+	Longitude  float32
+	Price      float32
+	Bedrooms   uint16
+	Bathrooms  uint16
+	AddedDate  time.Time
+}
+
+func (propertiesTableV1) TableName() string {
+	return "properties"
+}
+
+type requirementsTableV1 struct {
+	RequirementID uint64 `gorm:"primary_key"`
+	Latitude      float32
+	Longitude     float32
+	MinBudget     float32
+	MaxBudget     float32
+	MinBedrooms   uint16
+	MaxBedrooms   uint16
+	MinBathrooms  uint16
+	MaxBathrooms  uint16
+	AddedDate     time.Time
+}
+
+func (requirementsTableV1) TableName() string {
+	return "requirements"
+}
+
+type matchEventsTableV3 struct {
+	EventID       uint64 `gorm:"primary_key"`
+	Kind          string
+	RequirementID uint64
+	PropertyID    uint64
+	Payload       string `gorm:"type:text"`
+	CreatedAt     time.Time
+	Attempts      uint16
+	NextAttemptAt time.Time
+	PublishedAt   *time.Time
+	LastError     string `gorm:"type:text"`
+}
+
+func (matchEventsTableV3) TableName() string {
+	return "match_events"
+}
+
+type ownersTableV4 struct {
+	OwnerID    uint64 `gorm:"primary_key"`
+	Kind       string
+	Name       string
+	Email      string
+	WebhookURL string
+	AddedDate  time.Time
+}
+
+func (ownersTableV4) TableName() string {
+	return "owners"
+}
+
+type tenantsTableV5 struct {
+	TenantID  uint64 `gorm:"primary_key"`
+	Name      string
+	Policy    string `gorm:"type:text"`
+	AddedDate time.Time
+}
+
+func (tenantsTableV5) TableName() string {
+	return "tenants"
+}
+
+type sharingAgreementsTableV5 struct {
+	SharingTenantID uint64 `gorm:"primary_key;auto_increment:false"`
+	PartnerTenantID uint64 `gorm:"primary_key;auto_increment:false"`
+	AddedDate       time.Time
+}
+
+func (sharingAgreementsTableV5) TableName() string {
+	return "sharing_agreements"
+}
+
+type apiKeysTableV6 struct {
+	KeyID     uint64 `gorm:"primary_key"`
+	TenantID  uint64
+	Name      string
+	Prefix    string
+	Hash      string
+	OwnerID   uint64
+	Scopes    string
+	RateLimit int
+	AddedDate time.Time
+	RevokedAt *time.Time
+}
+
+func (apiKeysTableV6) TableName() string {
+	return "api_keys"
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", filepath.Join(t.TempDir(), "matcher.db"))
+	if err != nil {
+		t.Fatalf("couldn't open the database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func appliedVersions(t *testing.T, db *gorm.DB) []uint {
+	var applied []schemaMigration
+	if err := db.Order("version").Find(&applied).Error; err != nil {
+		t.Fatalf("couldn't read the applied migrations: %v", err)
+	}
+	versions := make([]uint, len(applied))
+	for i, m := range applied {
+		versions[i] = m.Version
+	}
+	return versions
+}
+
+func TestRunMigrations(t *testing.T) {
+	db := openTestDB(t)
+
+	// every migration is applied once, in order, and running them again applies none
+	for run := 1; run <= 2; run++ {
+		if err := RunMigrations(db); err != nil {
+			t.Fatalf("run %d: couldn't migrate: %v", run, err)
+		}
+		versions := appliedVersions(t, db)
+		if len(versions) != len(migrations) {
+			t.Fatalf("run %d: applied %d migrations, want %d", run, len(versions), len(migrations))
+		}
+		for i, m := range migrations {
+			if versions[i] != m.Version {
+				t.Errorf("run %d: migration %d is version %d, want %d", run, i, versions[i], m.Version)
+			}
+		}
+	}
+}
+
+func TestRunMigrationsFailure(t *testing.T) {
+	db := openTestDB(t)
+	if err := RunMigrations(db); err != nil {
+		t.Fatalf("couldn't migrate: %v", err)
+	}
+
+	// a failing migration is rolled back and not recorded, so it is run again on the next start
+	all := migrations
+	defer func() { migrations = all }()
+	last := all[len(all)-1].Version
+	migrations = append(all[:len(all):len(all)], Migration{
+		Version:     last + 1,
+		Description: "fail half way",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Exec("CREATE TABLE half_applied (id INTEGER)").Error; err != nil {
+				return err
+			}
+			return errors.New("failed")
+		},
+	})
+	if err := RunMigrations(db); err == nil {
+		t.Fatal("RunMigrations() succeeded, want the failure of the last migration")
+	}
+	if versions := appliedVersions(t, db); versions[len(versions)-1] != last {
+		t.Errorf("last applied version = %d, want %d", versions[len(versions)-1], last)
+	}
+	if db.HasTable("half_applied") {
+		t.Error("the failed migration was not rolled back")
+	}
+}
+
+func TestMigrationHundredths(t *testing.T) {
+	db := openTestDB(t)
+
+	// the rows stored before the migration 14 have their amounts in units of their currency
+	all := migrations
+	defer func() { migrations = all }()
+	for i, m := range all {
+		if m.Version == 14 {
+			migrations = all[:i]
+		}
+	}
+	if err := RunMigrations(db); err != nil {
+		t.Fatalf("couldn't migrate to version 13: %v", err)
+	}
+	statements := []string{
+		"INSERT INTO properties (property_id, price) VALUES (1, 123.45)",
+		"INSERT INTO requirements (requirement_id, min_budget, max_budget) VALUES (1, 0, 99999.99)",
+	}
+	if err := execAll(db, statements); err != nil {
+		t.Fatalf("couldn't insert the float rows: %v", err)
+	}
+
+	migrations = all
+	if err := RunMigrations(db); err != nil {
+		t.Fatalf("couldn't migrate from version 13: %v", err)
+	}
+
+	var prop Property
+	if err := db.First(&prop, 1).Error; err != nil {
+		t.Fatalf("couldn't read the property: %v", err)
+	}
+	if prop.Price != 12345 {
+		t.Errorf("price = %d, want 12345", prop.Price)
+	}
+	var req Requirement
+	if err := db.First(&req, 1).Error; err != nil {
+		t.Fatalf("couldn't read the requirement: %v", err)
+	}
+	if req.MinBudget != 0 || req.MaxBudget != 9999999 {
+		t.Errorf("budgets = (%d, %d), want (0, 9999999)", req.MinBudget, req.MaxBudget)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql/driver"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Money is an amount of a currency with 2 decimals, stored in hundredths of a unit of the currency
+// whatever its own minor unit, eg: 12345 for 123.45 dollars or 123.45 yen, and 1.234 dinars can't be
+// given. Prices and budgets are exact integers all along, from the api to the sql columns and the
+// scoring, so that a price right on a budget or band edge is never off by a cent.
+type Money int64
+
+const (
+	// OneUnit is one unit of a currency, eg: 1 dollar
+	OneUnit Money = 100
+	// MaxAmount is the largest price or budget, ie: 1 trillion units of a currency, so that scaling
+	// it by the margins of the match policy, which are less than 1, stays within an int64
+	MaxAmount Money = 1e12 * OneUnit
+)
+
+// basisPoints are the hundredths of a percent the fractions of the match policy are applied in
+const basisPoints = 10000
+
+// Scaled returns the amount changed by the fraction, eg: 0.25 for 25% more or -0.1 for 10% less,
+// rounded to the nearest hundredth. The fraction is applied in basis points, so that the margins
+// and the bands of the match policy, eg: 0.1, scale the amounts exactly.
+func (m Money) Scaled(fraction float32) Money {
+	bp := int64(math.Round(float64(fraction) * basisPoints))
+	scaled := int64(m) * (basisPoints + bp)
+	if scaled < 0 {
+		return Money((scaled - basisPoints/2) / basisPoints)
+	}
+	return Money((scaled + basisPoints/2) / basisPoints)
+}
+
+// String formats the amount in units of its currency, eg: "123.45" or "100"
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign, m = "-", -m
+	}
+	units := strconv.FormatInt(int64(m/OneUnit), 10)
+	if minor := m % OneUnit; minor != 0 {
+		return sign + units + "." + strconv.FormatInt(int64(OneUnit+minor), 10)[1:]
+	}
+	return sign + units
+}
+
+// MarshalJSON writes the amount as a json number in units of its currency, eg: 123.45
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads a json number in units of its currency, eg: 123.45 or 1e5, without going
+// through a float. It returns an error caused by ErrValidation for an amount with more than 2
+// decimals or too large to be stored.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = 0
+		return nil
+	}
+	amount, ok := new(big.Rat).SetString(string(data))
+	if !ok {
+		return errors.Wrapf(ErrValidation, "bad amount: %s, must be a number", data)
+	}
+	amount.Mul(amount, big.NewRat(int64(OneUnit), 1))
+	if !amount.IsInt() {
+		return errors.Wrapf(ErrValidation, "bad amount: %s, must have 2 decimals at most", data)
+	}
+	if !amount.Num().IsInt64() {
+		return errors.Wrapf(ErrValidation, "bad amount: %s, too large", data)
+	}
+	*m = Money(amount.Num().Int64())
+	return nil
+}
+
+// Value stores the amount in hundredths
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+// Scan reads an amount in hundredths. The sqlite columns have a REAL affinity, their hundredths are
+// exact integers up to 2^53 though.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v)
+	case float64:
+		*m = Money(math.Round(v))
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	default:
+		return errors.Errorf("can't scan %T into Money", src)
+	}
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		*m = Money(i)
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return errors.Wrapf(err, "can't scan %q into Money", s)
+	}
+	*m = Money(math.Round(f))
+	return nil
+}
+
+func MaxMoney(x, y Money) Money {
+	if x >= y {
+		return x
+	}
+	return y
+}
+
+// ratio returns x / y, eg: how far into a score band a price is
+func ratio(x, y Money) float32 {
+	return float32(float64(x) / float64(y))
+}
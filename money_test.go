@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestMoneyScaled(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Money
+		fraction float32
+		want     Money
+	}{
+		{"unchanged", 12345, 0, 12345},
+		{"exact band", 10000000, 0.1, 11000000},
+		{"exact band less", 10000000, -0.1, 9000000},
+		{"rounds down", 12345, 0.25, 15431},
+		{"rounds up", 12345, -0.25, 9259},
+		{"half rounds up", 10, 0.05, 11},
+		{"negative rounds down", -12345, 0.25, -15431},
+		{"negative rounds up", -12345, -0.25, -9259},
+		{"negative half rounds away from zero", -10, 0.05, -11},
+		{"max amount", MaxAmount, 0.99, 199000000000000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.amount.Scaled(tt.fraction); got != tt.want {
+				t.Errorf("Money(%d).Scaled(%v) = %d, want %d", tt.amount, tt.fraction, got, tt.want)
+			}
+		})
+	}
+}
+
+// a cent more or less than a budget is a cent more or less than the edges of its margins and bands,
+// so that the prices on the edges are in and the ones a cent outside are out
+func TestMoneyScaledEdges(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Money
+		fraction float32
+		want     Money
+	}{
+		{"on min price", testBudget, -0.25, testMinPrice},
+		{"inside min price", testBudget + 1, -0.25, testMinPrice + 1},
+		{"outside min price", testBudget - 1, -0.25, testMinPrice - 1},
+		{"on max price", testBudget, 0.25, testMaxPrice},
+		{"inside max price", testBudget - 1, 0.25, testMaxPrice - 1},
+		{"outside max price", testBudget + 1, 0.25, testMaxPrice + 1},
+		{"on min band", testBudget, -0.10, testMinBand},
+		{"on max band", testBudget, 0.10, testMaxBand},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.amount.Scaled(tt.fraction); got != tt.want {
+				t.Errorf("Money(%d).Scaled(%v) = %d, want %d", tt.amount, tt.fraction, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Money
+		invalid bool
+	}{
+		{"exponent", "1e5", 10000000, false},
+		{"2 decimals", "123.45", 12345, false},
+		{"integer", "100", 10000, false},
+		{"null", "null", 0, false},
+		{"3 decimals", "123.456", 0, true},
+		{"overflow", "1e17", 0, true},
+		{"not a number", `"123.45"`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			err := got.UnmarshalJSON([]byte(tt.data))
+			if tt.invalid {
+				if errors.Cause(err) != ErrValidation {
+					t.Errorf("UnmarshalJSON(%s) error = %v, want a validation error", tt.data, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UnmarshalJSON(%s) error = %v", tt.data, err)
+			}
+			if got != tt.want {
+				t.Errorf("UnmarshalJSON(%s) = %d, want %d", tt.data, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// mysqlDistanceSelect calculates the distance in miles of each row from a given point in the query
+// itself using the spherical law of cosines. LEAST keeps acos defined despite rounding errors.
+const mysqlDistanceSelect = "acos(LEAST(1.0, sin(radians(latitude))*sin(radians(?)) + cos(radians(latitude))*cos(radians(?))*cos(radians(?) - radians(longitude)))) * ? as distance"
+
+// MySQLPropertyRepo implements PropertyRepository on top of a mysql connection pool.
+// The distance is calculated in the query itself using the spherical law of cosines.
+type MySQLPropertyRepo struct {
+	gormPropertyStore
+}
+
+func NewMySQLPropertyRepo(db *gorm.DB) MySQLPropertyRepo {
+	return MySQLPropertyRepo{
+		gormPropertyStore: gormPropertyStore{DB: db},
+	}
+}
+
+func (repo MySQLPropertyRepo) FindCandidates(q CandidateQuery) ([]PropWithDistance, error) {
+	properties := []PropWithDistance{}
+
+	condition, candidateArgs := propCandidates(q)
+	args := []interface{}{q.Center.Latitude, q.Center.Latitude, q.Center.Longitude, EarthRadius}
+	args = append(args, candidateArgs...)
+	args = append(args, q.Radius)
+
+	err := repo.DB.Raw(repo.getQueryString(condition), args...).Scan(&properties).Error
+	if err != nil {
+		log.Printf("MySQLPropertyRepo couldn't find candidates for: (query: %v, err: %v)", q, err)
+		return properties, errors.Wrap(err, "MySQLPropertyRepo couldn't find candidates")
+	}
+	return properties, nil
+}
+
+func (repo MySQLPropertyRepo) getQueryString(condition string) string {
+	selectClause := "SELECT " + propertyColumns + ", " + mysqlDistanceSelect + " "
+	fromClause := "FROM properties "
+	// distance is a select alias, so it can only be filtered in HAVING
+	distCondition := " HAVING distance <= ?"
+
+	return selectClause + fromClause + "WHERE " + condition + distCondition
+}
+
+// MySQLRequirementRepo implements RequirementRepository on top of a mysql connection pool
+type MySQLRequirementRepo struct {
+	gormRequirementStore
+}
+
+func NewMySQLRequirementRepo(db *gorm.DB) MySQLRequirementRepo {
+	return MySQLRequirementRepo{
+		gormRequirementStore: gormRequirementStore{DB: db},
+	}
+}
+
+func (repo MySQLRequirementRepo) FindCandidates(q CandidateQuery) ([]ReqWithDistance, error) {
+	requirements := []ReqWithDistance{}
+
+	condition, candidateArgs := reqCandidates(q)
+	args := []interface{}{q.Center.Latitude, q.Center.Latitude, q.Center.Longitude, EarthRadius}
+	args = append(args, candidateArgs...)
+	args = append(args, q.Radius)
+
+	err := repo.DB.Raw(repo.getQueryString(condition), args...).Scan(&requirements).Error
+	if err != nil {
+		log.Printf("MySQLRequirementRepo couldn't find candidates for: (query: %v, err: %v)", q, err)
+		return requirements, errors.Wrap(err, "MySQLRequirementRepo couldn't find candidates")
+	}
+	return requirements, nil
+}
+
+func (repo MySQLRequirementRepo) getQueryString(condition string) string {
+	selectClause := "SELECT " + requirementColumns + ", " + mysqlDistanceSelect + " "
+	fromClause := "FROM requirements "
+	// distance is a select alias, so it can only be filtered in HAVING, against the search radius of
+	// each requirement or else the one of the query
+	distCondition := " HAVING distance <= " + reqRadiusSelect
+
+	return selectClause + fromClause + "WHERE " + condition + distCondition
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// EventPropertyMatched is sent to the owner of a stored requirement when a new property matches it
+	EventPropertyMatched = "property_matched"
+	// EventRequirementMatched is sent to the owner of a stored property when a new requirement matches it
+	EventRequirementMatched = "requirement_matched"
+)
+
+// MatchEvent is a "new match" event for the owner of an already stored record, ie: the other
+// side of a match which was only returned to the client adding the new record
+type MatchEvent struct {
+	Kind          string         `json:"kind"`
+	RequirementID uint64         `json:"requirement_id"`
+	PropertyID    uint64         `json:"property_id"`
+	MatchScore    float32        `json:"match_score"`
+	Breakdown     ScoreBreakdown `json:"breakdown"`
+	MatchedAt     time.Time      `json:"matched_at"`
+	// Rates are the exchange rates the match was scored with
+	Rates RateSnapshot `json:"rates"`
+}
+
+// NewPropertyMatchEvents returns a EventPropertyMatched event for every requirement matching the new property
+func NewPropertyMatchEvents(p Property, matches []MatchedRequirement, at time.Time) []MatchEvent {
+	events := make([]MatchEvent, len(matches))
+	for i, m := range matches {
+		events[i] = MatchEvent{
+			Kind:          EventPropertyMatched,
+			RequirementID: m.RequirementID,
+			PropertyID:    p.PropertyID,
+			MatchScore:    m.MatchScore,
+			Breakdown:     m.Breakdown,
+			MatchedAt:     at,
+			Rates:         m.Rates,
+		}
+	}
+	return events
+}
+
+// NewRequirementMatchEvents returns a EventRequirementMatched event for every property matching the new requirement
+func NewRequirementMatchEvents(r Requirement, matches []MatchedProperty, at time.Time) []MatchEvent {
+	events := make([]MatchEvent, len(matches))
+	for i, m := range matches {
+		events[i] = MatchEvent{
+			Kind:          EventRequirementMatched,
+			RequirementID: r.RequirementID,
+			PropertyID:    m.PropertyID,
+			MatchScore:    m.MatchScore,
+			Breakdown:     m.Breakdown,
+			MatchedAt:     at,
+			Rates:         m.Rates,
+		}
+	}
+	return events
+}
+
+// Recipient is the owner of a stored record and how they can be reached. Key identifies the
+// owner for rate limiting, digesting and the in-app inbox, Email and WebhookURL are optional.
+type Recipient struct {
+	Key        string `json:"key"`
+	Email      string `json:"email,omitempty"`
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// Notification is a digest of all the match events of a recipient since their last notification
+type Notification struct {
+	Recipient Recipient    `json:"recipient"`
+	Events    []MatchEvent `json:"events"`
+	SentAt    time.Time    `json:"sent_at"`
+}
+
+// MatchNotifier tells the owners of the stored records matched by a new record about the match
+type MatchNotifier interface {
+	NotifyRequirementOwners(p Property, matches []MatchedRequirement)
+	NotifyPropertyOwners(r Requirement, matches []MatchedProperty)
+}
+
+// NopNotifier is a MatchNotifier which does not notify anyone
+type NopNotifier struct{}
+
+func (NopNotifier) NotifyRequirementOwners(p Property, matches []MatchedRequirement) {}
+
+func (NopNotifier) NotifyPropertyOwners(r Requirement, matches []MatchedProperty) {}
+
+// RecipientResolver finds the owner of a stored requirement or property
+type RecipientResolver interface {
+	RequirementOwner(r Requirement) Recipient
+	PropertyOwner(p Property) Recipient
+}
+
+// RecordRecipientResolver treats every stored record as its own owner, eg: "requirement-42", so
+// the owners can only be reached via the in-app inbox and the deployment wide webhook.
+// It is used for the records which have no owner.
+type RecordRecipientResolver struct{}
+
+func (RecordRecipientResolver) RequirementOwner(r Requirement) Recipient {
+	return Recipient{Key: "requirement-" + strconv.FormatUint(r.RequirementID, 10)}
+}
+
+func (RecordRecipientResolver) PropertyOwner(p Property) Recipient {
+	return Recipient{Key: "property-" + strconv.FormatUint(p.PropertyID, 10)}
+}
+
+// OwnerRecipientResolver routes the notifications of a record to its owner, eg: "owner-7", using the
+// email and webhook of the owner. Records without a known owner fall back to RecordRecipientResolver.
+type OwnerRecipientResolver struct {
+	OwnerRepo OwnerRepository
+}
+
+func NewOwnerRecipientResolver(ownerRepo OwnerRepository) OwnerRecipientResolver {
+	return OwnerRecipientResolver{
+		OwnerRepo: ownerRepo,
+	}
+}
+
+func (res OwnerRecipientResolver) RequirementOwner(r Requirement) Recipient {
+	if to, ok := res.owner(r.OwnerID); ok {
+		return to
+	}
+	return RecordRecipientResolver{}.RequirementOwner(r)
+}
+
+func (res OwnerRecipientResolver) PropertyOwner(p Property) Recipient {
+	if to, ok := res.owner(p.OwnerID); ok {
+		return to
+	}
+	return RecordRecipientResolver{}.PropertyOwner(p)
+}
+
+func (res OwnerRecipientResolver) owner(id uint64) (Recipient, bool) {
+	if id == 0 {
+		return Recipient{}, false
+	}
+	o, err := res.OwnerRepo.FindByID(id)
+	if err != nil {
+		log.Printf("OwnerRecipientResolver couldn't find owner %d: %v", id, err)
+		return Recipient{}, false
+	}
+	return Recipient{
+		Key:        "owner-" + strconv.FormatUint(o.OwnerID, 10),
+		Email:      o.Email,
+		WebhookURL: o.WebhookURL,
+	}, true
+}
+
+// NotificationDispatcher is a MatchNotifier which queues the match events per recipient and, every
+// DigestInterval, sends each recipient a single digest of their queued events on all the Channels.
+// A recipient is sent at most RateLimit digests per RateWindow (0 means no limit), events of
+// recipients over the limit stay queued and go out with their next allowed digest.
+type NotificationDispatcher struct {
+	Resolver       RecipientResolver
+	Channels       []NotificationChannel
+	DigestInterval time.Duration
+	RateLimit      int
+	RateWindow     time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*Notification
+	sent    map[string][]time.Time
+}
+
+func NewNotificationDispatcher(resolver RecipientResolver, channels []NotificationChannel, digestInterval time.Duration, rateLimit int, rateWindow time.Duration) *NotificationDispatcher {
+	return &NotificationDispatcher{
+		Resolver:       resolver,
+		Channels:       channels,
+		DigestInterval: digestInterval,
+		RateLimit:      rateLimit,
+		RateWindow:     rateWindow,
+		pending:        make(map[string]*Notification),
+		sent:           make(map[string][]time.Time),
+	}
+}
+
+// NotifyRequirementOwners queues a EventPropertyMatched event for the owner of every matched requirement
+func (d *NotificationDispatcher) NotifyRequirementOwners(p Property, matches []MatchedRequirement) {
+	events := NewPropertyMatchEvents(p, matches, time.Now())
+	for i := range matches {
+		d.enqueue(d.Resolver.RequirementOwner(matches[i].Requirement), events[i])
+	}
+}
+
+// NotifyPropertyOwners queues a EventRequirementMatched event for the owner of every matched property
+func (d *NotificationDispatcher) NotifyPropertyOwners(r Requirement, matches []MatchedProperty) {
+	events := NewRequirementMatchEvents(r, matches, time.Now())
+	for i := range matches {
+		d.enqueue(d.Resolver.PropertyOwner(matches[i].Property), events[i])
+	}
+}
+
+func (d *NotificationDispatcher) enqueue(to Recipient, e MatchEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n, ok := d.pending[to.Key]
+	if !ok {
+		n = &Notification{Recipient: to}
+		d.pending[to.Key] = n
+	}
+	n.Events = append(n.Events, e)
+}
+
+// Run sends the queued digests every DigestInterval until stop is closed, flushing one last time
+// before returning. It is meant to be run in its own goroutine.
+func (d *NotificationDispatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.DigestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			d.Flush(now)
+		case <-stop:
+			d.Flush(time.Now())
+			return
+		}
+	}
+}
+
+// Flush sends the queued digest of every recipient which is not over its rate limit at time now
+func (d *NotificationDispatcher) Flush(now time.Time) {
+	var due []Notification
+
+	d.mu.Lock()
+	for key, n := range d.pending {
+		if !d.allow(key, now) {
+			continue
+		}
+		n.SentAt = now
+		due = append(due, *n)
+		delete(d.pending, key)
+	}
+	d.mu.Unlock()
+
+	// send outside of the lock so that slow channels don't block the usecases queueing events
+	for _, n := range due {
+		for _, c := range d.Channels {
+			if err := c.Send(n); err != nil {
+				log.Printf("NotificationDispatcher couldn't send on %s: (recipient: %s, err: %v)", c.Name(), n.Recipient.Key, err)
+			}
+		}
+	}
+}
+
+// allow records a digest sent to the recipient at time now if it is within its rate limit
+func (d *NotificationDispatcher) allow(key string, now time.Time) bool {
+	if d.RateLimit <= 0 {
+		return true
+	}
+
+	recent := d.sent[key][:0]
+	for _, t := range d.sent[key] {
+		if now.Sub(t) < d.RateWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= d.RateLimit {
+		d.sent[key] = recent
+		return false
+	}
+	d.sent[key] = append(recent, now)
+	return true
+}
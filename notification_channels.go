@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NotificationChannel delivers a notification digest to its recipient
+type NotificationChannel interface {
+	Name() string
+	Send(n Notification) error
+}
+
+// WebhookChannel POSTs the notification as json to the recipient's webhook, or to the deployment
+// wide URL when the recipient has none, eg: a service which forwards them as push notifications
+type WebhookChannel struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookChannel(url string) WebhookChannel {
+	return WebhookChannel{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c WebhookChannel) Name() string { return "webhook" }
+
+func (c WebhookChannel) Send(n Notification) error {
+	url := n.Recipient.WebhookURL
+	if url == "" {
+		url = c.URL
+	}
+	if url == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "WebhookChannel couldn't encode notification")
+	}
+	resp, err := c.Client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "WebhookChannel couldn't post notification")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("WebhookChannel got status %d from %s", resp.StatusCode, url)
+	}
+	return nil
+}
+
+// EmailChannel mails the notification to recipients having an email address through an SMTP
+// server without auth, eg: a local relay or a stand-in like MailHog during development
+type EmailChannel struct {
+	Addr string // host:port of the SMTP server
+	From string
+}
+
+func NewEmailChannel(addr, from string) EmailChannel {
+	return EmailChannel{
+		Addr: addr,
+		From: from,
+	}
+}
+
+func (c EmailChannel) Name() string { return "email" }
+
+func (c EmailChannel) Send(n Notification) error {
+	if n.Recipient.Email == "" {
+		return nil
+	}
+
+	err := smtp.SendMail(c.Addr, nil, c.From, []string{n.Recipient.Email}, c.message(n))
+	if err != nil {
+		return errors.Wrap(err, "EmailChannel couldn't send mail")
+	}
+	return nil
+}
+
+func (c EmailChannel) message(n Notification) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", c.From)
+	fmt.Fprintf(&b, "To: %s\r\n", n.Recipient.Email)
+	fmt.Fprintf(&b, "Subject: New matches for you (%d)\r\n", len(n.Events))
+	fmt.Fprintf(&b, "Date: %s\r\n", n.SentAt.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	for _, e := range n.Events {
+		switch e.Kind {
+		case EventPropertyMatched:
+			fmt.Fprintf(&b, "Property %d matches your requirement %d with a score of %.0f\r\n", e.PropertyID, e.RequirementID, e.MatchScore)
+		case EventRequirementMatched:
+			fmt.Fprintf(&b, "Requirement %d matches your property %d with a score of %.0f\r\n", e.RequirementID, e.PropertyID, e.MatchScore)
+		}
+	}
+	return []byte(b.String())
+}
+
+// Inbox is the in-app notification channel. It keeps the latest Capacity notifications of every
+// recipient in memory for the app to show, so they are lost on restart.
+type Inbox struct {
+	Capacity int
+
+	mu       sync.RWMutex
+	messages map[string][]Notification
+}
+
+func NewInbox(capacity int) *Inbox {
+	return &Inbox{
+		Capacity: capacity,
+		messages: make(map[string][]Notification),
+	}
+}
+
+func (in *Inbox) Name() string { return "inbox" }
+
+func (in *Inbox) Send(n Notification) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	msgs := append(in.messages[n.Recipient.Key], n)
+	if in.Capacity > 0 && len(msgs) > in.Capacity {
+		msgs = msgs[len(msgs)-in.Capacity:]
+	}
+	in.messages[n.Recipient.Key] = msgs
+	return nil
+}
+
+// Messages returns the notifications of the recipient, oldest first
+func (in *Inbox) Messages(key string) []Notification {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+
+	msgs := make([]Notification, len(in.messages[key]))
+	copy(msgs, in.messages[key])
+	return msgs
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OutboxEvent is a row of the match_events outbox table. The match events of a new property or
+// requirement are stored in the same transaction as the record itself, so that they survive a
+// crash right after the insert, and are then published to an EventSink by the OutboxRelay.
+// The status events of the expired records go through the same outbox.
+type OutboxEvent struct {
+	EventID       uint64     `gorm:"primary_key" json:"event_id"`
+	Kind          string     `json:"kind"`
+	RequirementID uint64     `json:"requirement_id"`
+	PropertyID    uint64     `json:"property_id"`
+	Payload       string     `gorm:"type:text" json:"payload"` // the MatchEvent or StatusEvent as json
+	CreatedAt     time.Time  `json:"created_at"`
+	Attempts      uint16     `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	PublishedAt   *time.Time `json:"published_at"`
+	LastError     string     `gorm:"type:text" json:"last_error"`
+}
+
+func (OutboxEvent) TableName() string {
+	return "match_events"
+}
+
+// NewOutboxEvent returns the pending outbox row of a match event, whose ids must already be set
+func NewOutboxEvent(e MatchEvent) (OutboxEvent, error) {
+	return newOutboxRow(e.Kind, e.RequirementID, e.PropertyID, e.MatchedAt, e)
+}
+
+// NewStatusOutboxEvent returns the pending outbox row of a status event
+func NewStatusOutboxEvent(e StatusEvent) (OutboxEvent, error) {
+	return newOutboxRow(e.Kind, e.RequirementID, e.PropertyID, e.ChangedAt, e)
+}
+
+func newOutboxRow(kind string, requirementID, propertyID uint64, at time.Time, payload interface{}) (OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return OutboxEvent{}, errors.Wrapf(err, "couldn't encode %s event", kind)
+	}
+	return OutboxEvent{
+		Kind:          kind,
+		RequirementID: requirementID,
+		PropertyID:    propertyID,
+		Payload:       string(data),
+		CreatedAt:     at.UTC(),
+		NextAttemptAt: at.UTC(),
+	}, nil
+}
+
+// OutboxRelay publishes the pending outbox events to its Sink, oldest first. An event is only
+// marked as published once the sink accepted it, so delivery is at least once: a crash between
+// the two publishes it again and consumers should dedupe on the EventID. Failed events are
+// retried with an exponential backoff, starting at RetryBackoff and capped at MaxRetryBackoff.
+type OutboxRelay struct {
+	Outbox          OutboxRepository
+	Sink            EventSink
+	PollInterval    time.Duration
+	BatchSize       int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+func NewOutboxRelay(outbox OutboxRepository, sink EventSink, pollInterval time.Duration) OutboxRelay {
+	return OutboxRelay{
+		Outbox:          outbox,
+		Sink:            sink,
+		PollInterval:    pollInterval,
+		BatchSize:       100,
+		RetryBackoff:    time.Second,
+		MaxRetryBackoff: 5 * time.Minute,
+	}
+}
+
+// Run relays the pending events every PollInterval until stop is closed. It is meant to be run
+// in its own goroutine, and only one relay should run per outbox.
+func (relay OutboxRelay) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(relay.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := relay.RelayPending(now); err != nil {
+				log.Printf("OutboxRelay couldn't relay events: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// RelayPending publishes the events due at time now, until none are left or one batch failed
+func (relay OutboxRelay) RelayPending(now time.Time) error {
+	for {
+		events, err := relay.Outbox.FetchPending(now, relay.BatchSize)
+		if err != nil {
+			return errors.Wrap(err, "OutboxRelay couldn't fetch pending events")
+		}
+
+		done := 0
+		for _, e := range events {
+			if relay.publish(e, now) {
+				done++
+			}
+		}
+		// stop at the first batch which didn't fully go through, to not busy loop on a broken sink or outbox
+		if len(events) < relay.BatchSize || done < len(events) {
+			return nil
+		}
+	}
+}
+
+// publish returns true once the event is published and marked as such in the outbox
+func (relay OutboxRelay) publish(e OutboxEvent, now time.Time) bool {
+	err := relay.Sink.Publish(e)
+	if err != nil {
+		next := now.Add(relay.backoff(e.Attempts))
+		log.Printf("OutboxRelay couldn't publish event %d to %s, retrying at %s: %v", e.EventID, relay.Sink.Name(), next.Format(time.RFC3339), err)
+		if err := relay.Outbox.MarkFailed(e.EventID, next, err.Error()); err != nil {
+			log.Printf("OutboxRelay couldn't mark event %d as failed: %v", e.EventID, err)
+		}
+		return false
+	}
+
+	if err := relay.Outbox.MarkPublished(e.EventID, now); err != nil {
+		// the event is published again on the next poll, which at least once delivery allows
+		log.Printf("OutboxRelay couldn't mark event %d as published: %v", e.EventID, err)
+		return false
+	}
+	return true
+}
+
+// backoff returns the delay before the next attempt of an event which already failed attempts times
+func (relay OutboxRelay) backoff(attempts uint16) time.Duration {
+	delay := relay.RetryBackoff
+	for i := uint16(0); i < attempts && delay < relay.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > relay.MaxRetryBackoff {
+		return relay.MaxRetryBackoff
+	}
+	return delay
+}
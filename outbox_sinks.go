@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EventSink is where the OutboxRelay publishes the outbox events. Publish must only return nil
+// once the event is durably accepted, as the event is not published again after that.
+type EventSink interface {
+	Name() string
+	Publish(e OutboxEvent) error
+}
+
+// LogFileSink appends every event as a json line to a file, eg: for a log shipper to pick up
+type LogFileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewLogFileSink(path string) (*LogFileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't open event log %s", path)
+	}
+	return &LogFileSink{file: file}, nil
+}
+
+func (s *LogFileSink) Name() string { return "log file" }
+
+func (s *LogFileSink) Publish(e OutboxEvent) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "LogFileSink couldn't encode event")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "LogFileSink couldn't write event")
+	}
+	return errors.Wrap(s.file.Sync(), "LogFileSink couldn't sync event log")
+}
+
+// WebhookSink POSTs the MatchEvent payload of every event to URL. The EventID is sent in the
+// X-Event-ID header for the receiver to dedupe the redeliveries.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookSink(url string) WebhookSink {
+	return WebhookSink{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s WebhookSink) Name() string { return "webhook" }
+
+func (s WebhookSink) Publish(e OutboxEvent) error {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader([]byte(e.Payload)))
+	if err != nil {
+		return errors.Wrap(err, "WebhookSink couldn't create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", strconv.FormatUint(e.EventID, 10))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "WebhookSink couldn't post event")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("WebhookSink got status %d from %s", resp.StatusCode, s.URL)
+	}
+	return nil
+}
+
+// LocalBroker is a NATS like in process publish/subscribe broker. Subjects are dot separated
+// tokens, eg: "match_events.property_matched", and subscriptions may use the "*" wildcard for
+// a single token and ">" for all the remaining tokens, eg: "match_events.>".
+type LocalBroker struct {
+	mu   sync.RWMutex
+	subs []brokerSubscription
+}
+
+type brokerSubscription struct {
+	subject string
+	handler func(subject string, e OutboxEvent) error
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{}
+}
+
+// Subscribe calls handler with every event published on a subject matching subject
+func (b *LocalBroker) Subscribe(subject string, handler func(subject string, e OutboxEvent) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs = append(b.subs, brokerSubscription{subject: subject, handler: handler})
+}
+
+// Publish synchronously delivers the event to every matching subscriber and fails if any of them does
+func (b *LocalBroker) Publish(subject string, e OutboxEvent) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subs {
+		if !subjectMatches(sub.subject, subject) {
+			continue
+		}
+		if err := sub.handler(subject, e); err != nil {
+			return errors.Wrapf(err, "subscriber of %s failed", sub.subject)
+		}
+	}
+	return nil
+}
+
+func subjectMatches(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
+
+// BrokerSink publishes every event on the "<Prefix>.<kind>" subject of a LocalBroker
+type BrokerSink struct {
+	Broker *LocalBroker
+	Prefix string
+}
+
+func NewBrokerSink(broker *LocalBroker, prefix string) BrokerSink {
+	return BrokerSink{
+		Broker: broker,
+		Prefix: prefix,
+	}
+}
+
+func (s BrokerSink) Name() string { return "broker" }
+
+func (s BrokerSink) Publish(e OutboxEvent) error {
+	return s.Broker.Publish(s.Prefix+"."+e.Kind, e)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// testSink records the ids of the events it accepted, and fails every publish while failing is set
+type testSink struct {
+	failing   bool
+	attempts  int
+	published []uint64
+}
+
+func (s *testSink) Name() string { return "test" }
+
+func (s *testSink) Publish(e OutboxEvent) error {
+	s.attempts++
+	if s.failing {
+		return errors.New("sink is down")
+	}
+	s.published = append(s.published, e.EventID)
+	return nil
+}
+
+func newTestOutbox(t *testing.T, count int, at time.Time) *MemoryOutboxRepo {
+	outbox := NewMemoryOutboxRepo()
+	events := make([]MatchEvent, count)
+	for i := range events {
+		events[i] = MatchEvent{Kind: EventPropertyMatched, RequirementID: uint64(i + 1), PropertyID: 1, MatchedAt: at}
+	}
+	if err := outbox.append(events); err != nil {
+		t.Fatalf("couldn't append the events: %v", err)
+	}
+	return outbox
+}
+
+func TestOutboxRelayDeliversOnce(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	outbox := newTestOutbox(t, 5, start)
+	sink := &testSink{}
+	relay := NewOutboxRelay(outbox, sink, time.Second)
+	relay.BatchSize = 2
+
+	// every batch is relayed in one go, oldest first, and the published events are never sent again
+	for run := 1; run <= 2; run++ {
+		if err := relay.RelayPending(start); err != nil {
+			t.Fatalf("run %d: RelayPending error = %v", run, err)
+		}
+		if want := []uint64{1, 2, 3, 4, 5}; !reflect.DeepEqual(sink.published, want) {
+			t.Errorf("run %d: published %v, want %v", run, sink.published, want)
+		}
+	}
+	if sink.attempts != 5 {
+		t.Errorf("%d publish attempts, want 5", sink.attempts)
+	}
+	if pending, _ := outbox.FetchPending(start, 10); len(pending) != 0 || len(outbox.events) != 0 {
+		t.Errorf("%d events still pending and %d kept, want none", len(pending), len(outbox.events))
+	}
+}
+
+func TestOutboxRelayRetriesFailedEvents(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	outbox := newTestOutbox(t, 1, start)
+	sink := &testSink{failing: true}
+	relay := NewOutboxRelay(outbox, sink, time.Second)
+
+	steps := []struct {
+		name      string
+		after     time.Duration
+		failing   bool
+		attempts  int
+		published []uint64
+	}{
+		{"first attempt fails", 0, true, 1, nil},
+		{"not due yet", 500 * time.Millisecond, true, 1, nil},
+		// the backoff doubles after every failure, so the third attempt is due 1s + 2s after the first
+		{"second attempt fails", time.Second, true, 2, nil},
+		{"still not due", 2 * time.Second, false, 2, nil},
+		{"third attempt goes through", 3 * time.Second, false, 3, []uint64{1}},
+		{"published already", 10 * time.Second, false, 3, []uint64{1}},
+	}
+	for _, step := range steps {
+		sink.failing = step.failing
+		if err := relay.RelayPending(start.Add(step.after)); err != nil {
+			t.Fatalf("%s: RelayPending error = %v", step.name, err)
+		}
+		if sink.attempts != step.attempts || !reflect.DeepEqual(sink.published, step.published) {
+			t.Errorf("%s: %d attempts published %v, want %d attempts publishing %v", step.name, sink.attempts, sink.published, step.attempts, step.published)
+		}
+	}
+}
+
+func TestOutboxRelayMarksEvents(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	outbox := newTestOutbox(t, 2, start)
+	sink := &testSink{failing: true}
+	relay := NewOutboxRelay(outbox, sink, time.Second)
+
+	if err := relay.RelayPending(start); err != nil {
+		t.Fatalf("RelayPending error = %v", err)
+	}
+	for _, e := range outbox.events {
+		if e.Attempts != 1 || e.LastError != "sink is down" || !e.NextAttemptAt.Equal(start.Add(time.Second)) || e.PublishedAt != nil {
+			t.Errorf("failed event %d = %+v, want 1 attempt retried in a second", e.EventID, e)
+		}
+	}
+
+	sink.failing = false
+	published := start.Add(time.Second)
+	if err := relay.RelayPending(published); err != nil {
+		t.Fatalf("RelayPending error = %v", err)
+	}
+	for _, e := range outbox.events {
+		if e.PublishedAt == nil || !e.PublishedAt.Equal(published) {
+			t.Errorf("event %d published at %v, want %v", e.EventID, e.PublishedAt, published)
+		}
+	}
+}
+
+func TestOutboxRelayBackoff(t *testing.T) {
+	relay := NewOutboxRelay(NewMemoryOutboxRepo(), &testSink{}, time.Second)
+	tests := []struct {
+		attempts uint16
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{8, 256 * time.Second},
+		{9, 5 * time.Minute},
+		{1000, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := relay.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// OwnerRequest is the DTO used to register a new agent or client
+type OwnerRequest struct {
+	TenantID   uint64 `json:"-"` // set by the api from the authenticated tenant, never by the client
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	WebhookURL string `json:"webhook_url"`
+}
+
+// OwnerProcessor is the usecase interactor for the owners and their properties and requirements.
+// The owners of a tenant are invisible to the other tenants.
+type OwnerProcessor struct {
+	OwnerRepo  OwnerRepository
+	ReqRepo    RequirementRepository
+	PropRepo   PropertyRepository
+	TenantRepo TenantRepository
+}
+
+func NewOwnerProcessor(ownerRepo OwnerRepository, reqRepo RequirementRepository, propRepo PropertyRepository, tenantRepo TenantRepository) OwnerProcessor {
+	return OwnerProcessor{
+		OwnerRepo:  ownerRepo,
+		ReqRepo:    reqRepo,
+		PropRepo:   propRepo,
+		TenantRepo: tenantRepo,
+	}
+}
+
+// CreateOwner usecase registers a new owner and returns it with its generated OwnerID
+func (oP OwnerProcessor) CreateOwner(o OwnerRequest) (Owner, error) {
+	err := oP.validate(o)
+	if err != nil {
+		return Owner{}, errors.Wrap(err, "OwnerProcessor couldn't validate")
+	}
+
+	owner := NewOwner(o.TenantID, o.Kind, o.Name, o.Email, o.WebhookURL)
+	err = oP.OwnerRepo.Save(owner)
+	if err != nil {
+		log.Printf("OwnerProcessor unable to insert owner: (owner: %v, err: %v)", owner, err)
+		return *owner, errors.Wrap(err, "OwnerProcessor couldn't insert owner")
+	}
+	return *owner, nil
+}
+
+// GetOwner usecase returns a stored owner of the tenant
+func (oP OwnerProcessor) GetOwner(tenantID, id uint64) (Owner, error) {
+	owner, err := oP.OwnerRepo.FindByID(id)
+	if err == nil && owner.TenantID != tenantID {
+		owner, err = Owner{}, errors.Wrapf(ErrNotFound, "owner %d", id)
+	}
+	if err != nil {
+		return owner, errors.Wrap(err, "OwnerProcessor couldn't find owner")
+	}
+	return owner, nil
+}
+
+// ListRequirements usecase returns the requirements of an owner, ie: "my requirements", with their
+// radii in the units
+func (oP OwnerProcessor) ListRequirements(tenantID, ownerID uint64, units DistanceUnits) ([]Requirement, error) {
+	if _, err := oP.GetOwner(tenantID, ownerID); err != nil {
+		return nil, err
+	}
+	requirements, err := oP.ReqRepo.FindByOwner(ownerID)
+	if err != nil {
+		return requirements, errors.Wrap(err, "OwnerProcessor couldn't find requirements")
+	}
+	for i := range requirements {
+		requirements[i] = requirements[i].In(units)
+	}
+	return requirements, nil
+}
+
+// ListProperties usecase returns the property listings of an owner, ie: "my properties"
+func (oP OwnerProcessor) ListProperties(tenantID, ownerID uint64) ([]Property, error) {
+	if _, err := oP.GetOwner(tenantID, ownerID); err != nil {
+		return nil, err
+	}
+	properties, err := oP.PropRepo.FindByOwner(ownerID)
+	if err != nil {
+		return properties, errors.Wrap(err, "OwnerProcessor couldn't find properties")
+	}
+	return properties, nil
+}
+
+// CheckRecipient usecase returns an error caused by ErrNotFound unless the recipient of an inbox,
+// eg: "owner-7" or "requirement-42" (see RecipientResolver), is an owner or a record of the tenant,
+// and of the owner when ownerID isn't 0, so that the inboxes of the others can't be read
+func (oP OwnerProcessor) CheckRecipient(tenantID, ownerID uint64, key string) error {
+	parts := strings.SplitN(key, "-", 2)
+	id, ok := uint64(0), len(parts) == 2
+	if ok {
+		id, ok = parseID(parts[1])
+	}
+	if !ok {
+		return errors.Wrapf(ErrNotFound, "recipient %s", key)
+	}
+
+	var recordTenantID, recordOwnerID uint64
+	var err error
+	switch parts[0] {
+	case "owner":
+		var owner Owner
+		owner, err = oP.OwnerRepo.FindByID(id)
+		recordTenantID, recordOwnerID = owner.TenantID, owner.OwnerID
+	case "requirement":
+		var req Requirement
+		req, err = oP.ReqRepo.FindByID(id)
+		recordTenantID, recordOwnerID = req.TenantID, req.OwnerID
+	case "property":
+		var prop Property
+		prop, err = oP.PropRepo.FindByID(id)
+		recordTenantID, recordOwnerID = prop.TenantID, prop.OwnerID
+	default:
+		err = errors.Wrapf(ErrNotFound, "recipient %s", key)
+	}
+	if err == nil && (recordTenantID != tenantID || (ownerID != 0 && recordOwnerID != ownerID)) {
+		err = errors.Wrapf(ErrNotFound, "recipient %s", key)
+	}
+	if err != nil {
+		return errors.Wrap(err, "OwnerProcessor couldn't find recipient")
+	}
+	return nil
+}
+
+// validate returns an error caused by ErrValidation describing the first bad field of the owner
+func (oP OwnerProcessor) validate(o OwnerRequest) error {
+	if !validOwnerKind(o.Kind) {
+		return errors.Wrapf(ErrValidation, "bad owner kind: %q, must be %q or %q", o.Kind, OwnerAgent, OwnerClient)
+	}
+	if o.Name == "" {
+		return errors.Wrap(ErrValidation, "owner name is missing")
+	}
+	if !validEmail(o.Email) {
+		return errors.Wrapf(ErrValidation, "bad email: %q", o.Email)
+	}
+	if !validWebhookURL(o.WebhookURL) {
+		return errors.Wrapf(ErrValidation, "bad webhook url: %q", o.WebhookURL)
+	}
+	return validTenant(oP.TenantRepo, o.TenantID)
+}
+
+// validOwner returns an error caused by ErrValidation when a property or requirement refers to an
+// unknown owner, or to an owner of another tenant. The OwnerID 0 means the record has no owner.
+func validOwner(repo OwnerRepository, tenantID, ownerID uint64) error {
+	if ownerID == 0 {
+		return nil
+	}
+	owner, err := repo.FindByID(ownerID)
+	if errors.Cause(err) == ErrNotFound || (err == nil && owner.TenantID != tenantID) {
+		log.Printf("unknown owner: %d", ownerID)
+		return errors.Wrapf(ErrValidation, "unknown owner: %d", ownerID)
+	}
+	if err != nil {
+		return errors.Wrap(err, "couldn't find owner")
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// MatchWeights are the maximum marks each matching parameter can contribute to the total score
+type MatchWeights struct {
+	Distance  float32 `json:"distance"`
+	Budget    float32 `json:"budget"`
+	Bedrooms  float32 `json:"bedrooms"`
+	Bathrooms float32 `json:"bathrooms"`
+	// Freshness is only scored by the V2 and V3 matching algorithms, it is 0 by default
+	Freshness float32 `json:"freshness"`
+	// Area and Features are only scored by the V3 matching algorithms, they are 0 by default
+	Area     float32 `json:"area"`
+	Features float32 `json:"features"`
+}
+
+// Sum returns the maximum total score a match can get with these weights
+func (w MatchWeights) Sum() float32 {
+	return w.Distance + w.Budget + w.Bedrooms + w.Bathrooms + w.Freshness + w.Area + w.Features
+}
+
+// Validate makes sure the weights are not negative and sum to 100
+func (w MatchWeights) Validate() error {
+	if math.Abs(float64(w.Sum())-100) > 0.001 {
+		return errors.Errorf("weights must sum to 100, got %v", w.Sum())
+	}
+	if w.Distance < 0 || w.Budget < 0 || w.Bedrooms < 0 || w.Bathrooms < 0 ||
+		w.Freshness < 0 || w.Area < 0 || w.Features < 0 {
+		return errors.Errorf("weights can't be negative: %+v", w)
+	}
+	return nil
+}
+
+// Value stores the weights as JSON, eg: the custom weights of a requirement
+func (w MatchWeights) Value() (driver.Value, error) {
+	data, err := json.Marshal(w)
+	return string(data), err
+}
+
+func (w *MatchWeights) Scan(src interface{}) error {
+	switch data := src.(type) {
+	case []byte:
+		return json.Unmarshal(data, w)
+	case string:
+		return json.Unmarshal([]byte(data), w)
+	default:
+		return errors.Errorf("can't scan %T into MatchWeights", src)
+	}
+}
+
+// MatchPolicy holds all the tunable numbers of the matching, both the base filtering margins used
+// to get the candidates and the bands and weights used by the matching algorithms to score them.
+// Every market can have its own policy without any code change.
+type MatchPolicy struct {
+	// Units are the units the radii of the policy are given in and the default units of the api,
+	// "mi" or "km". The radii are kept in miles whatever the units they are given in.
+	Units DistanceUnits `json:"units"`
+	// SearchRadius is the distance in miles within which candidates are considered at all
+	SearchRadius float32 `json:"search_radius"`
+	// FullScoreRadius is the distance in miles within which the distance gets its full weight
+	FullScoreRadius float32 `json:"full_score_radius"`
+	// PriceMargin is the fraction of the budget/price a candidate price may deviate by, eg: 0.25
+	PriceMargin float32 `json:"price_margin"`
+	// BudgetBand is the fraction around a single bound budget which gets the full budget weight, eg: 0.10
+	BudgetBand float32 `json:"budget_band"`
+	// AreaMargin is the fraction of the area range of a requirement a candidate area may deviate by, eg: 0.2
+	AreaMargin float32 `json:"area_margin"`
+	// RoomsMargin is the number of bedrooms/bathrooms a candidate may deviate by
+	RoomsMargin uint16 `json:"rooms_margin"`
+	// Weights must sum to 100
+	Weights MatchWeights `json:"weights"`
+	// MinScore is the total score below which a candidate is not considered a match
+	MinScore float32 `json:"min_score"`
+	// FreshnessHalfLife is the age in days at which a candidate gets half of the freshness weight
+	FreshnessHalfLife float32 `json:"freshness_half_life"`
+}
+
+// MaxSearchRadius is the largest search radius in miles a requirement can have. A new listing looks
+// for the requirements within this distance, so that the ones having a radius of their own larger
+// than the one of the policy are still found.
+const MaxSearchRadius float32 = 50
+
+// RadiiFor returns the search and full score radii a requirement having the given radii of its own
+// is matched with, any of which can be missing (0). A requirement having only a search radius gets
+// a full score radius in the same proportion to it as the ones of the policy.
+func (mp MatchPolicy) RadiiFor(searchRadius, fullScoreRadius float32) (float32, float32) {
+	if searchRadius <= 0 {
+		return mp.SearchRadius, mp.FullScoreRadius
+	}
+	if fullScoreRadius <= 0 {
+		fullScoreRadius = searchRadius * mp.FullScoreRadius / mp.SearchRadius
+	}
+	return searchRadius, fullScoreRadius
+}
+
+// WeightsFor returns the weights a requirement having the given custom weights is scored with,
+// ie: its own weights if any or else the weights of the policy
+func (mp MatchPolicy) WeightsFor(custom *MatchWeights) MatchWeights {
+	if custom != nil {
+		return *custom
+	}
+	return mp.Weights
+}
+
+// DefaultMatchPolicy returns the policy as given in the original problem statement
+func DefaultMatchPolicy() MatchPolicy {
+	return MatchPolicy{
+		Units:           Miles,
+		SearchRadius:    10,
+		FullScoreRadius: 2,
+		PriceMargin:     0.25,
+		BudgetBand:      0.10,
+		AreaMargin:      0.2,
+		RoomsMargin:     2,
+		Weights: MatchWeights{
+			Distance:  30,
+			Budget:    30,
+			Bedrooms:  20,
+			Bathrooms: 20,
+		},
+		MinScore:          40,
+		FreshnessHalfLife: 30,
+	}
+}
+
+// LoadMatchPolicy starts from the default policy, overrides it with the fields present in the JSON
+// file at path (if path is not empty) and then with the MATCH_* environment variables. The final
+// policy is validated, so a bad policy stops the application instead of producing bad matches.
+func LoadMatchPolicy(path string) (MatchPolicy, error) {
+	policy := DefaultMatchPolicy()
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return policy, errors.Wrapf(err, "couldn't read match policy file %s", path)
+		}
+		if err = json.Unmarshal(data, &policy); err != nil {
+			return policy, errors.Wrapf(err, "couldn't parse match policy file %s", path)
+		}
+	}
+
+	if err := policy.overrideFromEnv(); err != nil {
+		return policy, err
+	}
+
+	if err := policy.Validate(); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+// ParseMatchPolicy overrides the base policy with the fields present in the JSON data, eg: the
+// policy of a tenant over the policy of the deployment, and validates the result
+func ParseMatchPolicy(base MatchPolicy, data []byte) (MatchPolicy, error) {
+	policy := base
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return base, errors.Wrap(err, "couldn't parse match policy")
+	}
+	if err := policy.Validate(); err != nil {
+		return base, err
+	}
+	return policy, nil
+}
+
+// UnmarshalJSON overrides the policy with the fields present in the JSON data, converting the radii
+// present from the units of the policy to miles
+func (mp *MatchPolicy) UnmarshalJSON(data []byte) error {
+	type policy MatchPolicy
+	if err := json.Unmarshal(data, (*policy)(mp)); err != nil {
+		return err
+	}
+
+	var radii struct {
+		SearchRadius    *float32 `json:"search_radius"`
+		FullScoreRadius *float32 `json:"full_score_radius"`
+	}
+	if err := json.Unmarshal(data, &radii); err != nil {
+		return err
+	}
+	if radii.SearchRadius != nil {
+		mp.SearchRadius = mp.Units.ToMiles(*radii.SearchRadius)
+	}
+	if radii.FullScoreRadius != nil {
+		mp.FullScoreRadius = mp.Units.ToMiles(*radii.FullScoreRadius)
+	}
+	return nil
+}
+
+func (mp *MatchPolicy) overrideFromEnv() error {
+	if val, ok := os.LookupEnv("MATCH_UNITS"); ok && val != "" {
+		mp.Units = DistanceUnits(val)
+	}
+	// the radii are given in the units of the policy
+	radii := map[string]*float32{
+		"MATCH_SEARCH_RADIUS":     &mp.SearchRadius,
+		"MATCH_FULL_SCORE_RADIUS": &mp.FullScoreRadius,
+	}
+	for key, field := range radii {
+		val, ok := os.LookupEnv(key)
+		if !ok || val == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(val, 32)
+		if err != nil {
+			return errors.Wrapf(err, "bad value for %s", key)
+		}
+		*field = mp.Units.ToMiles(float32(f))
+	}
+
+	floats := map[string]*float32{
+		"MATCH_PRICE_MARGIN":        &mp.PriceMargin,
+		"MATCH_BUDGET_BAND":         &mp.BudgetBand,
+		"MATCH_AREA_MARGIN":         &mp.AreaMargin,
+		"MATCH_WEIGHT_DISTANCE":     &mp.Weights.Distance,
+		"MATCH_WEIGHT_BUDGET":       &mp.Weights.Budget,
+		"MATCH_WEIGHT_BEDROOMS":     &mp.Weights.Bedrooms,
+		"MATCH_WEIGHT_BATHROOMS":    &mp.Weights.Bathrooms,
+		"MATCH_WEIGHT_FRESHNESS":    &mp.Weights.Freshness,
+		"MATCH_WEIGHT_AREA":         &mp.Weights.Area,
+		"MATCH_WEIGHT_FEATURES":     &mp.Weights.Features,
+		"MATCH_MIN_SCORE":           &mp.MinScore,
+		"MATCH_FRESHNESS_HALF_LIFE": &mp.FreshnessHalfLife,
+	}
+	for key, field := range floats {
+		val, ok := os.LookupEnv(key)
+		if !ok || val == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(val, 32)
+		if err != nil {
+			return errors.Wrapf(err, "bad value for %s", key)
+		}
+		*field = float32(f)
+	}
+
+	if val, ok := os.LookupEnv("MATCH_ROOMS_MARGIN"); ok && val != "" {
+		n, err := strconv.ParseUint(val, 10, 16)
+		if err != nil {
+			return errors.Wrap(err, "bad value for MATCH_ROOMS_MARGIN")
+		}
+		mp.RoomsMargin = uint16(n)
+	}
+	return nil
+}
+
+// Validate makes sure the policy is consistent, eg: the weights sum to 100
+func (mp MatchPolicy) Validate() error {
+	if err := mp.Weights.Validate(); err != nil {
+		return errors.Wrap(err, "bad match policy")
+	}
+	if _, err := ParseDistanceUnits(string(mp.Units)); err != nil {
+		return errors.Wrap(err, "bad match policy")
+	}
+	if mp.SearchRadius <= 0 {
+		return errors.Errorf("match policy search_radius must be positive, got %v", mp.SearchRadius)
+	}
+	if mp.FullScoreRadius < 0 || mp.FullScoreRadius >= mp.SearchRadius {
+		return errors.Errorf("match policy full_score_radius must be in [0, search_radius), got %v", mp.FullScoreRadius)
+	}
+	if mp.PriceMargin <= 0 || mp.PriceMargin >= 1 {
+		return errors.Errorf("match policy price_margin must be in (0, 1), got %v", mp.PriceMargin)
+	}
+	if mp.BudgetBand < 0 || mp.BudgetBand >= mp.PriceMargin {
+		return errors.Errorf("match policy budget_band must be in [0, price_margin), got %v", mp.BudgetBand)
+	}
+	if mp.AreaMargin <= 0 || mp.AreaMargin >= 1 {
+		return errors.Errorf("match policy area_margin must be in (0, 1), got %v", mp.AreaMargin)
+	}
+	if mp.RoomsMargin == 0 {
+		return errors.Errorf("match policy rooms_margin must be at least 1")
+	}
+	if mp.MinScore < 0 || mp.MinScore > 100 {
+		return errors.Errorf("match policy min_score must be in [0, 100], got %v", mp.MinScore)
+	}
+	if mp.FreshnessHalfLife <= 0 {
+		return errors.Errorf("match policy freshness_half_life must be positive, got %v", mp.FreshnessHalfLife)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// The GeoJSON geometries the polygons of a requirement can be given as
+const (
+	GeoJSONPolygon      = "Polygon"
+	GeoJSONMultiPolygon = "MultiPolygon"
+)
+
+const (
+	// MaxPolygons is the number of polygons a requirement can have at most
+	MaxPolygons = 10
+	// MaxPolygonsRadius is the largest distance in miles from the center of the polygons of a
+	// requirement to any of their vertices. A new listing looks for the requirements whose center
+	// is within this distance on top of their search radius.
+	MaxPolygonsRadius float32 = 25
+)
+
+// milesPerDegree is the length in miles of a degree of latitude, or of longitude at the equator
+const milesPerDegree = float64(EarthRadius) * math.Pi / 180
+
+// Polygon is an area on the earth given by its rings: the first one is the outer boundary and the
+// others are holes in it. A ring is closed, ie: its last vertex is the same as its first one.
+type Polygon [][]Coordinate
+
+// Polygons are the areas a requirement looks for listings in, eg: two neighborhoods, instead of a
+// circle around its coordinate. They are given as a GeoJSON Polygon or MultiPolygon geometry, whose
+// positions are [longitude, latitude] pairs, and are always stored and returned as a MultiPolygon.
+type Polygons []Polygon
+
+// geoJSONGeometry is the part of a GeoJSON geometry the polygons are read from
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func (ps Polygons) MarshalJSON() ([]byte, error) {
+	if len(ps) == 0 {
+		return []byte("null"), nil
+	}
+	coordinates := make([][][][2]float32, len(ps))
+	for i, polygon := range ps {
+		coordinates[i] = make([][][2]float32, len(polygon))
+		for j, ring := range polygon {
+			coordinates[i][j] = make([][2]float32, len(ring))
+			for k, c := range ring {
+				coordinates[i][j][k] = [2]float32{c.Longitude, c.Latitude}
+			}
+		}
+	}
+	return json.Marshal(struct {
+		Type        string           `json:"type"`
+		Coordinates [][][][2]float32 `json:"coordinates"`
+	}{GeoJSONMultiPolygon, coordinates})
+}
+
+// UnmarshalJSON reads a GeoJSON Polygon or MultiPolygon geometry and returns an error caused by
+// ErrValidation for any other one
+func (ps *Polygons) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*ps = nil
+		return nil
+	}
+	var geometry geoJSONGeometry
+	if err := json.Unmarshal(data, &geometry); err != nil {
+		return errors.Wrapf(ErrValidation, "bad polygons: %v", err)
+	}
+
+	var coordinates [][][][]float32
+	var err error
+	switch geometry.Type {
+	case GeoJSONPolygon:
+		var polygon [][][]float32
+		err = json.Unmarshal(geometry.Coordinates, &polygon)
+		coordinates = [][][][]float32{polygon}
+	case GeoJSONMultiPolygon:
+		err = json.Unmarshal(geometry.Coordinates, &coordinates)
+	default:
+		return errors.Wrapf(ErrValidation, "bad polygons type: %q, must be %q or %q", geometry.Type, GeoJSONPolygon, GeoJSONMultiPolygon)
+	}
+	if err != nil {
+		return errors.Wrapf(ErrValidation, "bad polygons coordinates: %v", err)
+	}
+
+	polygons := make(Polygons, len(coordinates))
+	for i, rings := range coordinates {
+		polygons[i] = make(Polygon, len(rings))
+		for j, ring := range rings {
+			polygons[i][j] = make([]Coordinate, len(ring))
+			for k, position := range ring {
+				// a position may have an altitude after its longitude and latitude
+				if len(position) < 2 {
+					return errors.Wrapf(ErrValidation, "bad polygons position: %v, must be [longitude, latitude]", position)
+				}
+				polygons[i][j][k] = NewCoordinate(position[1], position[0])
+			}
+		}
+	}
+	*ps = polygons
+	return nil
+}
+
+// Value stores the polygons as a GeoJSON MultiPolygon, or as null when there are none
+func (ps Polygons) Value() (driver.Value, error) {
+	if len(ps) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(ps)
+	return string(data), err
+}
+
+func (ps *Polygons) Scan(src interface{}) error {
+	switch data := src.(type) {
+	case nil:
+		*ps = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, ps)
+	case string:
+		return json.Unmarshal([]byte(data), ps)
+	default:
+		return errors.Errorf("can't scan %T into Polygons", src)
+	}
+}
+
+// Center returns the center of the bounding box of the polygons
+func (ps Polygons) Center() Coordinate {
+	minLat, maxLat := float32(90), float32(-90)
+	minLon, maxLon := float32(180), float32(-180)
+	for _, polygon := range ps {
+		for _, c := range polygon[0] {
+			minLat, maxLat = MinF(minLat, c.Latitude), MaxF(maxLat, c.Latitude)
+			minLon, maxLon = MinF(minLon, c.Longitude), MaxF(maxLon, c.Longitude)
+		}
+	}
+	return NewCoordinate((minLat+maxLat)/2, (minLon+maxLon)/2)
+}
+
+// Radius returns the distance in miles from center to the farthest vertex of the polygons, so
+// that they all are within Radius of center
+func (ps Polygons) Radius(center Coordinate) float32 {
+	radius := float32(0)
+	for _, polygon := range ps {
+		for _, c := range polygon[0] {
+			radius = MaxF(radius, GreatCircleDistance(center, c))
+		}
+	}
+	return radius
+}
+
+// Contains tells if the coordinate is inside one of the polygons and not in one of its holes
+func (ps Polygons) Contains(c Coordinate) bool {
+	for _, polygon := range ps {
+		// ray casting: a ray from c crosses the edges of all the rings an odd number of times
+		// when c is inside the outer ring and outside of the holes
+		inside := false
+		for _, ring := range polygon {
+			for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+				a, b := ring[i], ring[j]
+				if (a.Latitude > c.Latitude) != (b.Latitude > c.Latitude) &&
+					c.Longitude < (b.Longitude-a.Longitude)*(c.Latitude-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+					inside = !inside
+				}
+			}
+		}
+		if inside {
+			return true
+		}
+	}
+	return false
+}
+
+// DistanceTo returns the distance in miles from the coordinate to the nearest edge of the polygons,
+// or 0 when it is inside one of them. The polygons being at most a few dozen miles wide, the edges
+// are measured on a plane tangent to the earth at the coordinate.
+func (ps Polygons) DistanceTo(c Coordinate) float32 {
+	if ps.Contains(c) {
+		return 0
+	}
+	cosLat := math.Cos(DegToRad(float64(c.Latitude)))
+	// project returns the position in miles of a vertex relative to c on the tangent plane
+	project := func(v Coordinate) (float64, float64) {
+		return float64(v.Longitude-c.Longitude) * cosLat * milesPerDegree, float64(v.Latitude-c.Latitude) * milesPerDegree
+	}
+
+	distance := math.MaxFloat64
+	for _, polygon := range ps {
+		for _, ring := range polygon {
+			for i := 1; i < len(ring); i++ {
+				ax, ay := project(ring[i-1])
+				bx, by := project(ring[i])
+				distance = math.Min(distance, distanceToSegment(ax, ay, bx, by))
+			}
+		}
+	}
+	return float32(distance)
+}
+
+// distanceToSegment returns the distance from the origin to the segment from (ax, ay) to (bx, by)
+func distanceToSegment(ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		// the fraction of the segment at which the origin projects on it
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+// propsNearPolygons returns the properties within radius miles of the polygons, along with their
+// distance to them instead of their distance to the center of the polygons
+func propsNearPolygons(ps Polygons, radius float32, properties []PropWithDistance) []PropWithDistance {
+	near := []PropWithDistance{}
+	for _, p := range properties {
+		p.Distance = ps.DistanceTo(NewCoordinate(p.Latitude, p.Longitude))
+		if p.Distance <= radius {
+			near = append(near, p)
+		}
+	}
+	return near
+}
+
+// reqsNearProperty returns the requirements having polygons which are within their search radius
+// of the coordinate of a property, along with its distance to their polygons, and all the other ones
+func reqsNearProperty(policy MatchPolicy, c Coordinate, requirements []ReqWithDistance) []ReqWithDistance {
+	near := []ReqWithDistance{}
+	for _, r := range requirements {
+		if len(r.Polygons) > 0 {
+			radius, _ := policy.RadiiFor(r.SearchRadius, r.FullScoreRadius)
+			r.Distance = r.Polygons.DistanceTo(c)
+			if r.Distance > radius {
+				continue
+			}
+		}
+		near = append(near, r)
+	}
+	return near
+}
+
+// validPolygons returns an error caused by ErrValidation describing the first bad polygon, if any
+func validPolygons(ps Polygons) error {
+	if len(ps) > MaxPolygons {
+		return errors.Wrapf(ErrValidation, "bad polygons: %d polygons, must be at most %d", len(ps), MaxPolygons)
+	}
+	for i, polygon := range ps {
+		if len(polygon) == 0 {
+			return errors.Wrapf(ErrValidation, "bad polygon %d: no rings", i)
+		}
+		for j, ring := range polygon {
+			if len(ring) < 4 || ring[0] != ring[len(ring)-1] {
+				return errors.Wrapf(ErrValidation, "bad polygon %d: ring %d must have 4 positions at least and be closed", i, j)
+			}
+			for _, c := range ring {
+				if !validCoordinate(c.Latitude, c.Longitude) {
+					return errors.Wrapf(ErrValidation, "bad polygon %d: bad coordinate - lat: %f or lon: %f", i, c.Latitude, c.Longitude)
+				}
+			}
+		}
+	}
+	if radius := ps.Radius(ps.Center()); radius > MaxPolygonsRadius {
+		return errors.Wrapf(ErrValidation, "bad polygons: %.1f miles from their center, must be within %v miles", radius, MaxPolygonsRadius)
+	}
+	return nil
+}
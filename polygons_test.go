@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// the polygon tests use a square of 0.02 degrees with a square hole of 0.004 degrees in its middle,
+// and points 0.01 degrees of latitude, ie: about 0.69 miles, away from its edges
+var testSquare = Polygons{{
+	{{12.90, 77.60}, {12.90, 77.62}, {12.92, 77.62}, {12.92, 77.60}, {12.90, 77.60}},
+	{{12.908, 77.608}, {12.908, 77.612}, {12.912, 77.612}, {12.912, 77.608}, {12.908, 77.608}},
+}}
+
+const testEdgeMiles = 0.01 * milesPerDegree
+
+func TestPolygonsDistanceTo(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Coordinate
+		want float64
+	}{
+		{"inside", NewCoordinate(12.905, 77.605), 0},
+		{"on the edge", NewCoordinate(12.90, 77.605), 0},
+		{"on a vertex", NewCoordinate(12.90, 77.60), 0},
+		{"near the south edge", NewCoordinate(12.89, 77.61), testEdgeMiles},
+		{"near the north edge", NewCoordinate(12.93, 77.61), testEdgeMiles},
+		{"near a corner", NewCoordinate(12.89, 77.59), math.Hypot(testEdgeMiles, testEdgeMiles*math.Cos(DegToRad(12.89)))},
+		// the nearest edges of the hole are the ones 0.002 degrees of longitude away
+		{"in the hole", NewCoordinate(12.91, 77.61), 0.002 * milesPerDegree * math.Cos(DegToRad(12.91))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testSquare.DistanceTo(tt.c); math.Abs(float64(got)-tt.want) > 0.001 {
+				t.Errorf("DistanceTo(%v) = %v, want %v", tt.c, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPropsNearPolygons(t *testing.T) {
+	tests := []struct {
+		name     string
+		c        Coordinate
+		near     bool
+		distance float64
+	}{
+		{"inside", NewCoordinate(12.905, 77.605), true, 0},
+		{"on the edge", NewCoordinate(12.90, 77.605), true, 0},
+		{"near the edge", NewCoordinate(12.89, 77.61), true, testEdgeMiles},
+		{"out of the radius", NewCoordinate(12.87, 77.61), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the distance of a candidate is to the center of the polygons until it is filtered
+			prop := PropWithDistance{Property: *NewProperty(1, 0, tt.c.Latitude, tt.c.Longitude, testBudget, 2, 2), Distance: 5}
+			near := propsNearPolygons(testSquare, 1, []PropWithDistance{prop})
+			if len(near) == 1 != tt.near {
+				t.Fatalf("propsNearPolygons(%v) kept %d properties, want near = %v", tt.c, len(near), tt.near)
+			}
+			if tt.near && math.Abs(float64(near[0].Distance)-tt.distance) > 0.001 {
+				t.Errorf("propsNearPolygons(%v) distance = %v, want %v", tt.c, near[0].Distance, tt.distance)
+			}
+		})
+	}
+}
+
+func TestReqsNearProperty(t *testing.T) {
+	policy := DefaultMatchPolicy()
+	withPolygons := ReqWithDistance{Distance: 5}
+	withPolygons.Polygons = testSquare
+	withPolygons.SearchRadius = 1
+	withoutPolygons := ReqWithDistance{Distance: 5}
+
+	tests := []struct {
+		name     string
+		c        Coordinate
+		kept     int
+		distance float64
+	}{
+		{"inside", NewCoordinate(12.905, 77.605), 2, 0},
+		{"on the edge", NewCoordinate(12.90, 77.605), 2, 0},
+		{"near the edge", NewCoordinate(12.89, 77.61), 2, testEdgeMiles},
+		{"out of the radius", NewCoordinate(12.87, 77.61), 1, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			near := reqsNearProperty(policy, tt.c, []ReqWithDistance{withPolygons, withoutPolygons})
+			if len(near) != tt.kept {
+				t.Fatalf("reqsNearProperty(%v) kept %d requirements, want %d", tt.c, len(near), tt.kept)
+			}
+			// the requirement without polygons keeps its distance to the property
+			if got := near[len(near)-1].Distance; got != 5 {
+				t.Errorf("reqsNearProperty(%v) distance without polygons = %v, want 5", tt.c, got)
+			}
+			if tt.kept == 2 && math.Abs(float64(near[0].Distance)-tt.distance) > 0.001 {
+				t.Errorf("reqsNearProperty(%v) distance = %v, want %v", tt.c, near[0].Distance, tt.distance)
+			}
+		})
+	}
+}
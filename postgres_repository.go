@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// MetersPerMile converts the miles used all over the matcher to the meters used by PostGIS geography
+const MetersPerMile float32 = 1609.344
+
+// postgisPoint builds the geography point of a (longitude, latitude) pair, note the order
+const postgisPoint = "ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography"
+
+// PostgresPropertyRepo implements PropertyRepository on top of PostgreSQL with the PostGIS extension.
+// Every row has a geography(Point) location, kept in sync with latitude and longitude by a trigger
+// (see migrations), so the radius search is done by ST_DWithin using the GiST index on location.
+type PostgresPropertyRepo struct {
+	gormPropertyStore
+}
+
+func NewPostgresPropertyRepo(db *gorm.DB) PostgresPropertyRepo {
+	return PostgresPropertyRepo{
+		gormPropertyStore: gormPropertyStore{DB: db},
+	}
+}
+
+func (repo PostgresPropertyRepo) FindCandidates(q CandidateQuery) ([]PropWithDistance, error) {
+	properties := []PropWithDistance{}
+
+	condition, filtersArgs := propFilters(q)
+	args := []interface{}{q.Center.Longitude, q.Center.Latitude, MetersPerMile}
+	args = append(args, q.Center.Longitude, q.Center.Latitude, q.Radius*MetersPerMile)
+	args = append(args, filtersArgs...)
+
+	err := repo.DB.Raw(repo.getQueryString(condition), args...).Scan(&properties).Error
+	if err != nil {
+		log.Printf("PostgresPropertyRepo couldn't find candidates for: (query: %v, err: %v)", q, err)
+		return properties, errors.Wrap(err, "PostgresPropertyRepo couldn't find candidates")
+	}
+	return properties, nil
+}
+
+func (repo PostgresPropertyRepo) getQueryString(condition string) string {
+	selectClause := "SELECT " + propertyColumns + ", " +
+		"ST_Distance(location, " + postgisPoint + ") / ? AS distance "
+	fromClause := "FROM properties "
+	distCondition := "ST_DWithin(location, " + postgisPoint + ", ?) AND "
+
+	return selectClause + fromClause + "WHERE " + distCondition + condition
+}
+
+// PostgresRequirementRepo implements RequirementRepository on top of PostgreSQL with the PostGIS extension
+type PostgresRequirementRepo struct {
+	gormRequirementStore
+}
+
+func NewPostgresRequirementRepo(db *gorm.DB) PostgresRequirementRepo {
+	return PostgresRequirementRepo{
+		gormRequirementStore: gormRequirementStore{DB: db},
+	}
+}
+
+func (repo PostgresRequirementRepo) FindCandidates(q CandidateQuery) ([]ReqWithDistance, error) {
+	requirements := []ReqWithDistance{}
+
+	condition, filtersArgs := reqFilters(q)
+	args := []interface{}{q.Center.Longitude, q.Center.Latitude, MetersPerMile}
+	args = append(args, q.Center.Longitude, q.Center.Latitude, q.MaxRadius*MetersPerMile)
+	args = append(args, q.Center.Longitude, q.Center.Latitude, q.Radius, MetersPerMile)
+	args = append(args, filtersArgs...)
+
+	err := repo.DB.Raw(repo.getQueryString(condition), args...).Scan(&requirements).Error
+	if err != nil {
+		log.Printf("PostgresRequirementRepo couldn't find candidates for: (query: %v, err: %v)", q, err)
+		return requirements, errors.Wrap(err, "PostgresRequirementRepo couldn't find candidates")
+	}
+	return requirements, nil
+}
+
+func (repo PostgresRequirementRepo) getQueryString(condition string) string {
+	selectClause := "SELECT " + requirementColumns + ", " +
+		"ST_Distance(location, " + postgisPoint + ") / ? AS distance "
+	fromClause := "FROM requirements "
+	// the search radius of each requirement can't use the index, so the ones within the largest
+	// radius are found first
+	distCondition := "ST_DWithin(location, " + postgisPoint + ", ?) AND " +
+		"ST_DWithin(location, " + postgisPoint + ", " + reqRadiusSelect + " * ?) AND "
+
+	return selectClause + fromClause + "WHERE " + distCondition + condition
+}
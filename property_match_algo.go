@@ -1,58 +1,191 @@
 package main
 
+import (
+	"time"
+)
+
 type MatchedRequirement struct {
 	Requirement
-	MatchScore float32
+	// Distance is in miles like the radii of the requirement and the reasons of the breakdown, until
+	// the match is converted to the units of the request with In
+	Distance   float32        `json:"distance"`
+	MatchScore float32        `json:"match_score"`
+	Breakdown  ScoreBreakdown `json:"breakdown"`
+	// Rates are the exchange rates the price and the budgets were compared with
+	Rates RateSnapshot `json:"rates"`
+	// fullScoreRadius is the radius in miles the distance was scored with, which the reason of the
+	// distance score is rebuilt with in other units
+	fullScoreRadius float32
 }
 
-func NewMatchedRequirement(r Requirement, score float32) MatchedRequirement {
+func NewMatchedRequirement(r Requirement, distance, fullScoreRadius, score float32, breakdown ScoreBreakdown) MatchedRequirement {
 	return MatchedRequirement{
-		Requirement: r,
-		MatchScore:  score,
+		Requirement:     r,
+		Distance:        distance,
+		MatchScore:      score,
+		Breakdown:       breakdown,
+		fullScoreRadius: fullScoreRadius,
 	}
 }
 
 type PropMatchingAlgo interface {
-	Match(PropListing, []ReqWithDistance, ReqMargins) []MatchedRequirement
+	Match(PropListing, []ReqWithDistance, ReqMargins, MatchPolicy) []MatchedRequirement
 }
 
+// PropMatchAlgoV1 scores the candidate requirements on distance, budget, bedrooms and bathrooms
+// using the bands, weights and minimum score of the MatchPolicy it is given. Every requirement
+// having custom weights or radii is scored with its own ones.
 type PropMatchAlgoV1 struct{}
 
 func NewPropMatchingAlgo() PropMatchAlgoV1 {
 	return PropMatchAlgoV1{}
 }
 
-func (a PropMatchAlgoV1) Match(p PropListing, requirements []ReqWithDistance, rMargins ReqMargins) []MatchedRequirement {
-	matchedReqs := []MatchedRequirement{}
-	scoring := make(chan bool)
-	defer close(scoring)
+func (a PropMatchAlgoV1) Match(p PropListing, requirements []ReqWithDistance, rMargins ReqMargins, policy MatchPolicy) []MatchedRequirement {
+	return scoreReqs(a, p, requirements, rMargins, policy)
+}
 
-	scores := a.createReqScores(requirements)
+func (a PropMatchAlgoV1) components(p PropListing, requirements []ReqWithDistance, rMargins ReqMargins, policy MatchPolicy) []scoreComponent {
+	return []scoreComponent{
+		func(scores []Score) { a.distanceMatching(policy, p.Latitude, p.Longitude, requirements, scores) },
+		func(scores []Score) { a.budgetMatching(policy, p.Price, requirements, scores, rMargins) },
+		func(scores []Score) { a.bedroomsMatching(policy, p.Bedrooms, requirements, scores, rMargins) },
+		func(scores []Score) { a.bathroomsMatching(policy, p.Bathrooms, requirements, scores, rMargins) },
+	}
+}
+
+func (a PropMatchAlgoV1) breakdown(s Score, w MatchWeights) ScoreBreakdown {
+	return NewScoreBreakdown(s, w)
+}
+
+// PropMatchAlgoV2 scores the candidate requirements like PropMatchAlgoV1 and adds the freshness
+// of the requirements, the counterpart of ReqMatchAlgoV2
+type PropMatchAlgoV2 struct {
+	PropMatchAlgoV1
+}
+
+func NewPropMatchingAlgoV2() PropMatchAlgoV2 {
+	return PropMatchAlgoV2{}
+}
+
+func (a PropMatchAlgoV2) Match(p PropListing, requirements []ReqWithDistance, rMargins ReqMargins, policy MatchPolicy) []MatchedRequirement {
+	return scoreReqs(a, p, requirements, rMargins, policy)
+}
+
+func (a PropMatchAlgoV2) components(p PropListing, requirements []ReqWithDistance, rMargins ReqMargins, policy MatchPolicy) []scoreComponent {
+	now := time.Now()
+	return append(a.PropMatchAlgoV1.components(p, requirements, rMargins, policy),
+		func(scores []Score) { a.freshnessMatching(policy, now, requirements, scores) },
+	)
+}
+
+func (a PropMatchAlgoV2) breakdown(s Score, w MatchWeights) ScoreBreakdown {
+	return NewFreshScoreBreakdown(s, w)
+}
+
+func (a PropMatchAlgoV2) freshnessMatching(policy MatchPolicy, now time.Time, r []ReqWithDistance, scores []Score) {
+	for i, _ := range scores {
+		age := now.Sub(r[i].AddedDate)
+		scores[i].AddedDate = r[i].AddedDate
+		scores[i].FreshnessScore = GetFreshnessScore(age, policy.FreshnessHalfLife, policy.WeightsFor(r[i].Weights).Freshness)
+		scores[i].FreshnessReason = FreshnessReason(age, "added")
+	}
+}
+
+// reqScorer is a version of the property matching algorithm, ie: the components it scores the
+// candidate requirements on and the breakdown of their scores, the counterpart of propScorer
+type reqScorer interface {
+	components(p PropListing, requirements []ReqWithDistance, rMargins ReqMargins, policy MatchPolicy) []scoreComponent
+	breakdown(s Score, w MatchWeights) ScoreBreakdown
+}
+
+// scoreReqs is the Match of every version of the property matching algorithm, the counterpart of
+// scoreProps. Each requirement is broken down with its own weights if it has some.
+func scoreReqs(a reqScorer, p PropListing, requirements []ReqWithDistance, rMargins ReqMargins, policy MatchPolicy) []MatchedRequirement {
+	matchedReqs := []MatchedRequirement{}
 
-	// Run the 4 mathching tasks in goroutines to run them concurrently
-	go a.distanceMatching(p.Latitude, p.Longitude, scores, scoring)
-	go a.budgetMatching(p.Price, requirements, scores, rMargins, scoring)
-	go a.bedroomsMatching(p.Bedrooms, requirements, scores, rMargins, scoring)
-	go a.bathroomsMatching(p.Bathrooms, requirements, scores, rMargins, scoring)
+	requirements = excludeOwnReqs(p.OwnerID, requirements)
+	requirements = excludeViolatingReqs(p, requirements)
+	scores := createReqScores(requirements)
 
-	// read from scoring channel, and wait and finish as soon as 4 of the goroutines finishes
-	for i := 0; i < 4; i++ {
-		<-scoring
+	for _, s := range scoreCandidates(scores, a.components(p, requirements, rMargins, policy), policy.MinScore) {
+		r := requirements[s.Index]
+		_, baseDistance := policy.RadiiFor(r.SearchRadius, r.FullScoreRadius)
+		breakdown := a.breakdown(s, policy.WeightsFor(r.Weights))
+		matchedReqs = append(matchedReqs, NewMatchedRequirement(r.Requirement, s.Distance, baseDistance, s.Total, breakdown))
 	}
+	return matchedReqs
+}
+
+// PropMatchAlgoV3 scores the candidate requirements like PropMatchAlgoV2 and adds how well the
+// attributes of the listing meet their asks, the counterpart of ReqMatchAlgoV3
+type PropMatchAlgoV3 struct {
+	PropMatchAlgoV2
+}
 
-	// Score have been added, now final step, sort them
-	SortScores(scores)
+func NewPropMatchingAlgoV3() PropMatchAlgoV3 {
+	return PropMatchAlgoV3{}
+}
+
+func (a PropMatchAlgoV3) Match(p PropListing, requirements []ReqWithDistance, rMargins ReqMargins, policy MatchPolicy) []MatchedRequirement {
+	return scoreReqs(a, p, requirements, rMargins, policy)
+}
 
+func (a PropMatchAlgoV3) components(p PropListing, requirements []ReqWithDistance, rMargins ReqMargins, policy MatchPolicy) []scoreComponent {
+	return append(a.PropMatchAlgoV2.components(p, requirements, rMargins, policy),
+		func(scores []Score) { a.areaMatching(policy, p.Area, requirements, scores) },
+		func(scores []Score) { a.featuresMatching(policy, p.PropertyAttributes, requirements, scores) },
+	)
+}
+
+func (a PropMatchAlgoV3) breakdown(s Score, w MatchWeights) ScoreBreakdown {
+	return NewAttributeScoreBreakdown(s, w)
+}
+
+func (a PropMatchAlgoV3) areaMatching(policy MatchPolicy, area float32, r []ReqWithDistance, scores []Score) {
 	for i, _ := range scores {
-		if scores[i].Total < 40.0 {
-			continue
+		scores[i].AreaScore = GetAreaScore(r[i].MinArea, r[i].MaxArea, area, policy.AreaMargin, policy.WeightsFor(r[i].Weights).Area)
+		scores[i].AreaReason = AreaReason(r[i].MinArea, r[i].MaxArea, area)
+	}
+}
+
+func (a PropMatchAlgoV3) featuresMatching(policy MatchPolicy, p PropertyAttributes, r []ReqWithDistance, scores []Score) {
+	for i, _ := range scores {
+		scores[i].FeaturesScore = GetFeaturesScore(r[i].RequirementAttributes, p, policy.WeightsFor(r[i].Weights).Features)
+		scores[i].FeaturesReason = FeaturesReason(r[i].RequirementAttributes, p)
+	}
+}
+
+// excludeOwnReqs drops the requirements of the owner of the listing, the counterpart of excludeOwnProps
+func excludeOwnReqs(ownerID uint64, requirements []ReqWithDistance) []ReqWithDistance {
+	if ownerID == 0 {
+		return requirements
+	}
+	others := make([]ReqWithDistance, 0, len(requirements))
+	for _, r := range requirements {
+		if r.OwnerID != ownerID {
+			others = append(others, r)
 		}
-		matchedReqs = append(matchedReqs, NewMatchedRequirement(requirements[scores[i].Index].Requirement, scores[i].Total))
 	}
-	return matchedReqs
+	return others
+}
+
+// excludeViolatingReqs drops the requirements whose hard constraints the listing is out of the
+// bounds of, the counterpart of excludeViolatingProps
+func excludeViolatingReqs(p PropListing, requirements []ReqWithDistance) []ReqWithDistance {
+	kept := make([]ReqWithDistance, 0, len(requirements))
+	for _, r := range requirements {
+		// the bounds of the hard budget are in the base currency like the price of the listing
+		req := NewPropRequirementOf(r.Requirement)
+		req.MinBudget, req.MaxBudget = r.BaseMinBudget, r.BaseMaxBudget
+		if MeetsHardConstraints(req, p.Price, p.Bedrooms, p.Bathrooms, p.Area) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
 }
 
-func (a PropMatchAlgoV1) createReqScores(r []ReqWithDistance) []Score {
+func createReqScores(r []ReqWithDistance) []Score {
 	scores := make([]Score, len(r))
 	for i, _ := range r {
 		scores[i] = NewScore(i, r[i].Distance)
@@ -60,35 +193,33 @@ func (a PropMatchAlgoV1) createReqScores(r []ReqWithDistance) []Score {
 	return scores
 }
 
-func (a PropMatchAlgoV1) distanceMatching(lat, lon float32, scores []Score, scoring chan bool) {
-	// base distance and maxDistance in miles
-	baseDistance := float32(2)
-	maxDistance := float32(10)
-
+func (a PropMatchAlgoV1) distanceMatching(policy MatchPolicy, lat, lon float32, r []ReqWithDistance, scores []Score) {
 	for i, _ := range scores {
-		scores[i].DistanceScore = GetDistanceScore(scores[i].Distance, baseDistance, maxDistance)
+		// base distance and maxDistance in miles, the radii of the requirement if it has some
+		maxDistance, baseDistance := policy.RadiiFor(r[i].SearchRadius, r[i].FullScoreRadius)
+		scores[i].DistanceScore = GetDistanceScore(scores[i].Distance, baseDistance, maxDistance, policy.WeightsFor(r[i].Weights).Distance)
+		scores[i].DistanceReason = DistanceReason(scores[i].Distance, baseDistance, Miles)
 	}
-	scoring <- true
 }
 
-func (a PropMatchAlgoV1) budgetMatching(price float32, r []ReqWithDistance, scores []Score, rMargins ReqMargins, scoring chan bool) {
+func (a PropMatchAlgoV1) budgetMatching(policy MatchPolicy, price Money, r []ReqWithDistance, scores []Score, rMargins ReqMargins) {
 	for i, _ := range scores {
-		scores[i].BudgetScore = GetBudgetScore(r[i].MinBudget, r[i].MaxBudget, price, rMargins.MinPrice, rMargins.MaxPrice)
+		scores[i].BudgetScore = GetBudgetScore(r[i].BaseMinBudget, r[i].BaseMaxBudget, price, rMargins.MinPrice, rMargins.MaxPrice, policy.BudgetBand, policy.WeightsFor(r[i].Weights).Budget)
+		scores[i].BudgetReason = BudgetReason(r[i].BaseMinBudget, r[i].BaseMaxBudget, price, policy.BudgetBand)
 	}
-	scoring <- true
 }
 
-func (a PropMatchAlgoV1) bedroomsMatching(bedrooms uint16, r []ReqWithDistance, scores []Score, rMargins ReqMargins, scoring chan bool) {
+func (a PropMatchAlgoV1) bedroomsMatching(policy MatchPolicy, bedrooms uint16, r []ReqWithDistance, scores []Score, rMargins ReqMargins) {
 	for i, _ := range scores {
-		scores[i].BedroomScore = GetBedroomScore(r[i].MinBedrooms, r[i].MaxBedrooms, bedrooms, rMargins.MinBeds, rMargins.MaxBeds)
+		scores[i].BedroomScore = GetBedroomScore(r[i].MinBedrooms, r[i].MaxBedrooms, bedrooms, rMargins.MinBeds, rMargins.MaxBeds, policy.WeightsFor(r[i].Weights).Bedrooms)
+		scores[i].BedroomReason = RoomsReason(r[i].MinBedrooms, r[i].MaxBedrooms, bedrooms, "bedroom")
 	}
-	scoring <- true
 }
 
-func (a PropMatchAlgoV1) bathroomsMatching(bathrooms uint16, r []ReqWithDistance, scores []Score, rMargins ReqMargins, scoring chan bool) {
+func (a PropMatchAlgoV1) bathroomsMatching(policy MatchPolicy, bathrooms uint16, r []ReqWithDistance, scores []Score, rMargins ReqMargins) {
 	for i, _ := range scores {
 		// since algor for bathrooms matching is similar to batrhooms matching, using the same GetBedroomScore function
-		scores[i].BathroomScore = GetBedroomScore(r[i].MinBathrooms, r[i].MaxBathrooms, bathrooms, rMargins.MinBaths, rMargins.MaxBaths)
+		scores[i].BathroomScore = GetBedroomScore(r[i].MinBathrooms, r[i].MaxBathrooms, bathrooms, rMargins.MinBaths, rMargins.MaxBaths, policy.WeightsFor(r[i].Weights).Bathrooms)
+		scores[i].BathroomReason = RoomsReason(r[i].MinBathrooms, r[i].MaxBathrooms, bathrooms, "bathroom")
 	}
-	scoring <- true
 }
@@ -5,165 +5,408 @@ package main
 
 import (
 	"log"
+	"sort"
+	"time"
 
-	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 )
 
 // PropListing is kind of a DTO which is used by CheckFraudulency method of
 // TransactionFraudProcessor to process the transaction
 type PropListing struct {
-	Latitude  float32
-	Longitude float32
-	Price     float32
-	Bedrooms  uint16
-	Bathrooms uint16
+	TenantID uint64 `json:"-"` // set by the api from the authenticated tenant, never by the client
+	// Units are the units of the distances of the result, set by the api
+	Units     DistanceUnits `json:"-"`
+	OwnerID   uint64        `json:"owner_id"`
+	Latitude  float32       `json:"latitude"`
+	Longitude float32       `json:"longitude"`
+	Price     Money         `json:"price"`
+	Bedrooms  uint16        `json:"bedrooms"`
+	Bathrooms uint16        `json:"bathrooms"`
+	// Currency is optional and is the ISO 4217 code of the price, the base currency otherwise
+	Currency string `json:"currency"`
+	// ExpiresAt is optional, the listing expires a TTL after it is added otherwise
+	ExpiresAt *time.Time `json:"expires_at"`
+	PropertyAttributes
+}
+
+// NewPropListingOf returns the listing request a stored property was created from
+func NewPropListingOf(p Property) PropListing {
+	return PropListing{
+		TenantID:  p.TenantID,
+		OwnerID:   p.OwnerID,
+		Latitude:  p.Latitude,
+		Longitude: p.Longitude,
+		Price:     p.Price,
+		Bedrooms:  p.Bedrooms,
+		Bathrooms: p.Bathrooms,
+		Currency:  p.Currency,
+		ExpiresAt: p.ExpiresAt,
+
+		PropertyAttributes: p.PropertyAttributes,
+	}
+}
+
+// PropertyMatchResult is the result of the usecases which store a property listing. It has the
+// property as stored, so that clients can later reference, update or delete it, and its matches.
+type PropertyMatchResult struct {
+	Property Property             `json:"property"`
+	Matches  []MatchedRequirement `json:"matches"`
 }
 
 type ReqWithDistance struct {
 	Requirement
 	Distance float32
+	// BaseMinBudget and BaseMaxBudget are the budgets in the base currency the prices are compared with
+	BaseMinBudget Money
+	BaseMaxBudget Money
 }
 
 // TransactionFraudProcessor is a usecase interactor which has methods which checks if a
 // transactino if fraudulent or not using other usecase interactors or interfaces.
 // It uses a FraudProcessor to process the fraud, a TransactionValidator to validate the transaction
 // and a TransactionRepo to store and retreive history transactions
+// The owners of the matched requirements are told about the new listing by its MatchNotifier.
 type PropProcessor struct {
-	DB             *gorm.DB
+	PropRepo       PropertyRepository
+	ReqRepo        RequirementRepository
+	OwnerRepo      OwnerRepository
+	TenantRepo     TenantRepository
 	MatchAlgorithm PropMatchingAlgo
+	Policy         MatchPolicy
+	TTL            time.Duration
+	Notifier       MatchNotifier
+	Rates          RateProvider
 }
 
-func NewPropProcessor(db *gorm.DB, pAlgo PropMatchingAlgo) PropProcessor {
+func NewPropProcessor(propRepo PropertyRepository, reqRepo RequirementRepository, ownerRepo OwnerRepository, tenantRepo TenantRepository, pAlgo PropMatchingAlgo, policy MatchPolicy, ttl time.Duration, notifier MatchNotifier, rates RateProvider) PropProcessor {
 	return PropProcessor{
-		DB:             db,
+		PropRepo:       propRepo,
+		ReqRepo:        reqRepo,
+		OwnerRepo:      ownerRepo,
+		TenantRepo:     tenantRepo,
 		MatchAlgorithm: pAlgo,
+		Policy:         policy,
+		TTL:            ttl,
+		Notifier:       notifier,
+		Rates:          rates,
 	}
 }
 
-// CheckFraudulency use_case takes a TransactionRequest object as input and creates a domain level
-// Transaction  object and sends it to a FraudProcess which process it from there on, asynchronously.
+// GetMatchingReqs usecase stores a new property listing and returns the stored property, with its
+// generated PropertyID and AddedDate, along with the requirements matching it.
 // It returns an error if there is a problem in any of the above processes.
-func (plP PropProcessor) GetMatchingReqs(p PropListing) ([]MatchedRequirement, error) {
+func (plP PropProcessor) GetMatchingReqs(p PropListing) (PropertyMatchResult, error) {
 	var err error
-	var candidateReqs []ReqWithDistance
-	var matchingReqs []MatchedRequirement
-	var rMargins ReqMargins
+	var result PropertyMatchResult
 
 	// step 0:  validate the Property Requirement Request
-	isValid := plP.validate(p)
-	if !isValid {
+	p = p.withCurrency(plP.Rates.Base())
+	err = plP.validate(p)
+	if err != nil {
+		return result, errors.Wrap(err, "PropProcessor couldn't validate")
+	}
+
+	// step 1 & 2: filter the candidate requirements and run the matching algorithm on them, before
+	// storing the property so that its match events are stored in the same transaction
+	result.Matches, err = plP.matchReqs(p)
+	if err != nil {
+		return result, err
+	}
+
+	// step 3: Add property to database along with its match events
+	result.Property, err = plP.addToDB(p, result.Matches)
+	if err != nil {
+		return result, errors.Wrap(err, "PropProcessor couldn't addToDB")
+	}
+
+	// step 4: let the owners of the matched requirements know about the new match
+	plP.Notifier.NotifyRequirementOwners(result.Property, result.Matches)
+	result.Matches = reqMatchesIn(result.Matches, p.Units)
+	return result, nil
+}
+
+// SearchReqs usecase is the match only (dry run) version of GetMatchingReqs. It validates the
+// listing and returns the matching requirements without storing the listing, eg: for pricing tools.
+func (plP PropProcessor) SearchReqs(p PropListing) ([]MatchedRequirement, error) {
+	var matchingReqs []MatchedRequirement
+
+	p = p.withCurrency(plP.Rates.Base())
+	err := plP.validate(p)
+	if err != nil {
 		return matchingReqs, errors.Wrap(err, "PropProcessor couldn't validate")
 	}
+	matchingReqs, err = plP.matchReqs(p)
+	return reqMatchesIn(matchingReqs, p.Units), err
+}
+
+// RematchProperty usecase re-scores an already stored property listing against the current
+// requirements, without storing anything, and returns their distances in the units
+func (plP PropProcessor) RematchProperty(tenantID, ownerID, id uint64, units DistanceUnits) ([]MatchedRequirement, error) {
+	var matchingReqs []MatchedRequirement
+
+	prop, err := plP.findProperty(tenantID, ownerID, id)
+	if err != nil {
+		return matchingReqs, errors.Wrap(err, "PropProcessor couldn't find property")
+	}
+	matchingReqs, err = plP.matchReqs(NewPropListingOf(prop))
+	return reqMatchesIn(matchingReqs, units), err
+}
 
-	// step 1: Add property listing to database
-	err = plP.addToDB(p)
+// UpdateProperty usecase overwrites the stored property listing with the given values, keeping its
+// id and added date, and returns the requirements matching the updated listing, eg: after a price drop
+func (plP PropProcessor) UpdateProperty(id uint64, p PropListing) (PropertyMatchResult, error) {
+	var result PropertyMatchResult
+
+	p = p.withCurrency(plP.Rates.Base())
+	err := plP.validate(p)
 	if err != nil {
-		return matchingReqs, errors.Wrap(err, "PropProcessor couldn't addToDB")
+		return result, errors.Wrap(err, "PropProcessor couldn't validate")
+	}
+
+	existing, err := plP.findProperty(p.TenantID, p.OwnerID, id)
+	if err != nil {
+		return result, errors.Wrap(err, "PropProcessor couldn't find property")
+	}
+	// the owner of a stored listing never changes, neither does its tenant, and its status is
+	// only changed by SetPropertyStatus
+	p.OwnerID = existing.OwnerID
+
+	prop := NewProperty(p.TenantID, p.OwnerID, p.Latitude, p.Longitude, p.Price, p.Bedrooms, p.Bathrooms)
+	prop.Currency = p.Currency
+	prop.PropertyAttributes = p.PropertyAttributes
+	prop.PropertyID = existing.PropertyID
+	prop.AddedDate = existing.AddedDate
+	prop.Status = existing.Status
+	prop.ExpiresAt = existing.ExpiresAt
+	if p.ExpiresAt != nil {
+		prop.ExpiresAt = expiryOf(p.ExpiresAt, plP.TTL, time.Now())
 	}
 
-	// step 2: Base Filtering - filter out a certain set of requirements first based on parameters which gives a set of possible candidate requirements
-	candidateReqs, rMargins, err = plP.getCandidateReqs(p)
+	// like a new listing, the updated one is stored along with the match events of its matches and
+	// the owners of the matched requirements are notified, unless it is no longer active
+	result.Matches, err = plP.matchReqs(p)
+	if err != nil {
+		return result, err
+	}
+	events := []MatchEvent{}
+	if prop.Status == StatusActive {
+		events = NewPropertyMatchEvents(*prop, result.Matches, time.Now())
+	}
+	err = plP.PropRepo.SaveWithEvents(prop, events)
 	if err != nil {
-		return matchingReqs, errors.Wrap(err, "PropProcessor couldn't getCandidateReqs")
+		log.Printf("PropProcessor unable to update property: (prop: %v, err: %v)", prop, err)
+		return result, errors.Wrap(err, "PropProcessor couldn't update property")
 	}
+	result.Property = *prop
 
-	// step 3: Run algorithm on candidate requirements and get a result set of matching requirement
-	matchingReqs = plP.MatchAlgorithm.Match(p, candidateReqs, rMargins)
+	if prop.Status == StatusActive {
+		plP.Notifier.NotifyRequirementOwners(result.Property, result.Matches)
+	}
+	result.Matches = reqMatchesIn(result.Matches, p.Units)
+	return result, nil
+}
+
+// DeleteProperty usecase removes the stored property listing so that new requirements no longer match it
+func (plP PropProcessor) DeleteProperty(tenantID, ownerID, id uint64) error {
+	_, err := plP.findProperty(tenantID, ownerID, id)
+	if err == nil {
+		err = plP.PropRepo.Delete(id)
+	}
+	if err != nil {
+		return errors.Wrap(err, "PropProcessor couldn't delete property")
+	}
+	return nil
+}
+
+// SetPropertyStatus usecase changes the status of a stored listing, eg: pauses it or marks it as
+// sold, and returns it. Only the active listings are matched against the new requirements.
+func (plP PropProcessor) SetPropertyStatus(tenantID, ownerID, id uint64, s StatusRequest) (Property, error) {
+	err := s.validate()
+	if err != nil {
+		return Property{}, errors.Wrap(err, "PropProcessor couldn't validate")
+	}
+
+	prop, err := plP.findProperty(tenantID, ownerID, id)
+	if err != nil {
+		return prop, errors.Wrap(err, "PropProcessor couldn't find property")
+	}
+	prop.Status = s.Status
+	prop.ExpiresAt = renewedExpiry(prop.ExpiresAt, s, plP.TTL, time.Now())
+
+	err = plP.PropRepo.Update(&prop)
+	if err != nil {
+		log.Printf("PropProcessor unable to update property status: (prop: %v, err: %v)", prop, err)
+		return prop, errors.Wrap(err, "PropProcessor couldn't update property status")
+	}
+	return prop, nil
+}
+
+// findProperty returns the stored property if it belongs to the tenant and to the owner, as if
+// the properties of the other tenants, or of the other owners, did not exist. The ownerID 0 is
+// any owner of the tenant, ie: the request acts for the whole tenant.
+func (plP PropProcessor) findProperty(tenantID, ownerID, id uint64) (Property, error) {
+	prop, err := plP.PropRepo.FindByID(id)
+	if err != nil {
+		return prop, err
+	}
+	if prop.TenantID != tenantID || (ownerID != 0 && prop.OwnerID != ownerID) {
+		return Property{}, errors.Wrapf(ErrNotFound, "property %d", id)
+	}
+	return prop, nil
+}
+
+// matchReqs runs the Base Filtering and the matching algorithm for an already validated listing.
+// The listing is matched against the requirements of its tenant and of its sharing agreement partners,
+// every requirement being filtered and scored with the match policy of its own tenant, so that its
+// score doesn't depend on the tenant of the listing.
+func (plP PropProcessor) matchReqs(p PropListing) ([]MatchedRequirement, error) {
+	matchingReqs := []MatchedRequirement{}
+
+	partners, err := plP.TenantRepo.PartnerTenants(p.TenantID)
+	if err != nil {
+		return matchingReqs, errors.Wrap(err, "PropProcessor couldn't find the partner tenants")
+	}
+	policies, err := tenantPolicies(plP.TenantRepo, plP.Policy, append([]uint64{p.TenantID}, partners...))
+	if err != nil {
+		return matchingReqs, errors.Wrap(err, "PropProcessor couldn't get the match policies")
+	}
+
+	// the price is compared with the budgets in the base currency, all of them converted with the
+	// same rates, which are recorded alongside each match
+	rates, err := plP.Rates.Snapshot()
+	if err != nil {
+		return matchingReqs, errors.Wrap(err, "PropProcessor couldn't get the exchange rates")
+	}
+	base, ok := p.inBase(rates)
+	if !ok {
+		return matchingReqs, errors.Wrapf(ErrValidation, "PropProcessor couldn't convert the price from %s to %s", p.Currency, rates.Base)
+	}
+
+	for _, tp := range policies {
+		// step 2: Base Filtering - filter out a certain set of requirements first based on parameters which gives a set of possible candidate requirements
+		candidateReqs, rMargins, err := plP.getCandidateReqs(base, tp.Policy, tp.TenantIDs, rates)
+		if err != nil {
+			return matchingReqs, errors.Wrap(err, "PropProcessor couldn't getCandidateReqs")
+		}
+
+		// step 3: Run algorithm on candidate requirements and get a result set of matching requirement
+		matchingReqs = append(matchingReqs, plP.MatchAlgorithm.Match(base, candidateReqs, rMargins, tp.Policy)...)
+	}
+	for i := range matchingReqs {
+		matchingReqs[i].Rates = rates.Of(p.Currency, matchingReqs[i].Currency)
+	}
+	if len(policies) > 1 {
+		sortMatchedReqs(matchingReqs)
+	}
 	return matchingReqs, nil
 }
 
-func (plP PropProcessor) validate(p PropListing) bool {
+// sortMatchedReqs sorts the matches of several match policies, each of them already sorted by the
+// matching algorithm, by score and then distance
+func sortMatchedReqs(matches []MatchedRequirement) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].MatchScore != matches[j].MatchScore {
+			return matches[i].MatchScore > matches[j].MatchScore
+		}
+		return matches[i].Distance < matches[j].Distance
+	})
+}
+
+// validate returns an error caused by ErrValidation describing the first bad field of the listing
+func (plP PropProcessor) validate(p PropListing) error {
 	if !validCoordinate(p.Latitude, p.Longitude) {
 		log.Printf("bad coordinate - lat: %f or lon: %f", p.Latitude, p.Longitude)
-		return false
+		return errors.Wrapf(ErrValidation, "bad coordinate - lat: %f or lon: %f", p.Latitude, p.Longitude)
 	}
 	if !validPrice(p.Price) {
-		log.Printf("bad price val: %f", p.Price)
-		return false
+		log.Printf("bad price val: %v", p.Price)
+		return errors.Wrapf(ErrValidation, "bad price val: %v", p.Price)
+	}
+	if err := validCurrency(plP.Rates, p.Currency); err != nil {
+		log.Printf("bad currency: %v", err)
+		return err
 	}
 	if !validBedrooms(p.Bedrooms) {
 		log.Printf("bad bedrooms val: %d", p.Bedrooms)
-		return false
+		return errors.Wrapf(ErrValidation, "bad bedrooms val: %d", p.Bedrooms)
 	}
 	if !validBathrooms(p.Bathrooms) {
 		log.Printf("bad bathrooms val: %d", p.Bathrooms)
-		return false
+		return errors.Wrapf(ErrValidation, "bad bathrooms val: %d", p.Bathrooms)
+	}
+	if !validExpiresAt(p.ExpiresAt) {
+		log.Printf("bad expires_at: %s", p.ExpiresAt)
+		return errors.Wrapf(ErrValidation, "bad expires_at: %s, must be in the future", p.ExpiresAt)
+	}
+	if err := validPropertyAttributes(p.PropertyAttributes); err != nil {
+		log.Printf("bad property attributes: %v", err)
+		return err
 	}
-	return true
+	return validOwner(plP.OwnerRepo, p.TenantID, p.OwnerID)
 }
 
-func (plP PropProcessor) addToDB(p PropListing) error {
-	newProperty := NewProperty(p.Latitude, p.Longitude, p.Price, p.Bedrooms, p.Bathrooms)
+// addToDB stores a new property along with the match events of its matches and returns it as
+// stored, ie: with its generated PropertyID
+func (plP PropProcessor) addToDB(p PropListing, matches []MatchedRequirement) (Property, error) {
+	newProperty := NewProperty(p.TenantID, p.OwnerID, p.Latitude, p.Longitude, p.Price, p.Bedrooms, p.Bathrooms)
+	newProperty.Currency = p.Currency
+	newProperty.PropertyAttributes = p.PropertyAttributes
+	newProperty.ExpiresAt = expiryOf(p.ExpiresAt, plP.TTL, newProperty.AddedDate)
+	events := NewPropertyMatchEvents(*newProperty, matches, newProperty.AddedDate)
 
-	err := plP.DB.Debug().Create(newProperty).Error
+	err := plP.PropRepo.SaveWithEvents(newProperty, events)
 	if err != nil {
 		log.Printf("PropProcessor unable to insert property: (prop: %v, err: %v)", newProperty, err)
-		return errors.Wrap(err, "PropProcessor couldn't insert property")
+		return *newProperty, errors.Wrap(err, "PropProcessor couldn't insert property")
 	}
-	return nil
+	return *newProperty, nil
 }
 
-// createTransaction is a helper function which takes TransactionRequest object and returns pointer instance of domain.Transaction
-func (plP PropProcessor) getCandidateReqs(p PropListing) ([]ReqWithDistance, ReqMargins, error) {
-	requirements := []ReqWithDistance{}
-	distanceRange := float32(10) // distance threshold in miles
-	rMargins := plP.getReqMargins(p, distanceRange)
-
-	queryString := plP.getQueryString()
+// getCandidateReqs is the base filtering step which asks the requirement repository for the requirements
+// falling inside the distance range and overlapping the price, bedrooms, bathrooms and attribute margins
+// of the listing. Every requirement is within its own search radius, or within the one of the policy if it
+// has none, of its coordinate or of its polygons, so the bounding box is as large as the largest search radius
+// a requirement can have plus the largest radius its polygons can have.
+// Only the requirements of the tenants having the match policy are candidates.
+// The price of the listing is in the base currency of the rates, which the budgets are converted to.
+func (plP PropProcessor) getCandidateReqs(p PropListing, policy MatchPolicy, tenantIDs []uint64, rates RateSnapshot) ([]ReqWithDistance, ReqMargins, error) {
+	distanceRange := policy.SearchRadius // distance threshold in miles
+	maxDistanceRange := MaxF(distanceRange, MaxSearchRadius) + MaxPolygonsRadius
+	rMargins := plP.getReqMargins(policy, p, maxDistanceRange)
+	attributes := PropertyAttributeWindows(p.PropertyAttributes, policy.AreaMargin)
 
-	err := plP.DB.Debug().
-		Raw(queryString, p.Latitude, p.Latitude, p.Longitude, EarthRadius,
-			rMargins.MinLat, rMargins.MaxLat, rMargins.MinLon, rMargins.MaxLon,
-			distanceRange, p.Price, rMargins.MinPrice, rMargins.MaxPrice, rMargins.MinPrice, rMargins.MaxPrice,
-			p.Bedrooms, rMargins.MinBeds, rMargins.MaxBeds, rMargins.MinBeds, rMargins.MaxBeds,
-			p.Bathrooms, rMargins.MinBaths, rMargins.MaxBaths, rMargins.MinBaths, rMargins.MaxBaths).
-		Scan(&requirements).Error
+	requirements, err := plP.ReqRepo.FindCandidates(NewCandidateQuery(rMargins, attributes, NewCoordinate(p.Latitude, p.Longitude), distanceRange, maxDistanceRange, tenantIDs, rates))
 	if err != nil {
 		log.Printf("PropProcessor couldn't getCandidateReqs for: (property: %v, err: %v)", p, err)
 		return requirements, rMargins, errors.Wrap(err, "PropProcessor couldn't getCandidateReqs")
 	}
+	requirements = reqsInBase(rates, requirements)
+	requirements = reqsNearProperty(policy, NewCoordinate(p.Latitude, p.Longitude), requirements)
 
 	return requirements, rMargins, nil
 }
 
-func (plP PropProcessor) getQueryString() string {
-	selectBaseClause := "SELECT requirement_id, latitude, longitude, "
-	selectDistanceClause := "acos(sin(radians(latitude))*sin(radians(?)) + cos(radiand(latitude))*cos(radians(?))*cos(radians(?) - radians(longitude)) ) * ? as distance, "
-	selectRestClause := "min_budget, max_budget, min_bedrooms, max_bedrooms, min_bathrooms, max_bathrooms "
-
-	fromClause := "FROM requirements "
-
-	latCondition := "latitude BETWEEN ? AND ? "
-	lonCondition := "AND longitude BETWEEN ? AND ? "
-	distCondition := "AND distance <= ? "
-	priceCondition := "AND ((? BETWEEN min_budget AND max_budget) OR (min_budget BETWEEN ? AND ?) OR (max_budget BETWEEN ? AND ?) "
-	bedsCondtion := "AND ((? BETWEEN min_bedrooms AND max_bedrooms) OR (min_bedrooms BETWEEN ? AND ?) OR (max_bedrooms BETWEEN ? AND ?) "
-	bathsCondition := "AND ((? BETWEEN min_bathrooms AND max_bathrooms) OR (min_bathrooms BETWEEN ? AND ?) OR (max_bathrooms BETWEEN ? AND ?)"
-
-	return selectBaseClause + selectDistanceClause + selectRestClause +
-		fromClause + "Where " + latCondition + lonCondition + distCondition +
-		priceCondition + bedsCondtion + bathsCondition
-}
-
-func (plP PropProcessor) getReqMargins(p PropListing, distanceRange float32) ReqMargins {
+func (plP PropProcessor) getReqMargins(policy MatchPolicy, p PropListing, distanceRange float32) ReqMargins {
 	minLat, maxLat := GetMinMaxLat(p.Latitude, distanceRange)
 	minLon, maxLon := GetMinMaxLon(p.Latitude, p.Longitude, distanceRange)
-	minPrice, maxPrice := plP.getMinMaxPrice(p.Price)
-	minBeds, maxBeds := plP.getMinMaxBedrooms(p.Bedrooms)
-	minBaths, maxBaths := plP.getMinMaxBathrooms(p.Bathrooms)
+	minPrice, maxPrice := plP.getMinMaxPrice(policy, p.Price)
+	minBeds, maxBeds := plP.getMinMaxBedrooms(policy, p.Bedrooms)
+	minBaths, maxBaths := plP.getMinMaxBathrooms(policy, p.Bathrooms)
 
 	return NewReqMargins(minLat, maxLat, minLon, maxLon, minPrice, maxPrice, minBeds, maxBeds, minBaths, maxBaths)
 }
 
-func (plP PropProcessor) getMinMaxPrice(price float32) (float32, float32) {
-	return MaxF((price - (0.25 * price)), 1.0), MaxF((price + (0.25 * price)), 1.25)
+func (plP PropProcessor) getMinMaxPrice(policy MatchPolicy, price Money) (Money, Money) {
+	margin := policy.PriceMargin
+	return MaxMoney(price.Scaled(-margin), OneUnit), MaxMoney(price.Scaled(margin), OneUnit.Scaled(margin))
 }
 
-func (plP PropProcessor) getMinMaxBedrooms(bedrooms uint16) (uint16, uint16) {
-	return Max(bedrooms-2, 1), Max(bedrooms+2, 3)
+func (plP PropProcessor) getMinMaxBedrooms(policy MatchPolicy, bedrooms uint16) (uint16, uint16) {
+	margin := policy.RoomsMargin
+	return Max(SubSat(bedrooms, margin), 1), Max(bedrooms+margin, 1+margin)
 }
 
-func (plP PropProcessor) getMinMaxBathrooms(bathrooms uint16) (uint16, uint16) {
-	return Max(bathrooms-2, 1), Max(bathrooms+2, 3)
+func (plP PropProcessor) getMinMaxBathrooms(policy MatchPolicy, bathrooms uint16) (uint16, uint16) {
+	return plP.getMinMaxBedrooms(policy, bathrooms)
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPropProcessorGetMinMaxPrice(t *testing.T) {
+	policy := DefaultMatchPolicy()
+	tests := []struct {
+		name             string
+		price            Money
+		wantMin, wantMax Money
+	}{
+		{"exact margin", testBudget, testMinPrice, testMaxPrice},
+		{"rounded margin", 12345, 9259, 15431},
+		{"floored at one unit", 1, OneUnit, OneUnit.Scaled(policy.PriceMargin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMin, gotMax := PropProcessor{}.getMinMaxPrice(policy, tt.price)
+			if gotMin != tt.wantMin || gotMax != tt.wantMax {
+				t.Errorf("getMinMaxPrice(%v) = (%v, %v), want (%v, %v)", tt.price, gotMin, gotMax, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
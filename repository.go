@@ -0,0 +1,152 @@
+package main
+
+import (
+	"time"
+)
+
+// Coordinate is a point on the earth given by its latitude and longitude in degrees
+type Coordinate struct {
+	Latitude  float32
+	Longitude float32
+}
+
+func NewCoordinate(lat, lon float32) Coordinate {
+	return Coordinate{
+		Latitude:  lat,
+		Longitude: lon,
+	}
+}
+
+// CandidateQuery is the base filtering query of FindCandidates: the records within Radius miles of
+// Center which fall inside the windows of Margins and Attributes and belong to one of the TenantIDs.
+// The requirements having a search radius of their own are within it instead of Radius, widened by
+// the radius of their polygons if they have some, and all of them are within MaxRadius, which is the
+// same as Radius for the properties. The price window of Margins is in the base currency of Rates,
+// which the amounts of the records are converted with.
+type CandidateQuery struct {
+	Margins    ReqMargins
+	Attributes AttributeWindows
+	Center     Coordinate
+	Radius     float32
+	MaxRadius  float32
+	TenantIDs  []uint64
+	Rates      RateSnapshot
+}
+
+func NewCandidateQuery(rMargins ReqMargins, attributes AttributeWindows, center Coordinate, radius, maxRadius float32, tenantIDs []uint64, rates RateSnapshot) CandidateQuery {
+	return CandidateQuery{
+		Margins:    rMargins,
+		Attributes: attributes,
+		Center:     center,
+		Radius:     radius,
+		MaxRadius:  maxRadius,
+		TenantIDs:  tenantIDs,
+		Rates:      rates,
+	}
+}
+
+// RadiusOf returns the distance in miles within which the requirement is a candidate
+func (q CandidateQuery) RadiusOf(r Requirement) float32 {
+	if r.SearchRadius > 0 {
+		return r.SearchRadius + r.PolygonsRadius
+	}
+	return q.Radius + r.PolygonsRadius
+}
+
+// PropertyRepository is the storage gateway for property listings. The usecase processors
+// only depend on this interface, so that any store (mysql, in memory etc) can be plugged in.
+type PropertyRepository interface {
+	// Save stores a new property and sets its generated PropertyID
+	Save(p *Property) error
+	// SaveWithEvents stores a new property, or overwrites the stored one having the same PropertyID,
+	// along with its match events in the outbox, atomically. The PropertyID of the events is set to
+	// the PropertyID of the property.
+	SaveWithEvents(p *Property, events []MatchEvent) error
+	// FindByID returns the stored property, or an error caused by ErrNotFound
+	FindByID(id uint64) (Property, error)
+	// FindByOwner returns all the properties of the owner, oldest first
+	FindByOwner(ownerID uint64) ([]Property, error)
+	// Update overwrites the stored property having the same PropertyID
+	Update(p *Property) error
+	// Delete removes the stored property, or returns an error caused by ErrNotFound
+	Delete(id uint64) error
+	// FindCandidates returns all the active properties matching the query, ie: which also fall inside
+	// its price, bedrooms, bathrooms and attribute windows, along with their distance
+	FindCandidates(q CandidateQuery) ([]PropWithDistance, error)
+	// ExpireDue sets up to limit active properties due at time now, ie: whose ExpiresAt is passed or
+	// which have none and were added before addedBefore, to StatusExpired along with a status event
+	// for each of them in the outbox, atomically, and returns them. A property which is no longer due
+	// when it is expired, eg: paused by a concurrent request, is left alone.
+	ExpireDue(now, addedBefore time.Time, limit int) ([]Property, error)
+}
+
+// RequirementRepository is the storage gateway for requirements, the counterpart of PropertyRepository
+type RequirementRepository interface {
+	// Save stores a new requirement and sets its generated RequirementID
+	Save(r *Requirement) error
+	// SaveWithEvents stores a new requirement, or overwrites the stored one having the same RequirementID,
+	// along with its match events in the outbox, atomically. The RequirementID of the events is set to
+	// the RequirementID of the requirement.
+	SaveWithEvents(r *Requirement, events []MatchEvent) error
+	// FindByID returns the stored requirement, or an error caused by ErrNotFound
+	FindByID(id uint64) (Requirement, error)
+	// FindByOwner returns all the requirements of the owner, oldest first
+	FindByOwner(ownerID uint64) ([]Requirement, error)
+	// Update overwrites the stored requirement having the same RequirementID
+	Update(r *Requirement) error
+	// Delete removes the stored requirement, or returns an error caused by ErrNotFound
+	Delete(id uint64) error
+	// FindCandidates returns all the active requirements matching the query, ie: whose budget, bedrooms
+	// and bathrooms ranges and attribute asks overlap its windows, along with their distance
+	FindCandidates(q CandidateQuery) ([]ReqWithDistance, error)
+	// ExpireDue is the requirements counterpart of PropertyRepository.ExpireDue
+	ExpireDue(now, addedBefore time.Time, limit int) ([]Requirement, error)
+}
+
+// OwnerRepository is the storage gateway for the owners of the properties and requirements
+type OwnerRepository interface {
+	// Save stores a new owner and sets its generated OwnerID
+	Save(o *Owner) error
+	// FindByID returns the stored owner, or an error caused by ErrNotFound
+	FindByID(id uint64) (Owner, error)
+}
+
+// TenantRepository is the storage gateway for the tenants and their sharing agreements
+type TenantRepository interface {
+	// Save stores a new tenant and sets its generated TenantID
+	Save(t *Tenant) error
+	// FindByID returns the stored tenant, or an error caused by ErrNotFound
+	FindByID(id uint64) (Tenant, error)
+	// Update overwrites the stored tenant having the same TenantID
+	Update(t *Tenant) error
+	// SaveAgreement stores a sharing agreement, storing an existing one again is a no-op
+	SaveAgreement(a *SharingAgreement) error
+	// DeleteAgreement removes a sharing agreement, or returns an error caused by ErrNotFound
+	DeleteAgreement(sharingTenantID, partnerTenantID uint64) error
+	// SharingTenants returns the tenants sharing their listings with the partner tenant
+	SharingTenants(partnerTenantID uint64) ([]uint64, error)
+	// PartnerTenants returns the tenants the sharing tenant shares its listings with
+	PartnerTenants(sharingTenantID uint64) ([]uint64, error)
+}
+
+// OutboxRepository is the storage gateway of the OutboxRelay to the match events stored by SaveWithEvents
+type OutboxRepository interface {
+	// FetchPending returns up to limit unpublished events due at time now, oldest first
+	FetchPending(now time.Time, limit int) ([]OutboxEvent, error)
+	// MarkPublished records that the event was published at time at
+	MarkPublished(id uint64, at time.Time) error
+	// MarkFailed records a failed attempt to publish the event and when to try it again
+	MarkFailed(id uint64, nextAttemptAt time.Time, reason string) error
+}
+
+// APIKeyRepository is the storage gateway for the API keys
+type APIKeyRepository interface {
+	// Save stores a new key and sets its generated KeyID
+	Save(k *APIKey) error
+	// FindByHash returns the stored key having the hash, or an error caused by ErrNotFound
+	FindByHash(hash string) (APIKey, error)
+	// FindByTenant returns the keys of a tenant, including the revoked ones
+	FindByTenant(tenantID uint64) ([]APIKey, error)
+	// Revoke marks the key as revoked at time at, or returns an error caused by ErrNotFound
+	Revoke(id uint64, at time.Time) error
+}
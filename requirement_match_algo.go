@@ -1,7 +1,9 @@
 package main
 
 import (
+	"math"
 	"sort"
+	"time"
 )
 
 type Score struct {
@@ -11,7 +13,22 @@ type Score struct {
 	BudgetScore   float32
 	BedroomScore  float32
 	BathroomScore float32
+	// FreshnessScore and AddedDate are only set by the V2 and V3 matching algorithms
+	FreshnessScore float32
+	AddedDate      time.Time
+	// AreaScore and FeaturesScore are only set by the V3 matching algorithms
+	AreaScore     float32
+	FeaturesScore float32
 	Total         float32
+
+	// human readable reasons of each of the component scores above
+	DistanceReason  string
+	BudgetReason    string
+	BedroomReason   string
+	BathroomReason  string
+	FreshnessReason string
+	AreaReason      string
+	FeaturesReason  string
 }
 
 func NewScore(index int, distance float32) Score {
@@ -23,57 +40,194 @@ func NewScore(index int, distance float32) Score {
 
 type MatchedProperty struct {
 	Property
-	MatchScore float32
+	// Distance is in miles like the reasons of the breakdown, until the match is converted to the
+	// units of the request with In
+	Distance   float32        `json:"distance"`
+	MatchScore float32        `json:"match_score"`
+	Breakdown  ScoreBreakdown `json:"breakdown"`
+	// Rates are the exchange rates the price and the budgets were compared with
+	Rates RateSnapshot `json:"rates"`
+	// fullScoreRadius is the radius in miles the distance was scored with, which the reason of the
+	// distance score is rebuilt with in other units
+	fullScoreRadius float32
 }
 
-func NewMatchedProperty(p Property, score float32) MatchedProperty {
+func NewMatchedProperty(p Property, distance, fullScoreRadius, score float32, breakdown ScoreBreakdown) MatchedProperty {
 	return MatchedProperty{
-		Property:   p,
-		MatchScore: score,
+		Property:        p,
+		Distance:        distance,
+		MatchScore:      score,
+		Breakdown:       breakdown,
+		fullScoreRadius: fullScoreRadius,
 	}
 }
 
 type ReqMatchingAlgo interface {
-	Match(PropRequirement, []PropWithDistance, ReqMargins) []MatchedProperty
+	Match(PropRequirement, []PropWithDistance, ReqMargins, MatchPolicy) []MatchedProperty
 }
 
+// ReqMatchAlgoV1 scores the candidate properties on distance, budget, bedrooms and bathrooms
+// using the bands, weights and minimum score of the MatchPolicy it is given, or the custom
+// weights and radii of the requirement if it has some
 type ReqMatchAlgoV1 struct{}
 
 func NewReqMatchingAlgo() ReqMatchAlgoV1 {
 	return ReqMatchAlgoV1{}
 }
 
-func (a ReqMatchAlgoV1) Match(p PropRequirement, properties []PropWithDistance, rMargins ReqMargins) []MatchedProperty {
-	matchedProps := []MatchedProperty{}
-	scoring := make(chan bool)
-	defer close(scoring)
+func (a ReqMatchAlgoV1) Match(p PropRequirement, properties []PropWithDistance, rMargins ReqMargins, policy MatchPolicy) []MatchedProperty {
+	return scoreProps(a, p, properties, rMargins, policy)
+}
+
+func (a ReqMatchAlgoV1) components(p PropRequirement, properties []PropWithDistance, rMargins ReqMargins, policy MatchPolicy) []scoreComponent {
+	return []scoreComponent{
+		func(scores []Score) { a.distanceMatching(policy, p.Latitude, p.Longitude, scores) },
+		func(scores []Score) { a.budgetMatching(policy, p.MinBudget, p.MaxBudget, properties, scores, rMargins) },
+		func(scores []Score) {
+			a.bedroomsMatching(policy, p.MinBedrooms, p.MaxBedrooms, properties, scores, rMargins)
+		},
+		func(scores []Score) {
+			a.bathroomsMatching(policy, p.MinBathrooms, p.MaxBathrooms, properties, scores, rMargins)
+		},
+	}
+}
 
-	scores := a.createPropScores(properties)
+func (a ReqMatchAlgoV1) breakdown(s Score, w MatchWeights) ScoreBreakdown {
+	return NewScoreBreakdown(s, w)
+}
 
-	// Run the 4 mathching tasks in goroutines to run them concurrently
-	go a.distanceMatching(p.Latitude, p.Longitude, scores, scoring)
-	go a.budgetMatching(p.MinBudget, p.MaxBudget, properties, scores, rMargins, scoring)
-	go a.bedroomsMatching(p.MinBedrooms, p.MaxBedrooms, properties, scores, rMargins, scoring)
-	go a.bathroomsMatching(p.MinBathrooms, p.MaxBathrooms, properties, scores, rMargins, scoring)
+// ReqMatchAlgoV2 scores the candidate properties like ReqMatchAlgoV1 and adds the freshness of
+// the listings, decaying with their age by the FreshnessHalfLife of the MatchPolicy, so that the
+// newer listings rank higher. Candidates scoring the same are ranked newer first.
+type ReqMatchAlgoV2 struct {
+	ReqMatchAlgoV1
+}
 
-	// read from scoring channel, and wait and finish as soon as 4 of the goroutines finishes
-	for i := 0; i < 4; i++ {
-		<-scoring
+func NewReqMatchingAlgoV2() ReqMatchAlgoV2 {
+	return ReqMatchAlgoV2{}
+}
+
+func (a ReqMatchAlgoV2) Match(p PropRequirement, properties []PropWithDistance, rMargins ReqMargins, policy MatchPolicy) []MatchedProperty {
+	return scoreProps(a, p, properties, rMargins, policy)
+}
+
+func (a ReqMatchAlgoV2) components(p PropRequirement, properties []PropWithDistance, rMargins ReqMargins, policy MatchPolicy) []scoreComponent {
+	now := time.Now()
+	return append(a.ReqMatchAlgoV1.components(p, properties, rMargins, policy),
+		func(scores []Score) { a.freshnessMatching(policy, now, properties, scores) },
+	)
+}
+
+func (a ReqMatchAlgoV2) breakdown(s Score, w MatchWeights) ScoreBreakdown {
+	return NewFreshScoreBreakdown(s, w)
+}
+
+func (a ReqMatchAlgoV2) freshnessMatching(policy MatchPolicy, now time.Time, p []PropWithDistance, scores []Score) {
+	for i, _ := range scores {
+		age := now.Sub(p[i].AddedDate)
+		scores[i].AddedDate = p[i].AddedDate
+		scores[i].FreshnessScore = GetFreshnessScore(age, policy.FreshnessHalfLife, policy.Weights.Freshness)
+		scores[i].FreshnessReason = FreshnessReason(age, "listed")
 	}
+}
 
-	// Score have been added, now final step, sort them
-	SortScores(scores)
+// propScorer is a version of the requirement matching algorithm, ie: the components it scores the
+// candidate properties on and the breakdown of their scores
+type propScorer interface {
+	components(p PropRequirement, properties []PropWithDistance, rMargins ReqMargins, policy MatchPolicy) []scoreComponent
+	breakdown(s Score, w MatchWeights) ScoreBreakdown
+}
+
+// scoreProps is the Match of every version of the requirement matching algorithm. It drops the
+// candidates which can't be a match, scores the other ones on the components of the version and
+// returns the ones reaching the minimum score of the policy, best first.
+func scoreProps(a propScorer, p PropRequirement, properties []PropWithDistance, rMargins ReqMargins, policy MatchPolicy) []MatchedProperty {
+	matchedProps := []MatchedProperty{}
+
+	// the requirement is scored with its own weights and radii when it has some
+	policy.Weights = policy.WeightsFor(p.Weights)
+	policy.SearchRadius, policy.FullScoreRadius = policy.RadiiFor(p.SearchRadius, p.FullScoreRadius)
+	properties = excludeOwnProps(p.OwnerID, properties)
+	properties = excludeViolatingProps(p, properties)
+	scores := createPropScores(properties)
+
+	for _, s := range scoreCandidates(scores, a.components(p, properties, rMargins, policy), policy.MinScore) {
+		breakdown := a.breakdown(s, policy.Weights)
+		matchedProps = append(matchedProps, NewMatchedProperty(properties[s.Index].Property, s.Distance, policy.FullScoreRadius, s.Total, breakdown))
+	}
+	return matchedProps
+}
+
+// ReqMatchAlgoV3 scores the candidate properties like ReqMatchAlgoV2 and adds their area and their
+// features, ie: how well the attributes of the listings meet the asks of the requirement
+type ReqMatchAlgoV3 struct {
+	ReqMatchAlgoV2
+}
+
+func NewReqMatchingAlgoV3() ReqMatchAlgoV3 {
+	return ReqMatchAlgoV3{}
+}
+
+func (a ReqMatchAlgoV3) Match(p PropRequirement, properties []PropWithDistance, rMargins ReqMargins, policy MatchPolicy) []MatchedProperty {
+	return scoreProps(a, p, properties, rMargins, policy)
+}
+
+func (a ReqMatchAlgoV3) components(p PropRequirement, properties []PropWithDistance, rMargins ReqMargins, policy MatchPolicy) []scoreComponent {
+	return append(a.ReqMatchAlgoV2.components(p, properties, rMargins, policy),
+		func(scores []Score) { a.areaMatching(policy, p.MinArea, p.MaxArea, properties, scores) },
+		func(scores []Score) { a.featuresMatching(policy, p.RequirementAttributes, properties, scores) },
+	)
+}
+
+func (a ReqMatchAlgoV3) breakdown(s Score, w MatchWeights) ScoreBreakdown {
+	return NewAttributeScoreBreakdown(s, w)
+}
+
+func (a ReqMatchAlgoV3) areaMatching(policy MatchPolicy, minArea, maxArea float32, p []PropWithDistance, scores []Score) {
+	for i, _ := range scores {
+		scores[i].AreaScore = GetAreaScore(minArea, maxArea, p[i].Area, policy.AreaMargin, policy.Weights.Area)
+		scores[i].AreaReason = AreaReason(minArea, maxArea, p[i].Area)
+	}
+}
 
+func (a ReqMatchAlgoV3) featuresMatching(policy MatchPolicy, r RequirementAttributes, p []PropWithDistance, scores []Score) {
 	for i, _ := range scores {
-		if scores[i].Total < 40.0 {
-			continue
+		scores[i].FeaturesScore = GetFeaturesScore(r, p[i].PropertyAttributes, policy.Weights.Features)
+		scores[i].FeaturesReason = FeaturesReason(r, p[i].PropertyAttributes)
+	}
+}
+
+// excludeOwnProps drops the properties of the owner of the requirement, as nobody wants their own
+// listings suggested to them
+func excludeOwnProps(ownerID uint64, properties []PropWithDistance) []PropWithDistance {
+	if ownerID == 0 {
+		return properties
+	}
+	others := make([]PropWithDistance, 0, len(properties))
+	for _, p := range properties {
+		if p.OwnerID != ownerID {
+			others = append(others, p)
 		}
-		matchedProps = append(matchedProps, NewMatchedProperty(properties[scores[i].Index].Property, scores[i].Total))
 	}
-	return matchedProps
+	return others
 }
 
-func (a ReqMatchAlgoV1) createPropScores(p []PropWithDistance) []Score {
+// excludeViolatingProps drops the properties out of the bounds of the hard constraints of the
+// requirement, they are never a match however well they score on the other fields
+func excludeViolatingProps(p PropRequirement, properties []PropWithDistance) []PropWithDistance {
+	if p.HardConstraints == 0 {
+		return properties
+	}
+	kept := make([]PropWithDistance, 0, len(properties))
+	for _, prop := range properties {
+		if MeetsHardConstraints(p, prop.BasePrice, prop.Bedrooms, prop.Bathrooms, prop.Area) {
+			kept = append(kept, prop)
+		}
+	}
+	return kept
+}
+
+func createPropScores(p []PropWithDistance) []Score {
 	scores := make([]Score, len(p))
 	for i, _ := range p {
 		scores[i] = NewScore(i, p[i].Distance)
@@ -81,114 +235,222 @@ func (a ReqMatchAlgoV1) createPropScores(p []PropWithDistance) []Score {
 	return scores
 }
 
-func (a ReqMatchAlgoV1) distanceMatching(lat, lon float32, scores []Score, scoring chan bool) {
+func (a ReqMatchAlgoV1) distanceMatching(policy MatchPolicy, lat, lon float32, scores []Score) {
 	// base distance and maxDistance in miles
-	baseDistance := float32(2)
-	maxDistance := float32(10)
+	baseDistance := policy.FullScoreRadius
+	maxDistance := policy.SearchRadius
 
 	for i, _ := range scores {
-		scores[i].DistanceScore = GetDistanceScore(scores[i].Distance, baseDistance, maxDistance)
+		scores[i].DistanceScore = GetDistanceScore(scores[i].Distance, baseDistance, maxDistance, policy.Weights.Distance)
+		scores[i].DistanceReason = DistanceReason(scores[i].Distance, baseDistance, Miles)
 	}
-	scoring <- true
 }
 
-func (a ReqMatchAlgoV1) budgetMatching(minBudget, maxBudget float32, p []PropWithDistance, scores []Score, rMargins ReqMargins, scoring chan bool) {
+func (a ReqMatchAlgoV1) budgetMatching(policy MatchPolicy, minBudget, maxBudget Money, p []PropWithDistance, scores []Score, rMargins ReqMargins) {
 	for i, _ := range scores {
-		scores[i].BudgetScore = GetBudgetScore(minBudget, maxBudget, p[i].Price, rMargins.MinPrice, rMargins.MaxPrice)
+		scores[i].BudgetScore = GetBudgetScore(minBudget, maxBudget, p[i].BasePrice, rMargins.MinPrice, rMargins.MaxPrice, policy.BudgetBand, policy.Weights.Budget)
+		scores[i].BudgetReason = BudgetReason(minBudget, maxBudget, p[i].BasePrice, policy.BudgetBand)
 	}
-	scoring <- true
 }
 
-func (a ReqMatchAlgoV1) bedroomsMatching(minBedrooms, maxBedrooms uint16, p []PropWithDistance, scores []Score, rMargins ReqMargins, scoring chan bool) {
+func (a ReqMatchAlgoV1) bedroomsMatching(policy MatchPolicy, minBedrooms, maxBedrooms uint16, p []PropWithDistance, scores []Score, rMargins ReqMargins) {
 	for i, _ := range scores {
-		scores[i].BedroomScore = GetBedroomScore(minBedrooms, maxBedrooms, p[i].Bedrooms, rMargins.MinBeds, rMargins.MaxBeds)
+		scores[i].BedroomScore = GetBedroomScore(minBedrooms, maxBedrooms, p[i].Bedrooms, rMargins.MinBeds, rMargins.MaxBeds, policy.Weights.Bedrooms)
+		scores[i].BedroomReason = RoomsReason(minBedrooms, maxBedrooms, p[i].Bedrooms, "bedroom")
 	}
-	scoring <- true
 }
 
-func (a ReqMatchAlgoV1) bathroomsMatching(minBathrooms, maxBathrooms uint16, p []PropWithDistance, scores []Score, rMargins ReqMargins, scoring chan bool) {
+func (a ReqMatchAlgoV1) bathroomsMatching(policy MatchPolicy, minBathrooms, maxBathrooms uint16, p []PropWithDistance, scores []Score, rMargins ReqMargins) {
 	for i, _ := range scores {
 		// since algor for bathrooms matching is similar to batrhooms matching, using the same GetBedroomScore function
-		scores[i].BathroomScore = GetBedroomScore(minBathrooms, maxBathrooms, p[i].Bathrooms, rMargins.MinBaths, rMargins.MaxBaths)
+		scores[i].BathroomScore = GetBedroomScore(minBathrooms, maxBathrooms, p[i].Bathrooms, rMargins.MinBaths, rMargins.MaxBaths, policy.Weights.Bathrooms)
+		scores[i].BathroomReason = RoomsReason(minBathrooms, maxBathrooms, p[i].Bathrooms, "bathroom")
 	}
-	scoring <- true
 }
 
-func GetDistanceScore(distance, baseDistance, maxDistance float32) float32 {
+// GetDistanceScore gives the full weight within baseDistance and distributes it linearly
+// down to 0 at maxDistance. A candidate further than maxDistance scores 0 instead of going negative.
+func GetDistanceScore(distance, baseDistance, maxDistance, weight float32) float32 {
 	if distance <= baseDistance {
-		return float32(30.0)
+		return weight
+	}
+	if distance >= maxDistance {
+		return 0
+	}
+	return ((maxDistance - distance) / (maxDistance - baseDistance)) * weight
+}
+
+// GetFreshnessScore gives the full weight to a candidate added just now and halves it every
+// halfLife days of its age, so that a candidate as old as halfLife gets half the weight
+func GetFreshnessScore(age time.Duration, halfLife, weight float32) float32 {
+	if age < 0 {
+		age = 0
+	}
+	days := age.Hours() / 24
+	return float32(math.Pow(0.5, days/float64(halfLife))) * weight
+}
+
+// GetAreaScore gives the full weight to areas within the [minArea, maxArea] range, either of which
+// can be missing (0), and distributes it linearly down to the bounds widened by the margin fraction.
+// A requirement without area asks gives the full weight and an unknown area (0) none.
+func GetAreaScore(minArea, maxArea, area, margin, weight float32) float32 {
+	if minArea == 0 && maxArea == 0 {
+		return weight
+	}
+	if area == 0 {
+		return 0
+	}
+	if minArea > 0 && area < minArea {
+		lo := minArea - (margin * minArea)
+		return MaxF((area-lo)/(minArea-lo), 0) * weight
+	}
+	if maxArea > 0 && area > maxArea {
+		hi := maxArea + (margin * maxArea)
+		return MaxF((hi-area)/(hi-maxArea), 0) * weight
+	}
+	return weight
+}
+
+// GetFeaturesScore gives the weight in proportion to the wanted features a listing has, see
+// wantedFeatures. A requirement wanting no features gives the full weight.
+func GetFeaturesScore(r RequirementAttributes, p PropertyAttributes, weight float32) float32 {
+	wanted, missing := wantedFeatures(r, p)
+	if wanted == 0 {
+		return weight
 	}
-	return ((maxDistance - distance) / (maxDistance - baseDistance)) * 30.0
+	return float32(wanted-len(missing)) / float32(wanted) * weight
 }
 
-func GetBudgetScore(minBudget, maxBudget, price, minPrice, maxPrice float32) float32 {
+// wantedFeatures returns the number of features wanted by a requirement, ie: its nice to have
+// amenities and its accepted property types and furnishings if any, along with the ones the listing
+// misses. The base filtering lets the listings of unknown type or furnishing through, it is only here
+// that they miss out against the listings known to be of an accepted one.
+func wantedFeatures(r RequirementAttributes, p PropertyAttributes) (int, []string) {
+	wanted := len(r.NiceToHaveAmenities.Names())
+	missing := (r.NiceToHaveAmenities &^ p.Amenities).Names()
+
+	if r.PropertyTypes != 0 {
+		wanted++
+		if !r.PropertyTypes.Has(p.PropertyType) {
+			missing = append(missing, "property type")
+		}
+	}
+	if r.Furnishings != 0 {
+		wanted++
+		if !r.Furnishings.Has(p.Furnishing) {
+			missing = append(missing, "furnishing")
+		}
+	}
+	return wanted, missing
+}
+
+// GetBudgetScore gives the full weight to prices within the budget range, or within +/- band
+// (a fraction, eg: 0.10) of the budget when only one of min or max budget is given
+func GetBudgetScore(minBudget, maxBudget, price, minPrice, maxPrice Money, band, weight float32) float32 {
 	// case 1: when both minBudget and maxBudther is given
 	if minBudget > 0 && maxBudget > 0 {
-		return budgetScoreUtil(minBudget, maxBudget, price, minPrice, maxPrice)
+		return budgetScoreUtil(minBudget, maxBudget, price, minPrice, maxPrice, weight)
 	}
 
-	var min10Budget, max10Budget float32
+	var minBandBudget, maxBandBudget Money
 	// case 2: only minBudget given
 	if minBudget > 0 {
-		min10Budget = MaxF((minBudget - (0.10 * minBudget)), 1.0)
-		max10Budget = MaxF((minBudget + (0.10 * minBudget)), 1.1)
+		minBandBudget = MaxMoney(minBudget.Scaled(-band), OneUnit)
+		maxBandBudget = MaxMoney(minBudget.Scaled(band), OneUnit.Scaled(band))
 	} else {
 		// case 3: only maxBudget given
-		min10Budget = MaxF((maxBudget - (0.10 * maxBudget)), 1.0)
-		max10Budget = MaxF((maxBudget + (0.10 * maxBudget)), 1.1)
+		minBandBudget = MaxMoney(maxBudget.Scaled(-band), OneUnit)
+		maxBandBudget = MaxMoney(maxBudget.Scaled(band), OneUnit.Scaled(band))
 	}
-	return budgetScoreUtil(min10Budget, max10Budget, price, minPrice, maxPrice)
+	return budgetScoreUtil(minBandBudget, maxBandBudget, price, minPrice, maxPrice, weight)
 }
 
-func budgetScoreUtil(minBudget, maxBudget, price, minPrice, maxPrice float32) float32 {
+func budgetScoreUtil(minBudget, maxBudget, price, minPrice, maxPrice Money, weight float32) float32 {
 	var weightage float32
 
 	if price >= minBudget && price <= maxBudget {
-		// price falls within budget range, 30 marks
+		// price falls within budget range, full marks
 		weightage = 1
 	} else if price < minBudget {
 		// price falls in the minPrice - minBudget range
-		weightage = (price - minPrice) / (minBudget - minPrice)
+		weightage = ratio(price-minPrice, minBudget-minPrice)
 	} else {
 		// price falls in the maxBudget - maxPrice range
-		weightage = (maxPrice - price) / (maxPrice - maxBudget)
+		weightage = ratio(maxPrice-price, maxPrice-maxBudget)
 	}
-	return weightage * 30
+	return weightage * weight
 }
 
-func GetBedroomScore(minBedrooms, maxBedrooms, bedrooms, minBeds, maxBeds uint16) float32 {
+// GetBedroomScore gives the full weight to bedrooms within the required range and distributes
+// it linearly down to the minBeds/maxBeds margins. It is used for the bathrooms as well.
+func GetBedroomScore(minBedrooms, maxBedrooms, bedrooms, minBeds, maxBeds uint16, weight float32) float32 {
 	// case 1: when both minBedrooms and maxBedrooms is given
 	if minBedrooms > 0 && maxBedrooms > 0 {
-		return bedroomScoreUtil(minBedrooms, maxBedrooms, bedrooms, minBeds, maxBeds)
+		return bedroomScoreUtil(minBedrooms, maxBedrooms, bedrooms, minBeds, maxBeds, weight)
 	}
 
 	// case 2: only minBedrooms given
 	if minBedrooms > 0 {
-		return bedroomScoreUtil(minBedrooms, minBedrooms, bedrooms, minBeds, maxBeds)
+		return bedroomScoreUtil(minBedrooms, minBedrooms, bedrooms, minBeds, maxBeds, weight)
 	} else {
 		// case 3: only maxBedrooms given
-		return bedroomScoreUtil(maxBedrooms, maxBedrooms, bedrooms, minBeds, maxBeds)
+		return bedroomScoreUtil(maxBedrooms, maxBedrooms, bedrooms, minBeds, maxBeds, weight)
 	}
 }
 
-func bedroomScoreUtil(minBedrooms, maxBedrooms, bedrooms, minBeds, maxBeds uint16) float32 {
+func bedroomScoreUtil(minBedrooms, maxBedrooms, bedrooms, minBeds, maxBeds uint16, weight float32) float32 {
 	var weightage float32
 
 	if bedrooms >= minBedrooms && bedrooms <= maxBedrooms {
-		// price falls within bedrooms range, 30 marks
+		// bedrooms falls within bedrooms range, full marks
 		weightage = 1
 	} else if bedrooms < minBedrooms {
-		// price falls in the minBeds - minBedrooms range
-		a := bedrooms - minBedrooms
-		b := Max((minBedrooms - minBeds), 1)
+		// bedrooms falls in the minBeds - minBedrooms range
+		a := SubSat(bedrooms, minBeds)
+		b := Max(SubSat(minBedrooms, minBeds), 1)
 		weightage = float32(a) / float32(b)
 	} else {
-		// price falls in the maxBedrooms - maxBeds range
-		c := maxBeds - bedrooms
-		d := maxBeds - maxBedrooms
+		// bedrooms falls in the maxBedrooms - maxBeds range
+		c := SubSat(maxBeds, bedrooms)
+		d := Max(SubSat(maxBeds, maxBedrooms), 1)
 		weightage = float32(c) / float32(d)
 	}
-	return weightage * 20
+	return weightage * weight
+}
+
+// scoreComponent fills one component of the scores of the candidates, eg: their distance scores
+// and reasons
+type scoreComponent func(scores []Score)
+
+// scoreCandidates runs the components concurrently on the scores of the candidates, then sorts the
+// scores and returns the ones reaching minScore. It is shared by all the matching algorithms, which
+// only differ by their components.
+func scoreCandidates(scores []Score, components []scoreComponent, minScore float32) []Score {
+	scoring := make(chan bool)
+	defer close(scoring)
+
+	// Run the mathching tasks in goroutines to run them concurrently
+	for _, c := range components {
+		go func(c scoreComponent) {
+			c(scores)
+			scoring <- true
+		}(c)
+	}
+	// read from scoring channel, and wait and finish as soon as all of the goroutines finishes
+	for range components {
+		<-scoring
+	}
+
+	// Score have been added, now final step, sort them
+	SortScores(scores)
+
+	kept := []Score{}
+	for i := range scores {
+		if scores[i].Total >= minScore {
+			kept = append(kept, scores[i])
+		}
+	}
+	return kept
 }
 
 func SortScores(s []Score) {
@@ -231,10 +493,11 @@ func SortScores(s []Score) {
 		} else if first.BathroomScore > second.BathroomScore {
 			return true
 		}
-		return true
+		// at last, the newer candidate first
+		return first.AddedDate.After(second.AddedDate)
 	})
 }
 
 func getTotalScore(s Score) float32 {
-	return s.DistanceScore + s.BudgetScore + s.BedroomScore + s.BathroomScore
+	return s.DistanceScore + s.BudgetScore + s.BedroomScore + s.BathroomScore + s.FreshnessScore + s.AreaScore + s.FeaturesScore
 }
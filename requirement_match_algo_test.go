@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+)
+
+// the budget tests use a 100000.00 budget, whose +/- 10% band is [90000.00, 110000.00] and +/- 25%
+// margin is [75000.00, 125000.00]
+const (
+	testBudget   Money = 10000000
+	testMinBand  Money = 9000000
+	testMaxBand  Money = 11000000
+	testMinPrice Money = 7500000
+	testMaxPrice Money = 12500000
+	testWeight         = 30
+)
+
+func TestGetBudgetScore(t *testing.T) {
+	tests := []struct {
+		name                 string
+		minBudget, maxBudget Money
+		price                Money
+		full                 bool
+	}{
+		{"range on min", testMinBand, testMaxBand, testMinBand, true},
+		{"range inside min", testMinBand, testMaxBand, testMinBand + 1, true},
+		{"range outside min", testMinBand, testMaxBand, testMinBand - 1, false},
+		{"range on max", testMinBand, testMaxBand, testMaxBand, true},
+		{"range inside max", testMinBand, testMaxBand, testMaxBand - 1, true},
+		{"range outside max", testMinBand, testMaxBand, testMaxBand + 1, false},
+		{"min budget on band min", testBudget, 0, testMinBand, true},
+		{"min budget inside band min", testBudget, 0, testMinBand + 1, true},
+		{"min budget outside band min", testBudget, 0, testMinBand - 1, false},
+		{"min budget on band max", testBudget, 0, testMaxBand, true},
+		{"min budget inside band max", testBudget, 0, testMaxBand - 1, true},
+		{"min budget outside band max", testBudget, 0, testMaxBand + 1, false},
+		{"max budget on band min", 0, testBudget, testMinBand, true},
+		{"max budget inside band min", 0, testBudget, testMinBand + 1, true},
+		{"max budget outside band min", 0, testBudget, testMinBand - 1, false},
+		{"max budget on band max", 0, testBudget, testMaxBand, true},
+		{"max budget inside band max", 0, testBudget, testMaxBand - 1, true},
+		{"max budget outside band max", 0, testBudget, testMaxBand + 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GetBudgetScore(tt.minBudget, tt.maxBudget, tt.price, testMinPrice, testMaxPrice, 0.10, testWeight)
+			if tt.full && got != testWeight {
+				t.Errorf("GetBudgetScore(%v, %v, %v) = %v, want %v", tt.minBudget, tt.maxBudget, tt.price, got, testWeight)
+			}
+			if !tt.full && (got <= 0 || got >= testWeight) {
+				t.Errorf("GetBudgetScore(%v, %v, %v) = %v, want in (0, %v)", tt.minBudget, tt.maxBudget, tt.price, got, testWeight)
+			}
+		})
+	}
+}
+
+func TestBudgetScoreUtil(t *testing.T) {
+	tests := []struct {
+		name  string
+		price Money
+		want  float32
+	}{
+		{"on min budget", testMinBand, testWeight},
+		{"inside min budget", testMinBand + 1, testWeight},
+		{"on max budget", testMaxBand, testWeight},
+		{"inside max budget", testMaxBand - 1, testWeight},
+		{"half way to min price", (testMinPrice + testMinBand) / 2, testWeight / 2},
+		{"half way to max price", (testMaxBand + testMaxPrice) / 2, testWeight / 2},
+		{"on min price", testMinPrice, 0},
+		{"on max price", testMaxPrice, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := budgetScoreUtil(testMinBand, testMaxBand, tt.price, testMinPrice, testMaxPrice, testWeight); got != tt.want {
+				t.Errorf("budgetScoreUtil(%v) = %v, want %v", tt.price, got, tt.want)
+			}
+		})
+	}
+
+	// one cent outside the budget scores less than the full weight, and one cent inside the margin
+	// more than nothing
+	for _, price := range []Money{testMinBand - 1, testMaxBand + 1, testMinPrice + 1, testMaxPrice - 1} {
+		if got := budgetScoreUtil(testMinBand, testMaxBand, price, testMinPrice, testMaxPrice, testWeight); got <= 0 || got >= testWeight {
+			t.Errorf("budgetScoreUtil(%v) = %v, want in (0, %v)", price, got, testWeight)
+		}
+	}
+}
+
+func TestGetDistanceScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		distance float32
+		want     float32
+	}{
+		{"at the coordinate", 0, testWeight},
+		{"on base distance", 2, testWeight},
+		{"half way to max distance", 6, testWeight / 2},
+		{"on max distance", 10, 0},
+		{"past max distance", 15, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetDistanceScore(tt.distance, 2, 10, testWeight); got != tt.want {
+				t.Errorf("GetDistanceScore(%v) = %v, want %v", tt.distance, got, tt.want)
+			}
+		})
+	}
+}
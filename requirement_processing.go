@@ -7,8 +7,8 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"time"
 
-	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 )
 
@@ -17,19 +17,74 @@ const (
 )
 
 type PropRequirement struct {
-	Latitude     float32
-	Longitude    float32
-	MinBudget    float32
-	MaxBudget    float32
-	MinBedrooms  uint16
-	MaxBedrooms  uint16
-	MinBathrooms uint16
-	MaxBathrooms uint16
+	TenantID uint64 `json:"-"` // set by the api from the authenticated tenant, never by the client
+	// Units are the units of the radii of the request and of the distances of its result, set by the api
+	Units        DistanceUnits `json:"-"`
+	OwnerID      uint64        `json:"owner_id"`
+	Latitude     float32       `json:"latitude"`
+	Longitude    float32       `json:"longitude"`
+	MinBudget    Money         `json:"min_budget"`
+	MaxBudget    Money         `json:"max_budget"`
+	MinBedrooms  uint16        `json:"min_bedrooms"`
+	MaxBedrooms  uint16        `json:"max_bedrooms"`
+	MinBathrooms uint16        `json:"min_bathrooms"`
+	MaxBathrooms uint16        `json:"max_bathrooms"`
+	// Currency is optional and is the ISO 4217 code of the budgets, the base currency otherwise
+	Currency string `json:"currency"`
+	// ExpiresAt is optional, the requirement expires a TTL after it is added otherwise
+	ExpiresAt *time.Time `json:"expires_at"`
+	// HardConstraints are optional, eg: ["budget"] to never match the listings above the max budget
+	HardConstraints ConstraintSet `json:"hard_constraints"`
+	// Weights are optional and must sum to 100, eg: more to bedrooms for a family, the weights of
+	// the match policy are used otherwise
+	Weights *MatchWeights `json:"weights"`
+	// SearchRadius is optional, eg: 30 miles for a rural buyer or 1 mile for a city one, and so is
+	// FullScoreRadius, which needs a SearchRadius
+	SearchRadius    float32 `json:"search_radius"`
+	FullScoreRadius float32 `json:"full_score_radius"`
+	// Polygons are optional, eg: two neighborhoods. The listings are then looked for within the search
+	// radius of the polygons instead of the coordinate, which is set to their center.
+	Polygons Polygons `json:"polygons"`
+	RequirementAttributes
+}
+
+// NewPropRequirementOf returns the requirement request a stored requirement was created from
+func NewPropRequirementOf(r Requirement) PropRequirement {
+	return PropRequirement{
+		TenantID:     r.TenantID,
+		OwnerID:      r.OwnerID,
+		Latitude:     r.Latitude,
+		Longitude:    r.Longitude,
+		MinBudget:    r.MinBudget,
+		MaxBudget:    r.MaxBudget,
+		Currency:     r.Currency,
+		MinBedrooms:  r.MinBedrooms,
+		MaxBedrooms:  r.MaxBedrooms,
+		MinBathrooms: r.MinBathrooms,
+		MaxBathrooms: r.MaxBathrooms,
+		ExpiresAt:    r.ExpiresAt,
+
+		HardConstraints:       r.HardConstraints,
+		Weights:               r.Weights,
+		SearchRadius:          r.SearchRadius,
+		FullScoreRadius:       r.FullScoreRadius,
+		Polygons:              r.Polygons,
+		RequirementAttributes: r.RequirementAttributes,
+	}
 }
 
 type PropWithDistance struct {
 	Property
 	Distance float32
+	// BasePrice is the price in the base currency the budgets are compared with
+	BasePrice Money
+}
+
+// RequirementMatchResult is the result of the usecases which store a requirement. It has the
+// requirement as stored, so that clients can later reference, update or delete it, and its matches.
+type RequirementMatchResult struct {
+	Requirement Requirement       `json:"requirement"`
+	Matches     []MatchedProperty `json:"matches"`
 }
 
 type ReqMargins struct {
@@ -37,15 +92,15 @@ type ReqMargins struct {
 	MaxLat   float32
 	MinLon   float32
 	MaxLon   float32
-	MinPrice float32
-	MaxPrice float32
+	MinPrice Money
+	MaxPrice Money
 	MinBeds  uint16
 	MaxBeds  uint16
 	MinBaths uint16
 	MaxBaths uint16
 }
 
-func NewReqMargins(minLat, maxLat, minLon, maxLon, minPrice, maxPrice float32, minBeds, maxBeds, minBaths, maxBaths uint16) ReqMargins {
+func NewReqMargins(minLat, maxLat, minLon, maxLon float32, minPrice, maxPrice Money, minBeds, maxBeds, minBaths, maxBaths uint16) ReqMargins {
 	return ReqMargins{
 		MinLat:   minLat,
 		MaxLat:   maxLat,
@@ -60,158 +115,423 @@ func NewReqMargins(minLat, maxLat, minLon, maxLon, minPrice, maxPrice float32, m
 	}
 }
 
+// ReqProcessor is the usecase interactor for a new requirement. It stores the requirement using
+// a RequirementRepository and finds the candidate properties using a PropertyRepository.
+// The base filtering margins come from the MatchPolicy of the tenant of the requirement and the
+// owners of the matched properties are told about the new requirement by its MatchNotifier.
+// The budgets and the prices are compared in the base currency of the rates of its RateProvider.
 type ReqProcessor struct {
-	DB             *gorm.DB
+	ReqRepo        RequirementRepository
+	PropRepo       PropertyRepository
+	OwnerRepo      OwnerRepository
+	TenantRepo     TenantRepository
 	MatchAlgorithm ReqMatchingAlgo
+	Policy         MatchPolicy
+	TTL            time.Duration
+	Notifier       MatchNotifier
+	Rates          RateProvider
 }
 
-func NewReqProcessor(db *gorm.DB, rAlgo ReqMatchingAlgo) ReqProcessor {
+func NewReqProcessor(reqRepo RequirementRepository, propRepo PropertyRepository, ownerRepo OwnerRepository, tenantRepo TenantRepository, rAlgo ReqMatchingAlgo, policy MatchPolicy, ttl time.Duration, notifier MatchNotifier, rates RateProvider) ReqProcessor {
 	return ReqProcessor{
-		DB:             db,
+		ReqRepo:        reqRepo,
+		PropRepo:       propRepo,
+		OwnerRepo:      ownerRepo,
+		TenantRepo:     tenantRepo,
 		MatchAlgorithm: rAlgo,
+		Policy:         policy,
+		TTL:            ttl,
+		Notifier:       notifier,
+		Rates:          rates,
 	}
 }
 
-func (rP ReqProcessor) GetMatchingProps(p PropRequirement) ([]MatchedProperty, error) {
+// GetMatchingProps usecase stores a new requirement and returns the stored requirement, with its
+// generated RequirementID and AddedDate, along with the properties matching it
+func (rP ReqProcessor) GetMatchingProps(p PropRequirement) (RequirementMatchResult, error) {
 	var err error
-	var candidateProps []PropWithDistance
-	var matchingProps []MatchedProperty
-	var rMargins ReqMargins
+	var result RequirementMatchResult
 
 	// step 0:  validate the Property Requirement Request
-	isValid := rP.validate(p)
-	if !isValid {
+	p = p.inMiles().withCurrency(rP.Rates.Base())
+	err = rP.validate(p)
+	if err != nil {
+		return result, errors.Wrap(err, "ReqProcessor couldn't validate")
+	}
+	p = centeredOnPolygons(p)
+
+	// step 1 & 2: filter the candidate properties and run the matching algorithm on them, before
+	// storing the requirement so that its match events are stored in the same transaction
+	result.Matches, err = rP.matchProps(p)
+	if err != nil {
+		return result, err
+	}
+
+	// step 3: Add requirement to database along with its match events
+	result.Requirement, err = rP.addToDB(p, result.Matches)
+	if err != nil {
+		return result, errors.Wrap(err, "ReqProcessor couldn't addToDB")
+	}
+
+	// step 4: let the owners of the matched properties know about the new match
+	rP.Notifier.NotifyPropertyOwners(result.Requirement, result.Matches)
+	result.Requirement = result.Requirement.In(p.Units)
+	result.Matches = propMatchesIn(result.Matches, p.Units)
+	return result, nil
+}
+
+// SearchProps usecase is the match only (dry run) version of GetMatchingProps. It validates the
+// requirement and returns the matching properties without storing the requirement.
+func (rP ReqProcessor) SearchProps(p PropRequirement) ([]MatchedProperty, error) {
+	var matchingProps []MatchedProperty
+
+	p = p.inMiles().withCurrency(rP.Rates.Base())
+	err := rP.validate(p)
+	if err != nil {
 		return matchingProps, errors.Wrap(err, "ReqProcessor couldn't validate")
 	}
+	matchingProps, err = rP.matchProps(centeredOnPolygons(p))
+	return propMatchesIn(matchingProps, p.Units), err
+}
+
+// RematchRequirement usecase re-scores an already stored requirement against the current
+// properties, without storing anything, and returns their distances in the units
+func (rP ReqProcessor) RematchRequirement(tenantID, ownerID, id uint64, units DistanceUnits) ([]MatchedProperty, error) {
+	var matchingProps []MatchedProperty
+
+	req, err := rP.findRequirement(tenantID, ownerID, id)
+	if err != nil {
+		return matchingProps, errors.Wrap(err, "ReqProcessor couldn't find requirement")
+	}
+	matchingProps, err = rP.matchProps(NewPropRequirementOf(req))
+	return propMatchesIn(matchingProps, units), err
+}
+
+// UpdateRequirement usecase overwrites the stored requirement with the given values, keeping its
+// id and added date, and returns the properties matching the updated requirement
+func (rP ReqProcessor) UpdateRequirement(id uint64, p PropRequirement) (RequirementMatchResult, error) {
+	var result RequirementMatchResult
+
+	p = p.inMiles().withCurrency(rP.Rates.Base())
+	err := rP.validate(p)
+	if err != nil {
+		return result, errors.Wrap(err, "ReqProcessor couldn't validate")
+	}
+	p = centeredOnPolygons(p)
+
+	existing, err := rP.findRequirement(p.TenantID, p.OwnerID, id)
+	if err != nil {
+		return result, errors.Wrap(err, "ReqProcessor couldn't find requirement")
+	}
+	// the owner of a stored requirement never changes, neither does its tenant, and its status is
+	// only changed by SetRequirementStatus
+	p.OwnerID = existing.OwnerID
+
+	req := NewRequirement(p.TenantID, p.OwnerID, p.Latitude, p.Longitude, p.MinBudget, p.MaxBudget, p.MinBedrooms, p.MaxBedrooms, p.MinBathrooms, p.MaxBathrooms)
+	req.Currency = p.Currency
+	req.HardConstraints = p.HardConstraints
+	req.Weights = p.Weights
+	req.SearchRadius = p.SearchRadius
+	req.FullScoreRadius = p.FullScoreRadius
+	req.Polygons = p.Polygons
+	req.PolygonsRadius = p.Polygons.Radius(NewCoordinate(p.Latitude, p.Longitude))
+	req.RequirementAttributes = p.RequirementAttributes
+	req.RequirementID = existing.RequirementID
+	req.AddedDate = existing.AddedDate
+	req.Status = existing.Status
+	req.ExpiresAt = existing.ExpiresAt
+	if p.ExpiresAt != nil {
+		req.ExpiresAt = expiryOf(p.ExpiresAt, rP.TTL, time.Now())
+	}
+
+	// like a new requirement, the updated one is stored along with the match events of its matches
+	// and the owners of the matched properties are notified, unless it is no longer active
+	result.Matches, err = rP.matchProps(p)
+	if err != nil {
+		return result, err
+	}
+	events := []MatchEvent{}
+	if req.Status == StatusActive {
+		events = NewRequirementMatchEvents(*req, result.Matches, time.Now())
+	}
+	err = rP.ReqRepo.SaveWithEvents(req, events)
+	if err != nil {
+		log.Printf("ReqProcessor unable to update requirement: (req: %v, err: %v)", req, err)
+		return result, errors.Wrap(err, "ReqProcessor couldn't update requirement")
+	}
+
+	if req.Status == StatusActive {
+		rP.Notifier.NotifyPropertyOwners(*req, result.Matches)
+	}
+	result.Requirement = req.In(p.Units)
+	result.Matches = propMatchesIn(result.Matches, p.Units)
+	return result, nil
+}
+
+// DeleteRequirement usecase removes the stored requirement so that new properties no longer match it
+func (rP ReqProcessor) DeleteRequirement(tenantID, ownerID, id uint64) error {
+	_, err := rP.findRequirement(tenantID, ownerID, id)
+	if err == nil {
+		err = rP.ReqRepo.Delete(id)
+	}
+	if err != nil {
+		return errors.Wrap(err, "ReqProcessor couldn't delete requirement")
+	}
+	return nil
+}
+
+// SetRequirementStatus usecase changes the status of a stored requirement, eg: pauses it or marks
+// it as fulfilled, and returns it with its radii in the units. Only the active requirements are
+// matched against the new listings.
+func (rP ReqProcessor) SetRequirementStatus(tenantID, ownerID, id uint64, s StatusRequest, units DistanceUnits) (Requirement, error) {
+	err := s.validate()
+	if err != nil {
+		return Requirement{}, errors.Wrap(err, "ReqProcessor couldn't validate")
+	}
+
+	req, err := rP.findRequirement(tenantID, ownerID, id)
+	if err != nil {
+		return req, errors.Wrap(err, "ReqProcessor couldn't find requirement")
+	}
+	req.Status = s.Status
+	req.ExpiresAt = renewedExpiry(req.ExpiresAt, s, rP.TTL, time.Now())
 
-	// step 1: Add requirement to database
-	err = rP.addToDB(p)
+	err = rP.ReqRepo.Update(&req)
 	if err != nil {
-		return matchingProps, errors.Wrap(err, "ReqProcessor couldn't addToDB")
+		log.Printf("ReqProcessor unable to update requirement status: (req: %v, err: %v)", req, err)
+		return req, errors.Wrap(err, "ReqProcessor couldn't update requirement status")
+	}
+	return req.In(units), nil
+}
+
+// findRequirement returns the stored requirement if it belongs to the tenant and to the owner, as
+// if the requirements of the other tenants, or of the other owners, did not exist. The ownerID 0
+// is any owner of the tenant, ie: the request acts for the whole tenant.
+func (rP ReqProcessor) findRequirement(tenantID, ownerID, id uint64) (Requirement, error) {
+	req, err := rP.ReqRepo.FindByID(id)
+	if err != nil {
+		return req, err
+	}
+	if req.TenantID != tenantID || (ownerID != 0 && req.OwnerID != ownerID) {
+		return Requirement{}, errors.Wrapf(ErrNotFound, "requirement %d", id)
+	}
+	return req, nil
+}
+
+// matchProps runs the Base Filtering and the matching algorithm for an already validated requirement
+func (rP ReqProcessor) matchProps(p PropRequirement) ([]MatchedProperty, error) {
+	var matchingProps []MatchedProperty
+
+	policy, err := tenantPolicy(rP.TenantRepo, rP.Policy, p.TenantID)
+	if err != nil {
+		return matchingProps, errors.Wrap(err, "ReqProcessor couldn't get the match policy")
+	}
+
+	// the budgets are compared with the prices in the base currency, all of them converted with the
+	// same rates, which are recorded alongside each match
+	rates, err := rP.Rates.Snapshot()
+	if err != nil {
+		return matchingProps, errors.Wrap(err, "ReqProcessor couldn't get the exchange rates")
+	}
+	base, ok := p.inBase(rates)
+	if !ok {
+		return matchingProps, errors.Wrapf(ErrValidation, "ReqProcessor couldn't convert the budgets from %s to %s", p.Currency, rates.Base)
 	}
 
 	// step 2: Base Filtering - filter out a certain set of property listings first based on parameters which gives a set of possible candidate property listings
-	candidateProps, rMargins, err = rP.getCandidateProps(p)
+	candidateProps, rMargins, err := rP.getCandidateProps(base, policy, rates)
 	if err != nil {
 		return matchingProps, errors.Wrap(err, "ReqProcessor couldn't getCandidateProps")
 	}
 
 	// step 3: Run algorithm on candidate properties and get a result set of matching properties
-	matchingProps = rP.MatchAlgorithm.Match(p, candidateProps, rMargins)
+	matchingProps = rP.MatchAlgorithm.Match(base, candidateProps, rMargins, policy)
+	for i := range matchingProps {
+		matchingProps[i].Rates = rates.Of(p.Currency, matchingProps[i].Currency)
+	}
 	return matchingProps, nil
 }
 
-func (rP ReqProcessor) validate(p PropRequirement) bool {
+// centeredOnPolygons returns the requirement located at the center of its polygons, if it has some
+func centeredOnPolygons(p PropRequirement) PropRequirement {
+	if len(p.Polygons) > 0 {
+		center := p.Polygons.Center()
+		p.Latitude, p.Longitude = center.Latitude, center.Longitude
+	}
+	return p
+}
+
+// validate returns an error caused by ErrValidation describing the first bad field of the requirement
+func (rP ReqProcessor) validate(p PropRequirement) error {
 	if !validCoordinate(p.Latitude, p.Longitude) {
 		log.Printf("bad coordinate - lat: %f or lon: %f", p.Latitude, p.Longitude)
-		return false
+		return errors.Wrapf(ErrValidation, "bad coordinate - lat: %f or lon: %f", p.Latitude, p.Longitude)
 	}
 	if !validBudget(p.MinBudget, p.MaxBudget) {
-		log.Printf("bad budget range min: %f - max: %f", p.MinBudget, p.MaxBudget)
-		return false
+		log.Printf("bad budget range min: %v - max: %v", p.MinBudget, p.MaxBudget)
+		return errors.Wrapf(ErrValidation, "bad budget range min: %v - max: %v", p.MinBudget, p.MaxBudget)
+	}
+	if err := validCurrency(rP.Rates, p.Currency); err != nil {
+		log.Printf("bad currency: %v", err)
+		return err
 	}
 	if !validBedroomsRange(p.MinBedrooms, p.MaxBedrooms) {
 		log.Printf("bad bedrooms range min: %d - max: %d", p.MinBedrooms, p.MaxBedrooms)
-		return false
+		return errors.Wrapf(ErrValidation, "bad bedrooms range min: %d - max: %d", p.MinBedrooms, p.MaxBedrooms)
 	}
 	if !validBathroomsRange(p.MinBathrooms, p.MaxBathrooms) {
 		log.Printf("bad bathrooms range min: %d - max: %d", p.MinBathrooms, p.MaxBathrooms)
-		return false
+		return errors.Wrapf(ErrValidation, "bad bathrooms range min: %d - max: %d", p.MinBathrooms, p.MaxBathrooms)
+	}
+	if !validExpiresAt(p.ExpiresAt) {
+		log.Printf("bad expires_at: %s", p.ExpiresAt)
+		return errors.Wrapf(ErrValidation, "bad expires_at: %s, must be in the future", p.ExpiresAt)
 	}
-	return true
+	if err := validRequirementAttributes(p.RequirementAttributes); err != nil {
+		log.Printf("bad requirement attributes: %v", err)
+		return err
+	}
+	if err := validHardConstraints(p); err != nil {
+		log.Printf("bad hard constraints: %v", err)
+		return err
+	}
+	if !validSearchRadius(p.SearchRadius) {
+		log.Printf("bad search_radius: %f miles", p.SearchRadius)
+		return errors.Wrapf(ErrValidation, "bad search_radius: %g, must be in (0, %.3g] %s", p.Units.FromMiles(p.SearchRadius), p.Units.FromMiles(MaxSearchRadius), p.Units.Label())
+	}
+	if !validFullScoreRadius(p.SearchRadius, p.FullScoreRadius) {
+		log.Printf("bad full_score_radius: %f - search_radius: %f miles", p.FullScoreRadius, p.SearchRadius)
+		return errors.Wrapf(ErrValidation, "bad full_score_radius: %g, needs a search_radius and must be less than it", p.Units.FromMiles(p.FullScoreRadius))
+	}
+	if err := validPolygons(p.Polygons); err != nil {
+		log.Printf("bad polygons: %v", err)
+		return err
+	}
+	if p.Weights != nil {
+		if err := p.Weights.Validate(); err != nil {
+			log.Printf("bad weights: %v", err)
+			return errors.Wrapf(ErrValidation, "bad weights: %v", err)
+		}
+	}
+	return validOwner(rP.OwnerRepo, p.TenantID, p.OwnerID)
 }
 
-func (rP ReqProcessor) addToDB(p PropRequirement) error {
-	req := NewRequirement(p.Latitude, p.Longitude, p.MinBudget, p.MaxBudget, p.MinBedrooms, p.MaxBedrooms, p.MinBathrooms, p.MaxBathrooms)
-
-	err := rP.DB.Debug().Create(req).Error
+// addToDB stores a new requirement along with the match events of its matches and returns it as
+// stored, ie: with its generated RequirementID
+func (rP ReqProcessor) addToDB(p PropRequirement, matches []MatchedProperty) (Requirement, error) {
+	req := NewRequirement(p.TenantID, p.OwnerID, p.Latitude, p.Longitude, p.MinBudget, p.MaxBudget, p.MinBedrooms, p.MaxBedrooms, p.MinBathrooms, p.MaxBathrooms)
+	req.Currency = p.Currency
+	req.HardConstraints = p.HardConstraints
+	req.Weights = p.Weights
+	req.SearchRadius = p.SearchRadius
+	req.FullScoreRadius = p.FullScoreRadius
+	req.Polygons = p.Polygons
+	req.PolygonsRadius = p.Polygons.Radius(NewCoordinate(p.Latitude, p.Longitude))
+	req.RequirementAttributes = p.RequirementAttributes
+	req.ExpiresAt = expiryOf(p.ExpiresAt, rP.TTL, req.AddedDate)
+	events := NewRequirementMatchEvents(*req, matches, req.AddedDate)
+
+	err := rP.ReqRepo.SaveWithEvents(req, events)
 	if err != nil {
 		log.Printf("ReqProcessor unable to insert requirement: (req: %v, err: %v)", req, err)
-		return errors.Wrap(err, fmt.Sprintf("ReqProcessor couldn't insert requirement: %v", req))
+		return *req, errors.Wrap(err, fmt.Sprintf("ReqProcessor couldn't insert requirement: %v", req))
 	}
-	return nil
+	return *req, nil
 }
 
-// createTransaction is a helper function which takes TransactionRequest object and returns pointer instance of domain.Transaction
-func (rP ReqProcessor) getCandidateProps(p PropRequirement) ([]PropWithDistance, ReqMargins, error) {
-	properties := []PropWithDistance{}
-	distanceRange := float32(10) // distance threshold in miles
-	rMargins := rP.getReqMargins(p, distanceRange)
-
-	queryString := rP.getQueryString()
+// getCandidateProps is the base filtering step which asks the property repository for the properties
+// falling inside the distance range and the budget, bedrooms, bathrooms and attribute margins of the requirement.
+// The distance range is the search radius of the requirement if it has one, around its polygons if it has some.
+// Only the properties of the tenant of the requirement and of the tenants sharing theirs with it are candidates.
+// The budgets of the requirement are in the base currency of the rates, which the prices are converted to.
+func (rP ReqProcessor) getCandidateProps(p PropRequirement, policy MatchPolicy, rates RateSnapshot) ([]PropWithDistance, ReqMargins, error) {
+	distanceRange, _ := policy.RadiiFor(p.SearchRadius, p.FullScoreRadius) // distance threshold in miles
+	// the polygons are all within their radius of the coordinate, so are the listings near them
+	queryRange := distanceRange + p.Polygons.Radius(NewCoordinate(p.Latitude, p.Longitude))
+	rMargins := rP.getReqMargins(policy, p, queryRange)
+	attributes := rP.getAttributeWindows(policy, p)
+
+	sharing, err := rP.TenantRepo.SharingTenants(p.TenantID)
+	if err != nil {
+		return nil, rMargins, errors.Wrap(err, "ReqProcessor couldn't find the sharing tenants")
+	}
+	tenants := append([]uint64{p.TenantID}, sharing...)
 
-	err := rP.DB.Debug().
-		Raw(queryString, p.Latitude, p.Latitude, p.Longitude, EarthRadius,
-			rMargins.MinLat, rMargins.MaxLat, rMargins.MinLon, rMargins.MaxLon,
-			distanceRange, rMargins.MinPrice, rMargins.MaxPrice, rMargins.MinBeds,
-			rMargins.MaxBeds, rMargins.MinBaths, rMargins.MaxBaths).
-		Scan(&properties).Error
+	properties, err := rP.PropRepo.FindCandidates(NewCandidateQuery(rMargins, attributes, NewCoordinate(p.Latitude, p.Longitude), queryRange, queryRange, tenants, rates))
 	if err != nil {
 		log.Printf("ReqProcessor couldn't getCandidateProps for: (requirement: %v, err: %v)", p, err)
 		return properties, rMargins, errors.Wrap(err, "ReqProcessor couldn't getCandidateProps")
 	}
+	properties = propsInBase(rates, properties)
+	if len(p.Polygons) > 0 {
+		properties = propsNearPolygons(p.Polygons, distanceRange, properties)
+	}
 
 	return properties, rMargins, nil
 }
 
-func (rP ReqProcessor) getQueryString() string {
-	selectBaseClause := "SELECT property_id, latitude, longitude, "
-	selectDistanceClause := "acos(sin(rs(latitude))*sin(rs(?)) + cos(rd(latitude))*cos(rs(?))*cos(rs(?) - rs(longitude)) ) * ? as distance, "
-	selectRestClause := "price, bedrooms, bathrooms "
-
-	fromClause := "FROM properties "
-
-	latCondition := "latitude BETWEEN ? AND ? "
-	lonCondition := "AND longitude BETWEEN ? AND ? "
-	distCondition := "AND distance <= ? "
-	priceCondition := "AND price BETWEEN ? AND ? "
-	bedsCondtion := "AND bedrooms BETWEEN ? AND ? "
-	bathsCondition := "AND bathrooms BETWEEN ? AND ?"
-
-	return selectBaseClause + selectDistanceClause + selectRestClause +
-		fromClause + "Where " + latCondition + lonCondition + distCondition +
-		priceCondition + bedsCondtion + bathsCondition
-}
-
-func (rP ReqProcessor) getReqMargins(p PropRequirement, distanceRange float32) ReqMargins {
+func (rP ReqProcessor) getReqMargins(policy MatchPolicy, p PropRequirement, distanceRange float32) ReqMargins {
 	minLat, maxLat := GetMinMaxLat(p.Latitude, distanceRange)
 	minLon, maxLon := GetMinMaxLon(p.Latitude, p.Longitude, distanceRange)
-	minPrice, maxPrice := rP.getMinMaxPrice(p.MinBudget, p.MaxBudget)
-	minBeds, maxBeds := rP.getMinMaxBedrooms(p.MinBedrooms, p.MaxBedrooms)
-	minBaths, maxBaths := rP.getMinMaxBathrooms(p.MinBathrooms, p.MaxBathrooms)
+	minPrice, maxPrice := rP.getMinMaxPrice(policy, p.MinBudget, p.MaxBudget)
+	minBeds, maxBeds := rP.getMinMaxBedrooms(policy, p.MinBedrooms, p.MaxBedrooms)
+	minBaths, maxBaths := rP.getMinMaxBathrooms(policy, p.MinBathrooms, p.MaxBathrooms)
+
+	// the hard fields leave no margin outside of their bounds
+	if p.HardConstraints.Has(ConstraintBudget) {
+		minPrice, maxPrice = hardWindowMoney(minPrice, maxPrice, p.MinBudget, p.MaxBudget)
+	}
+	if p.HardConstraints.Has(ConstraintBedrooms) {
+		minBeds, maxBeds = hardWindow(minBeds, maxBeds, p.MinBedrooms, p.MaxBedrooms)
+	}
+	if p.HardConstraints.Has(ConstraintBathrooms) {
+		minBaths, maxBaths = hardWindow(minBaths, maxBaths, p.MinBathrooms, p.MaxBathrooms)
+	}
 
 	return NewReqMargins(minLat, maxLat, minLon, maxLon, minPrice, maxPrice, minBeds, maxBeds, minBaths, maxBaths)
 }
 
-func (rP ReqProcessor) getMinMaxPrice(minBudget, maxBudget float32) (float32, float32) {
+// getAttributeWindows returns the attribute windows of the requirement, the area range having no
+// margin when the area is a hard constraint
+func (rP ReqProcessor) getAttributeWindows(policy MatchPolicy, p PropRequirement) AttributeWindows {
+	margin := policy.AreaMargin
+	if p.HardConstraints.Has(ConstraintArea) {
+		margin = 0
+	}
+	return RequirementAttributeWindows(p.RequirementAttributes, margin)
+}
+
+func (rP ReqProcessor) getMinMaxPrice(policy MatchPolicy, minBudget, maxBudget Money) (Money, Money) {
+	margin := policy.PriceMargin
 	if minBudget > 0 && maxBudget > 0 {
 		// if both minBudet and maxBudget given
-		return MaxF((minBudget - (0.25 * minBudget)), 1.0), MaxF((maxBudget + (0.25 * maxBudget)), 1.25)
+		return MaxMoney(minBudget.Scaled(-margin), OneUnit), MaxMoney(maxBudget.Scaled(margin), OneUnit.Scaled(margin))
 	}
 	if minBudget > 0 {
 		// if only minBudget given
-		return MaxF((minBudget - (0.25 * minBudget)), 1.0), MaxF((minBudget + (0.25 * minBudget)), 1.25)
+		return MaxMoney(minBudget.Scaled(-margin), OneUnit), MaxMoney(minBudget.Scaled(margin), OneUnit.Scaled(margin))
 	}
 	// if only maxBudget given
-	return MaxF((maxBudget - (0.25 * maxBudget)), 1.0), MaxF((maxBudget + (0.25 * maxBudget)), 1.25)
+	return MaxMoney(maxBudget.Scaled(-margin), OneUnit), MaxMoney(maxBudget.Scaled(margin), OneUnit.Scaled(margin))
 }
 
-func (rP ReqProcessor) getMinMaxBedrooms(minBeds, maxBeds uint16) (uint16, uint16) {
+func (rP ReqProcessor) getMinMaxBedrooms(policy MatchPolicy, minBeds, maxBeds uint16) (uint16, uint16) {
+	margin := policy.RoomsMargin
 	if minBeds > 0 && maxBeds > 0 {
 		// if both maxBeds and maxBeds given
-		return Max(minBeds-2, 1), Max(maxBeds+2, 3)
+		return Max(SubSat(minBeds, margin), 1), Max(maxBeds+margin, 1+margin)
 	}
 	if minBeds > 0 {
 		// if only minBeds given
-		return Max(minBeds-2, 1), Max(minBeds+2, 3)
+		return Max(SubSat(minBeds, margin), 1), Max(minBeds+margin, 1+margin)
 	}
 	// if only maxBeds given
-	return Max(maxBeds-2, 1), Max(maxBeds+2, 3)
+	return Max(SubSat(maxBeds, margin), 1), Max(maxBeds+margin, 1+margin)
 }
 
-func (rP ReqProcessor) getMinMaxBathrooms(minBaths, maxBaths uint16) (uint16, uint16) {
+func (rP ReqProcessor) getMinMaxBathrooms(policy MatchPolicy, minBaths, maxBaths uint16) (uint16, uint16) {
 	// using hte smae MinMaxBedrooms functiion as it has the same functionality
-	return rP.getMinMaxBedrooms(minBaths, maxBaths)
+	return rP.getMinMaxBedrooms(policy, minBaths, maxBaths)
 }
 
 //////////////////////////////////////////////////////////
@@ -219,15 +539,27 @@ func (rP ReqProcessor) getMinMaxBathrooms(minBaths, maxBaths uint16) (uint16, ui
 //////////////////////////////////////////////////////////
 
 func GetMinMaxLat(lat, distanceRange float32) (float32, float32) {
-	degree := RadToDeg(float64(EarthRadius / distanceRange))
+	degree := RadToDeg(float64(distanceRange / EarthRadius))
 	return lat - float32(degree), lat + float32(degree)
 }
 
 func GetMinMaxLon(lat, lon, distanceRange float32) (float32, float32) {
-	degree := RadToDeg(math.Asin(float64(EarthRadius/distanceRange)) / math.Cos(DegToRad(float64(lat))))
+	degree := RadToDeg(math.Asin(float64(distanceRange/EarthRadius)) / math.Cos(DegToRad(float64(lat))))
 	return lon - float32(degree), lon + float32(degree)
 }
 
+// GreatCircleDistance returns the distance in miles between two coordinates using the haversine
+// formula, which unlike the spherical law of cosines stays accurate for very small distances
+func GreatCircleDistance(from, to Coordinate) float32 {
+	lat1 := DegToRad(float64(from.Latitude))
+	lat2 := DegToRad(float64(to.Latitude))
+	dLat := lat2 - lat1
+	dLon := DegToRad(float64(to.Longitude - from.Longitude))
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return float32(2 * math.Asin(math.Min(1, math.Sqrt(h))) * float64(EarthRadius))
+}
+
 func MaxF(x, y float32) float32 {
 	if x >= y {
 		return x
@@ -235,6 +567,13 @@ func MaxF(x, y float32) float32 {
 	return y
 }
 
+func MinF(x, y float32) float32 {
+	if x <= y {
+		return x
+	}
+	return y
+}
+
 func Max(x, y uint16) uint16 {
 	if x >= y {
 		return x
@@ -242,6 +581,14 @@ func Max(x, y uint16) uint16 {
 	return y
 }
 
+// SubSat subtracts y from x stopping at 0, as a plain uint16 subtraction would wrap around
+func SubSat(x, y uint16) uint16 {
+	if y >= x {
+		return 0
+	}
+	return x - y
+}
+
 func DegToRad(d float64) float64 {
 	return d * math.Pi / 180
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReqProcessorGetMinMaxPrice(t *testing.T) {
+	policy := DefaultMatchPolicy()
+	tests := []struct {
+		name                 string
+		minBudget, maxBudget Money
+		wantMin, wantMax     Money
+	}{
+		{"both budgets", testMinBand, testMaxBand, 6750000, 13750000},
+		{"min budget", testBudget, 0, testMinPrice, testMaxPrice},
+		{"max budget", 0, testBudget, testMinPrice, testMaxPrice},
+		{"rounded margin", 12345, 0, 9259, 15431},
+		{"floored at one unit", 0, 1, OneUnit, OneUnit.Scaled(policy.PriceMargin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMin, gotMax := ReqProcessor{}.getMinMaxPrice(policy, tt.minBudget, tt.maxBudget)
+			if gotMin != tt.wantMin || gotMax != tt.wantMax {
+				t.Errorf("getMinMaxPrice(%v, %v) = (%v, %v), want (%v, %v)", tt.minBudget, tt.maxBudget, gotMin, gotMax, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// SQLitePropertyRepo implements PropertyRepository on top of a sqlite file database, mostly meant
+// for running the matcher locally. sqlite has no trigonometric sql functions, so only the bounding
+// box and the margins are filtered in the query and the distance is calculated and filtered in go.
+type SQLitePropertyRepo struct {
+	gormPropertyStore
+}
+
+func NewSQLitePropertyRepo(db *gorm.DB) SQLitePropertyRepo {
+	return SQLitePropertyRepo{
+		gormPropertyStore: gormPropertyStore{DB: db},
+	}
+}
+
+func (repo SQLitePropertyRepo) FindCandidates(q CandidateQuery) ([]PropWithDistance, error) {
+	properties := []PropWithDistance{}
+
+	rows := []Property{}
+	condition, args := propCandidates(q)
+	err := repo.DB.Where(condition, args...).Find(&rows).Error
+	if err != nil {
+		log.Printf("SQLitePropertyRepo couldn't find candidates for: (query: %v, err: %v)", q, err)
+		return properties, errors.Wrap(err, "SQLitePropertyRepo couldn't find candidates")
+	}
+
+	for i := range rows {
+		distance := GreatCircleDistance(q.Center, NewCoordinate(rows[i].Latitude, rows[i].Longitude))
+		if distance > q.Radius {
+			continue
+		}
+		properties = append(properties, PropWithDistance{Property: rows[i], Distance: distance})
+	}
+	return properties, nil
+}
+
+// SQLiteRequirementRepo implements RequirementRepository on top of a sqlite file database
+type SQLiteRequirementRepo struct {
+	gormRequirementStore
+}
+
+func NewSQLiteRequirementRepo(db *gorm.DB) SQLiteRequirementRepo {
+	return SQLiteRequirementRepo{
+		gormRequirementStore: gormRequirementStore{DB: db},
+	}
+}
+
+func (repo SQLiteRequirementRepo) FindCandidates(q CandidateQuery) ([]ReqWithDistance, error) {
+	requirements := []ReqWithDistance{}
+
+	rows := []Requirement{}
+	condition, args := reqCandidates(q)
+	err := repo.DB.Where(condition, args...).Find(&rows).Error
+	if err != nil {
+		log.Printf("SQLiteRequirementRepo couldn't find candidates for: (query: %v, err: %v)", q, err)
+		return requirements, errors.Wrap(err, "SQLiteRequirementRepo couldn't find candidates")
+	}
+
+	for i := range rows {
+		distance := GreatCircleDistance(q.Center, NewCoordinate(rows[i].Latitude, rows[i].Longitude))
+		if distance > q.RadiusOf(rows[i]) {
+			continue
+		}
+		requirements = append(requirements, ReqWithDistance{Requirement: rows[i], Distance: distance})
+	}
+	return requirements, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+// TenantRequest is the DTO used to register a new brokerage. Policy is an optional JSON match
+// policy whose fields override the ones of the deployment policy for the tenant.
+type TenantRequest struct {
+	Name   string `json:"name"`
+	Policy string `json:"policy"`
+}
+
+// TenantProcessor is the usecase interactor for the tenants, their match policies and their
+// sharing agreements
+type TenantProcessor struct {
+	TenantRepo TenantRepository
+	Policy     MatchPolicy
+}
+
+func NewTenantProcessor(tenantRepo TenantRepository, policy MatchPolicy) TenantProcessor {
+	return TenantProcessor{
+		TenantRepo: tenantRepo,
+		Policy:     policy,
+	}
+}
+
+// CreateTenant usecase registers a new tenant and returns it with its generated TenantID
+func (tP TenantProcessor) CreateTenant(t TenantRequest) (Tenant, error) {
+	err := tP.validate(t)
+	if err != nil {
+		return Tenant{}, errors.Wrap(err, "TenantProcessor couldn't validate")
+	}
+
+	tenant := NewTenant(t.Name, t.Policy)
+	err = tP.TenantRepo.Save(tenant)
+	if err != nil {
+		log.Printf("TenantProcessor unable to insert tenant: (tenant: %v, err: %v)", tenant, err)
+		return *tenant, errors.Wrap(err, "TenantProcessor couldn't insert tenant")
+	}
+	return *tenant, nil
+}
+
+// GetTenant usecase returns a stored tenant
+func (tP TenantProcessor) GetTenant(id uint64) (Tenant, error) {
+	tenant, err := tP.TenantRepo.FindByID(id)
+	if err != nil {
+		return tenant, errors.Wrap(err, "TenantProcessor couldn't find tenant")
+	}
+	return tenant, nil
+}
+
+// UpdatePolicy usecase replaces the match policy overrides of a tenant, an empty policy makes the
+// tenant use the deployment policy again
+func (tP TenantProcessor) UpdatePolicy(id uint64, policy string) (Tenant, error) {
+	tenant, err := tP.GetTenant(id)
+	if err != nil {
+		return tenant, err
+	}
+	if err = tP.validPolicy(policy); err != nil {
+		return tenant, errors.Wrap(err, "TenantProcessor couldn't validate")
+	}
+
+	tenant.Policy = policy
+	err = tP.TenantRepo.Update(&tenant)
+	if err != nil {
+		log.Printf("TenantProcessor unable to update tenant: (tenant: %v, err: %v)", tenant, err)
+		return tenant, errors.Wrap(err, "TenantProcessor couldn't update tenant")
+	}
+	return tenant, nil
+}
+
+// Share usecase lets the requirements of the partner tenant match against the property listings
+// of the sharing tenant. The agreement is one way, the partner has to share its own listings back.
+func (tP TenantProcessor) Share(sharingTenantID, partnerTenantID uint64) (SharingAgreement, error) {
+	if sharingTenantID == partnerTenantID {
+		return SharingAgreement{}, errors.Wrap(ErrValidation, "a tenant can't share with itself")
+	}
+	if _, err := tP.GetTenant(sharingTenantID); err != nil {
+		return SharingAgreement{}, err
+	}
+	if err := validTenant(tP.TenantRepo, partnerTenantID); err != nil {
+		return SharingAgreement{}, errors.Wrap(err, "TenantProcessor couldn't validate")
+	}
+
+	agreement := NewSharingAgreement(sharingTenantID, partnerTenantID)
+	err := tP.TenantRepo.SaveAgreement(agreement)
+	if err != nil {
+		log.Printf("TenantProcessor unable to insert sharing agreement: (agreement: %v, err: %v)", agreement, err)
+		return *agreement, errors.Wrap(err, "TenantProcessor couldn't insert sharing agreement")
+	}
+	return *agreement, nil
+}
+
+// Unshare usecase ends the sharing agreement of the sharing tenant with the partner tenant
+func (tP TenantProcessor) Unshare(sharingTenantID, partnerTenantID uint64) error {
+	err := tP.TenantRepo.DeleteAgreement(sharingTenantID, partnerTenantID)
+	if err != nil {
+		return errors.Wrap(err, "TenantProcessor couldn't delete sharing agreement")
+	}
+	return nil
+}
+
+// ListPartners usecase returns the tenants the sharing tenant shares its property listings with
+func (tP TenantProcessor) ListPartners(sharingTenantID uint64) ([]uint64, error) {
+	if _, err := tP.GetTenant(sharingTenantID); err != nil {
+		return nil, err
+	}
+	partners, err := tP.TenantRepo.PartnerTenants(sharingTenantID)
+	if err != nil {
+		return partners, errors.Wrap(err, "TenantProcessor couldn't find partners")
+	}
+	return partners, nil
+}
+
+// validate returns an error caused by ErrValidation describing the first bad field of the tenant
+func (tP TenantProcessor) validate(t TenantRequest) error {
+	if t.Name == "" {
+		return errors.Wrap(ErrValidation, "tenant name is missing")
+	}
+	return tP.validPolicy(t.Policy)
+}
+
+// validPolicy makes sure the policy overrides give a valid policy once applied to the deployment policy
+func (tP TenantProcessor) validPolicy(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	if _, err := ParseMatchPolicy(tP.Policy, []byte(policy)); err != nil {
+		return errors.Wrapf(ErrValidation, "bad policy: %v", err)
+	}
+	return nil
+}
+
+// validTenant returns an error caused by ErrValidation when a record refers to an unknown tenant.
+// The TenantID 0 is the default tenant and always exists.
+func validTenant(repo TenantRepository, tenantID uint64) error {
+	if tenantID == 0 {
+		return nil
+	}
+	_, err := repo.FindByID(tenantID)
+	if errors.Cause(err) == ErrNotFound {
+		log.Printf("unknown tenant: %d", tenantID)
+		return errors.Wrapf(ErrValidation, "unknown tenant: %d", tenantID)
+	}
+	if err != nil {
+		return errors.Wrap(err, "couldn't find tenant")
+	}
+	return nil
+}
+
+// Units usecase returns the units of a request of the tenant, ie: the units asked for if any, or
+// else the units of the match policy of the tenant
+func (tP TenantProcessor) Units(tenantID uint64, asked string) (DistanceUnits, error) {
+	if asked != "" {
+		return ParseDistanceUnits(asked)
+	}
+	policy, err := tenantPolicy(tP.TenantRepo, tP.Policy, tenantID)
+	if err != nil {
+		return "", errors.Wrap(err, "TenantProcessor couldn't get the match policy")
+	}
+	return policy.Units, nil
+}
+
+// tenantPolicy returns the match policy of a tenant, ie: the deployment policy overridden with the
+// policy of the tenant if it has one
+func tenantPolicy(repo TenantRepository, base MatchPolicy, tenantID uint64) (MatchPolicy, error) {
+	if tenantID == 0 {
+		return base, nil
+	}
+	t, err := repo.FindByID(tenantID)
+	if errors.Cause(err) == ErrNotFound {
+		return base, errors.Wrapf(ErrValidation, "unknown tenant: %d", tenantID)
+	}
+	if err != nil {
+		return base, errors.Wrap(err, "couldn't find tenant")
+	}
+	if t.Policy == "" {
+		return base, nil
+	}
+	return ParseMatchPolicy(base, []byte(t.Policy))
+}
+
+// tenantsPolicy is a match policy along with the tenants having it
+type tenantsPolicy struct {
+	Policy    MatchPolicy
+	TenantIDs []uint64
+}
+
+// tenantPolicies returns the tenants grouped by their match policy, in the order of their first
+// tenant, so that the records of the tenants having the same policy are filtered and scored
+// together. The matching is done in miles whatever the units of the policies, which only tell the
+// units of the responses, so the tenants differing by their units only share a group.
+func tenantPolicies(repo TenantRepository, base MatchPolicy, tenantIDs []uint64) ([]tenantsPolicy, error) {
+	groups := []tenantsPolicy{}
+	index := map[MatchPolicy]int{}
+	for _, tenantID := range tenantIDs {
+		policy, err := tenantPolicy(repo, base, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		policy.Units = Miles
+		i, ok := index[policy]
+		if !ok {
+			i = len(groups)
+			index[policy] = i
+			groups = append(groups, tenantsPolicy{Policy: policy})
+		}
+		groups[i].TenantIDs = append(groups[i].TenantIDs, tenantID)
+	}
+	return groups, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// DistanceUnits are the units the distances of the api are given and returned in. The matcher works
+// in miles all along, ie: the stored radii, the candidate queries and the scoring, so the distances
+// are only converted on their way in and out.
+type DistanceUnits string
+
+const (
+	Miles      DistanceUnits = "mi"
+	Kilometers DistanceUnits = "km"
+)
+
+// KilometersPerMile converts the miles used all over the matcher to kilometres
+const KilometersPerMile float32 = MetersPerMile / 1000
+
+// ParseDistanceUnits returns the units named by s, or an error caused by ErrValidation
+func ParseDistanceUnits(s string) (DistanceUnits, error) {
+	switch units := DistanceUnits(s); units {
+	case Miles, Kilometers:
+		return units, nil
+	default:
+		return "", errors.Wrapf(ErrValidation, "bad units: %q, must be %q or %q", s, Miles, Kilometers)
+	}
+}
+
+// ToMiles converts a distance given in the units to miles, the empty units being miles
+func (u DistanceUnits) ToMiles(distance float32) float32 {
+	if u == Kilometers {
+		return distance / KilometersPerMile
+	}
+	return distance
+}
+
+// FromMiles converts a distance in miles to the units, the empty units being miles
+func (u DistanceUnits) FromMiles(distance float32) float32 {
+	if u == Kilometers {
+		return distance * KilometersPerMile
+	}
+	return distance
+}
+
+// Label returns how the distances in the units read, eg: "3.4 km away"
+func (u DistanceUnits) Label() string {
+	if u == Kilometers {
+		return "km"
+	}
+	return "miles"
+}
+
+// In returns the requirement having its radii in the units instead of miles
+func (r Requirement) In(units DistanceUnits) Requirement {
+	r.SearchRadius = units.FromMiles(r.SearchRadius)
+	r.FullScoreRadius = units.FromMiles(r.FullScoreRadius)
+	return r
+}
+
+// In returns the match having its distances in the units instead of miles, the one of the reason of
+// its distance score included
+func (m MatchedRequirement) In(units DistanceUnits) MatchedRequirement {
+	m.Breakdown.Distance.Reason = DistanceReason(m.Distance, m.fullScoreRadius, units)
+	m.Requirement = m.Requirement.In(units)
+	m.Distance = units.FromMiles(m.Distance)
+	return m
+}
+
+// In returns the match having its distance in the units instead of miles, the one of the reason of
+// its distance score included
+func (m MatchedProperty) In(units DistanceUnits) MatchedProperty {
+	m.Breakdown.Distance.Reason = DistanceReason(m.Distance, m.fullScoreRadius, units)
+	m.Distance = units.FromMiles(m.Distance)
+	return m
+}
+
+// reqMatchesIn returns the matches having their distances in the units instead of miles
+func reqMatchesIn(matches []MatchedRequirement, units DistanceUnits) []MatchedRequirement {
+	converted := make([]MatchedRequirement, len(matches))
+	for i, m := range matches {
+		converted[i] = m.In(units)
+	}
+	return converted
+}
+
+// propMatchesIn returns the matches having their distances in the units instead of miles
+func propMatchesIn(matches []MatchedProperty, units DistanceUnits) []MatchedProperty {
+	converted := make([]MatchedProperty, len(matches))
+	for i, m := range matches {
+		converted[i] = m.In(units)
+	}
+	return converted
+}
+
+// inMiles returns the requirement request having its radii in miles instead of its Units
+func (p PropRequirement) inMiles() PropRequirement {
+	p.SearchRadius = p.Units.ToMiles(p.SearchRadius)
+	p.FullScoreRadius = p.Units.ToMiles(p.FullScoreRadius)
+	return p
+}